@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"go-voxblox/voxblox"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aler9/goroslib/pkg/msgs/sensor_msgs"
+)
+
+// pendingCloud is a PointCloud2 message waiting for a transform.
+type pendingCloud struct {
+	msg      *sensor_msgs.PointCloud2
+	received time.Time
+}
+
+// CloudQueue holds PointCloud2 messages whose transform is not available yet.
+// Clouds are retried when a new transform arrives and dropped once they have
+// waited longer than MaxWait.
+type CloudQueue struct {
+	MaxWait         time.Duration
+	MaxSize         int
+	Instrumentation *Instrumentation
+	sync.Mutex
+	clouds []pendingCloud
+}
+
+// NewCloudQueue returns a new CloudQueue.
+func NewCloudQueue(maxWait time.Duration, maxSize int) *CloudQueue {
+	return &CloudQueue{
+		MaxWait: maxWait,
+		MaxSize: maxSize,
+	}
+}
+
+// Len returns the number of pending clouds.
+// Thread-safe.
+func (q *CloudQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.clouds)
+}
+
+// push adds a cloud to the queue.
+// Drops the oldest cloud if the queue is full.
+// Thread-safe.
+func (q *CloudQueue) push(msg *sensor_msgs.PointCloud2, received time.Time) {
+	q.Lock()
+	defer q.Unlock()
+	q.Instrumentation.cloudReceived()
+	if len(q.clouds) >= q.MaxSize {
+		slog.Warn("Pending cloud queue full, dropping cloud", "stamp", q.clouds[0].msg.Header.Stamp)
+		q.Instrumentation.cloudDropped(dropReasonPendingQueueFull)
+		q.clouds = q.clouds[1:]
+	}
+	q.clouds = append(q.clouds, pendingCloud{msg: msg, received: received})
+}
+
+// readyCloud is a pending cloud with its resolved transform.
+type readyCloud struct {
+	msg       *sensor_msgs.PointCloud2
+	transform voxblox.Transform
+}
+
+// popReady removes and returns the clouds that have a transform available.
+// Clouds that can never be transformed or have waited too long are dropped.
+// Thread-safe.
+func (q *CloudQueue) popReady(tf *TransformListener, now time.Time) []readyCloud {
+	q.Lock()
+	defer q.Unlock()
+
+	var ready []readyCloud
+	pending := q.clouds[:0]
+	for _, cloud := range q.clouds {
+		transform, err := tf.LookupTransform(cloud.msg.Header.Stamp)
+		switch {
+		case err == nil:
+			ready = append(ready, readyCloud{msg: cloud.msg, transform: *transform})
+		case errors.Is(err, errTransformNotReady) && now.Sub(cloud.received) < q.MaxWait:
+			pending = append(pending, cloud)
+		default:
+			slog.Warn("Dropping cloud", "stamp", cloud.msg.Header.Stamp, "error", err)
+			q.Instrumentation.transformLookupFailed()
+		}
+	}
+	q.clouds = pending
+	return ready
+}
+
+// process submits every pending cloud that has a transform available to the pipeline.
+func (q *CloudQueue) process(tf *TransformListener, pipeline *Pipeline) {
+	for _, cloud := range q.popReady(tf, time.Now()) {
+		pipeline.Submit(cloud)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"go-voxblox/voxblox"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/quaternion"
+
+	"github.com/aler9/goroslib/pkg/msgs/sensor_msgs"
+	"github.com/aler9/goroslib/pkg/msgs/std_msgs"
+)
+
+func newStampedPointCloud2(stamp time.Time) *sensor_msgs.PointCloud2 {
+	return &sensor_msgs.PointCloud2{Header: std_msgs.Header{Stamp: stamp}}
+}
+
+func TestCloudQueueWaitsForTransform(t *testing.T) {
+	config := voxblox.Config{
+		Rotation:                  quaternion.Ident,
+		TransformMaxInterpolation: 100 * time.Millisecond,
+		TransformBufferDuration:   time.Second,
+	}
+	tf := NewTransformListener(&config)
+	queue := NewCloudQueue(time.Second, 2)
+	t0 := time.Unix(100, 0)
+	now := time.Now()
+
+	tf.addTransformStamped(newTransformStamped(t0, 0))
+	queue.push(newStampedPointCloud2(t0.Add(50*time.Millisecond)), now)
+	assert.Empty(t, queue.popReady(tf, now))
+	assert.Equal(t, 1, queue.Len())
+
+	tf.addTransformStamped(newTransformStamped(t0.Add(100*time.Millisecond), 1))
+	ready := queue.popReady(tf, now)
+	assert.Len(t, ready, 1)
+	assert.InDelta(t, 0.5, ready[0].transform.Translation[0], 1e-9)
+	assert.Equal(t, 0, queue.Len())
+}
+
+func TestCloudQueueDropsClouds(t *testing.T) {
+	config := voxblox.Config{
+		Rotation:                  quaternion.Ident,
+		TransformMaxInterpolation: 100 * time.Millisecond,
+		TransformBufferDuration:   time.Second,
+	}
+	tf := NewTransformListener(&config)
+	queue := NewCloudQueue(time.Second, 2)
+	t0 := time.Unix(100, 0)
+	now := time.Now()
+
+	// Oldest cloud is dropped when the queue is full.
+	queue.push(newStampedPointCloud2(t0), now)
+	queue.push(newStampedPointCloud2(t0.Add(time.Millisecond)), now)
+	queue.push(newStampedPointCloud2(t0.Add(2*time.Millisecond)), now)
+	assert.Equal(t, 2, queue.Len())
+
+	// Clouds are dropped after waiting longer than MaxWait.
+	assert.Empty(t, queue.popReady(tf, now.Add(2*time.Second)))
+	assert.Equal(t, 0, queue.Len())
+}
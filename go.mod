@@ -1,6 +1,6 @@
 module go-voxblox
 
-go 1.17
+go 1.21
 
 require (
 	github.com/aler9/goroslib v0.0.0-20220313141100-5edcd71dd1f4
@@ -4,35 +4,39 @@ import (
 	"fmt"
 	"go-voxblox/proto"
 	"go-voxblox/voxblox"
-	"log"
+	"log/slog"
 )
 
 // MeshServer is used to implement gRPC Server
 type MeshServer struct {
 	proto.UnimplementedMeshServiceServer
-	meshIntegrator *voxblox.MeshIntegrator
+	pipeline        *Pipeline
+	meshIntegrator  *voxblox.MeshIntegrator
+	Instrumentation *Instrumentation
 }
 
-// NewMeshServer creates a new MeshServer
-func NewMeshServer(meshIntegrator *voxblox.MeshIntegrator) *MeshServer {
+// NewMeshServer creates a new MeshServer meshing through the pipeline
+func NewMeshServer(pipeline *Pipeline) *MeshServer {
 	return &MeshServer{
-		meshIntegrator: meshIntegrator,
+		pipeline:       pipeline,
+		meshIntegrator: pipeline.MeshIntegrator,
 	}
 }
 
 // GetMeshBlocks streams the glTF binary data over gRPC
+// The mesh is updated on the pipeline goroutine, once the pipeline is stopped the last mesh is streamed.
 func (s MeshServer) GetMeshBlocks(
 	in *proto.GetMeshRequest,
 	srv proto.MeshService_GetMeshBlocksServer,
 ) error {
-	s.meshIntegrator.Integrate()
+	s.pipeline.UpdateMesh()
 	for _, meshBlock := range s.meshIntegrator.MeshLayer.GetBlocks() {
 		if !meshBlock.HasData() {
 			continue
 		}
-		buf, err := meshBlock.Gltf()
+		buf, err := meshBlock.GltfSplit(s.meshIntegrator.Config.MeshMaxPrimitiveVertices)
 		if err != nil {
-			log.Print(err)
+			slog.Error("Failed to encode mesh block", "block", meshBlock.String(), "error", err)
 			continue
 		}
 		err = srv.Send(&proto.GetMeshResult{
@@ -41,9 +45,10 @@ func (s MeshServer) GetMeshBlocks(
 		})
 		meshBlock.Clear()
 		if err != nil {
-			log.Print(err)
+			slog.Error("Failed to send mesh block", "block", meshBlock.String(), "error", err)
 			return err
 		}
+		s.Instrumentation.meshSent(buf.Len())
 
 	}
 	return nil
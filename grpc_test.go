@@ -47,7 +47,10 @@ func TestGetMeshBlocks(t *testing.T) {
 	tsdfIntegrator := voxblox.NewFastTsdfIntegrator(&config, tsdfLayer)
 	meshLayer := voxblox.NewMeshLayer(tsdfLayer)
 	meshIntegrator := voxblox.NewMeshIntegrator(config, tsdfLayer, meshLayer)
-	meshServer := NewMeshServer(&meshIntegrator)
+	pipeline := NewPipeline(config, tsdfIntegrator, meshIntegrator)
+	pipeline.Start()
+	defer pipeline.Stop()
+	meshServer := NewMeshServer(pipeline)
 
 	lis = bufconn.Listen(bufSize)
 	s := grpc.NewServer()
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"go-voxblox/metrics"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Reasons a cloud is dropped.
+const (
+	dropReasonPendingQueueFull     = "pending_queue_full"
+	dropReasonTransform            = "transform"
+	dropReasonIntegrationQueueFull = "integration_queue_full"
+)
+
+// Instrumentation records the node metrics.
+// All methods are no-ops on a nil Instrumentation.
+type Instrumentation struct {
+	Registry                *metrics.Registry
+	durations               *metrics.HistogramVec
+	cloudsReceived          *metrics.Counter
+	cloudsDropped           *metrics.CounterVec
+	transformLookupFailures *metrics.Counter
+	meshBytesSent           *metrics.Counter
+}
+
+// blockCounter is a TSDF Layer reporting its number of blocks.
+type blockCounter interface {
+	GetBlockCount() int
+}
+
+// NewInstrumentation returns a new Instrumentation reporting the block count of the TSDF Layer.
+func NewInstrumentation(tsdfLayer blockCounter) *Instrumentation {
+	registry := metrics.NewRegistry()
+	registry.NewGaugeFunc(
+		"voxblox_tsdf_blocks",
+		"Number of allocated TSDF blocks.",
+		func() float64 { return float64(tsdfLayer.GetBlockCount()) },
+	)
+	return &Instrumentation{
+		Registry: registry,
+		durations: registry.NewHistogramVec(
+			"voxblox_duration_seconds",
+			"Duration of the timed operations.",
+			"operation",
+			metrics.DefaultDurationBuckets,
+		),
+		cloudsReceived: registry.NewCounter(
+			"voxblox_clouds_received_total",
+			"Number of PointCloud2 messages received.",
+		),
+		cloudsDropped: registry.NewCounterVec(
+			"voxblox_clouds_dropped_total",
+			"Number of clouds dropped before integration.",
+			"reason",
+		),
+		transformLookupFailures: registry.NewCounter(
+			"voxblox_transform_lookup_failures_total",
+			"Number of clouds whose transform could not be looked up.",
+		),
+		meshBytesSent: registry.NewCounter(
+			"voxblox_mesh_bytes_sent_total",
+			"Number of glTF bytes sent by the gRPC mesh server.",
+		),
+	}
+}
+
+// ObserveDuration records the duration of a timed operation.
+// Implements voxblox.DurationObserver.
+func (i *Instrumentation) ObserveDuration(name string, elapsed time.Duration) {
+	if i == nil {
+		return
+	}
+	i.durations.With(name).Observe(elapsed.Seconds())
+}
+
+// cloudReceived counts a received cloud.
+func (i *Instrumentation) cloudReceived() {
+	if i == nil {
+		return
+	}
+	i.cloudsReceived.Inc()
+}
+
+// cloudDropped counts a dropped cloud.
+func (i *Instrumentation) cloudDropped(reason string) {
+	if i == nil {
+		return
+	}
+	i.cloudsDropped.With(reason).Inc()
+}
+
+// transformLookupFailed counts a cloud dropped because its transform lookup failed.
+func (i *Instrumentation) transformLookupFailed() {
+	if i == nil {
+		return
+	}
+	i.transformLookupFailures.Inc()
+	i.cloudDropped(dropReasonTransform)
+}
+
+// meshSent counts the bytes of a mesh block sent to a client.
+func (i *Instrumentation) meshSent(bytes int) {
+	if i == nil {
+		return
+	}
+	i.meshBytesSent.Add(float64(bytes))
+}
+
+// serveMetrics serves the metrics at /metrics on the given address.
+// Returns a function that shuts the server down.
+func serveMetrics(address string, registry *metrics.Registry) func() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Failed to serve metrics", "address", address, "error", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Failed to shut down metrics server", "error", err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"go-voxblox/voxblox"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/quaternion"
+)
+
+func metricsText(t *testing.T, instrumentation *Instrumentation) string {
+	var text strings.Builder
+	assert.NoError(t, instrumentation.Registry.WriteText(&text))
+	return text.String()
+}
+
+func TestInstrumentationCloudQueue(t *testing.T) {
+	config := voxblox.Config{
+		Rotation:                  quaternion.Ident,
+		TransformMaxInterpolation: 100 * time.Millisecond,
+		TransformBufferDuration:   time.Second,
+	}
+	tsdfLayer := voxblox.NewTsdfLayer(0.1, 8)
+	instrumentation := NewInstrumentation(tsdfLayer)
+	tf := NewTransformListener(&config)
+	queue := NewCloudQueue(time.Second, 1)
+	queue.Instrumentation = instrumentation
+	t0 := time.Unix(100, 0)
+	now := time.Now()
+
+	queue.push(newStampedPointCloud2(t0), now)
+	queue.push(newStampedPointCloud2(t0.Add(time.Millisecond)), now)
+	assert.Empty(t, queue.popReady(tf, now.Add(2*time.Second)))
+	instrumentation.ObserveDuration("Integrate Fast", 3*time.Millisecond)
+
+	text := metricsText(t, instrumentation)
+	assert.Contains(t, text, "voxblox_tsdf_blocks 0\n")
+	assert.Contains(t, text, "voxblox_clouds_received_total 2\n")
+	assert.Contains(t, text, `voxblox_clouds_dropped_total{reason="pending_queue_full"} 1`+"\n")
+	assert.Contains(t, text, `voxblox_clouds_dropped_total{reason="transform"} 1`+"\n")
+	assert.Contains(t, text, "voxblox_transform_lookup_failures_total 1\n")
+	assert.Contains(t, text, `voxblox_duration_seconds_count{operation="Integrate Fast"} 1`+"\n")
+}
+
+func TestInstrumentationPipeline(t *testing.T) {
+	pipeline, _ := newTestPipeline(voxblox.DropPolicyLatest)
+	instrumentation := NewInstrumentation(pipeline.MeshIntegrator.TsdfLayer)
+	pipeline.Instrumentation = instrumentation
+
+	for x := 1.0; x <= 3; x++ {
+		pipeline.Submit(newTestReadyCloud(x))
+	}
+	pipeline.Stop()
+
+	text := metricsText(t, instrumentation)
+	assert.Contains(t, text, `voxblox_clouds_dropped_total{reason="integration_queue_full"} 1`+"\n")
+}
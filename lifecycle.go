@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-voxblox/voxblox"
+	"path/filepath"
+	"sync"
+)
+
+// Lifecycle coordinates a graceful shutdown.
+// Work started with Begin is drained before Shutdown returns.
+type Lifecycle struct {
+	sync.Mutex
+	stopping bool
+	inFlight sync.WaitGroup
+	stops    []func()
+}
+
+// NewLifecycle returns a new Lifecycle.
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Begin registers a unit of in-flight work.
+// Returns false if the Lifecycle is shutting down and the work must be skipped.
+// Every successful Begin must be followed by End.
+// Thread-safe.
+func (l *Lifecycle) Begin() bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.stopping {
+		return false
+	}
+	l.inFlight.Add(1)
+	return true
+}
+
+// End marks a unit of in-flight work as done.
+func (l *Lifecycle) End() {
+	l.inFlight.Done()
+}
+
+// Do runs f as in-flight work unless the Lifecycle is shutting down.
+func (l *Lifecycle) Do(f func()) {
+	if !l.Begin() {
+		return
+	}
+	defer l.End()
+	f()
+}
+
+// OnStop registers a function that stops a source of work.
+// Functions are called in registration order on Shutdown.
+// Thread-safe.
+func (l *Lifecycle) OnStop(f func()) {
+	l.Lock()
+	defer l.Unlock()
+	l.stops = append(l.stops, f)
+}
+
+// Shutdown rejects new work, stops all registered sources of work and
+// waits for in-flight work to finish.
+// Thread-safe.
+func (l *Lifecycle) Shutdown() {
+	l.Lock()
+	l.stopping = true
+	stops := l.stops
+	l.stops = nil
+	l.Unlock()
+
+	for _, stop := range stops {
+		stop()
+	}
+	l.inFlight.Wait()
+}
+
+// exportMap re-meshes the whole map and writes the mesh and TSDF Layer to the output path.
+// Both exports are attempted even if one of them fails.
+func exportMap(meshIntegrator *voxblox.MeshIntegrator, outputPath string) error {
+	meshIntegrator.IntegrateAll()
+
+	meshErr := voxblox.WriteMeshLayerToObjFiles(meshIntegrator.MeshLayer, outputPath)
+	if meshErr == nil {
+		meshErr = voxblox.WriteGlobalMeshToObjFile(
+			meshIntegrator.MeshLayer,
+			filepath.Join(outputPath, "mesh.obj"),
+		)
+	}
+	if meshErr != nil {
+		meshErr = fmt.Errorf("failed to write mesh: %w", meshErr)
+	}
+	mapErr := voxblox.WriteTsdfLayer(
+		getSavedLayer(meshIntegrator),
+		filepath.Join(outputPath, "map.tsdf"),
+	)
+	if mapErr != nil {
+		mapErr = fmt.Errorf("failed to write map: %w", mapErr)
+	}
+
+	return errors.Join(meshErr, mapErr)
+}
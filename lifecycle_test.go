@@ -0,0 +1,84 @@
+package main
+
+import (
+	"go-voxblox/voxblox"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycleDrainsInFlightWork(t *testing.T) {
+	lifecycle := NewLifecycle()
+	stopped := false
+	lifecycle.OnStop(func() { stopped = true })
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := false
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		lifecycle.Do(func() {
+			close(started)
+			<-release
+			finished = true
+		})
+	}()
+	<-started
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	lifecycle.Shutdown()
+	assert.True(t, stopped)
+	assert.True(t, finished)
+
+	// Work is rejected after shutdown.
+	ran := false
+	lifecycle.Do(func() { ran = true })
+	assert.False(t, ran)
+	wg.Wait()
+}
+
+func TestExportMap(t *testing.T) {
+	config, err := voxblox.ReadConfig("testdata/test.yaml")
+	assert.NoError(t, err)
+	tsdfLayer := voxblox.NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	tsdfIntegrator := voxblox.NewSimpleTsdfIntegrator(&config, tsdfLayer)
+	meshLayer := voxblox.NewMeshLayer(tsdfLayer)
+	meshIntegrator := voxblox.NewMeshIntegrator(config, tsdfLayer, meshLayer)
+	tsdfIntegrator.IntegratePointCloud(
+		voxblox.Transform{Rotation: [4]float64{0, 0, 0, 1}},
+		newTestPlaneCloud(),
+	)
+
+	outputPath := filepath.Join(t.TempDir(), "output")
+	assert.NoError(t, exportMap(meshIntegrator, outputPath))
+	_, err = os.Stat(filepath.Join(outputPath, "map.tsdf"))
+	assert.NoError(t, err)
+	objFiles, _ := filepath.Glob(filepath.Join(outputPath, "*.obj"))
+	assert.NotEmpty(t, objFiles)
+	_, err = os.Stat(filepath.Join(outputPath, "mesh.obj"))
+	assert.NoError(t, err)
+
+	// Exporting to a path blocked by a file fails.
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	assert.NoError(t, os.WriteFile(blocked, nil, 0o644))
+	err = exportMap(meshIntegrator, blocked)
+	assert.Error(t, err)
+	// Both failures are kept.
+	joined, ok := err.(interface{ Unwrap() []error })
+	assert.True(t, ok)
+	if ok {
+		assert.Len(t, joined.Unwrap(), 2)
+	}
+	var pathErr *fs.PathError
+	assert.ErrorAs(t, err, &pathErr)
+}
@@ -4,109 +4,243 @@ import (
 	"fmt"
 	"go-voxblox/proto"
 	"go-voxblox/voxblox"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 
 	"github.com/aler9/goroslib"
 	"github.com/aler9/goroslib/pkg/msgs/geometry_msgs"
+	"github.com/aler9/goroslib/pkg/msgs/nav_msgs"
 	"github.com/aler9/goroslib/pkg/msgs/sensor_msgs"
 )
 
 // onPointCloud2 is called when a PointCloud2 message is received.
-// Converts the message to a Voxblox PointCloud and integrates it.
+// Queues the message until its transform is available and submits
+// every cloud that is ready to the integration pipeline.
 func onPointCloud2(
 	msg *sensor_msgs.PointCloud2,
-	tsdfIntegrator voxblox.TsdfIntegrator,
+	pipeline *Pipeline,
 	tf *TransformListener,
+	clouds *CloudQueue,
 ) {
-	transform, err := tf.LookupTransform(msg.Header.Stamp)
+	clouds.push(msg, time.Now())
+	clouds.process(tf, pipeline)
+}
+
+// subscribe creates a subscriber and stops it when the Lifecycle shuts down.
+// Does nothing if the topic is empty.
+func subscribe(n *goroslib.Node, lifecycle *Lifecycle, topic string, callback interface{}) error {
+	if topic == "" {
+		return nil
+	}
+	sub, err := goroslib.NewSubscriber(goroslib.SubscriberConf{
+		Node:     n,
+		Topic:    topic,
+		Callback: callback,
+	})
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	voxbloxPointCloud := PointCloud2ToPointCloud(msg)
-	tsdfIntegrator.IntegratePointCloud(*transform, voxbloxPointCloud)
+	lifecycle.OnStop(func() { sub.Close() })
+	return nil
 }
 
 func main() {
+	if err := run(); err != nil {
+		slog.Error("Exiting with error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the node and blocks until SIGINT or SIGTERM.
+// Returns an error if startup or the final export fails.
+func run() error {
 	config, err := voxblox.ReadConfig("voxblox.yaml")
 	if err != nil {
-		panic(err)
+		return err
 	}
 
+	// Logger shared by the node and the library.
+	config.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: config.LogLevel}))
+	slog.SetDefault(config.Logger)
+
 	// Create a node and connect to the master
 	n, err := goroslib.NewNode(goroslib.NodeConf{
 		Name:          "go-voxblox",
 		MasterAddress: config.RosMaster,
 	})
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer n.Close()
 
-	// Transformer
-	tfListener := NewTransformListener(voxblox.Transform{
-		Rotation:    config.Rotation,
-		Translation: config.Translation,
-	})
+	lifecycle := NewLifecycle()
+	// Stop anything that was started if startup fails.
+	defer lifecycle.Shutdown()
 
 	// Integrators
-	tsdfLayer := voxblox.NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
-	tsdfIntegrator := voxblox.NewFastTsdfIntegrator(&config, tsdfLayer)
-	meshLayer := voxblox.NewMeshLayer(tsdfLayer)
-	meshIntegrator := voxblox.NewMeshIntegrator(config, tsdfLayer, meshLayer)
+	var tsdfLayer blockCounter
+	var tsdfIntegrator voxblox.TsdfIntegrator
+	var meshIntegrator *voxblox.MeshIntegrator
+	if config.ResolutionLevels > 1 {
+		multiResolutionLayer := voxblox.NewMultiResolutionTsdfLayer(
+			config.VoxelSize,
+			config.VoxelsPerSide,
+			config.ResolutionLevels,
+			config.ResolutionLevelDistance,
+		)
+		tsdfLayer = multiResolutionLayer
+		tsdfIntegrator = voxblox.NewMultiResolutionTsdfIntegrator(&config, multiResolutionLayer)
+		meshLayer := voxblox.NewMeshLayer(multiResolutionLayer.Levels[0])
+		meshIntegrator = voxblox.NewMultiResolutionMeshIntegrator(config, multiResolutionLayer, meshLayer)
+	} else {
+		singleLayer := voxblox.NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+		tsdfLayer = singleLayer
+		fastIntegrator := voxblox.NewFastTsdfIntegrator(&config, singleLayer)
+		tsdfIntegrator = fastIntegrator
+		meshLayer := voxblox.NewMeshLayer(singleLayer)
+		meshIntegrator = voxblox.NewMeshIntegrator(config, singleLayer, meshLayer)
+		if config.SemanticMapping {
+			semanticLayer := voxblox.NewSemanticLayer(singleLayer)
+			fastIntegrator.SemanticLayer = semanticLayer
+			meshIntegrator.SemanticLayer = semanticLayer
+		}
+		if config.InstanceSegmentation {
+			instanceLayer := voxblox.NewInstanceLayer(singleLayer)
+			fastIntegrator.InstanceLayer = instanceLayer
+			meshIntegrator.InstanceLayer = instanceLayer
+		}
+		if len(config.VoxelChannels) > 0 {
+			channelLayer := voxblox.NewChannelLayer(singleLayer, config.VoxelChannels)
+			fastIntegrator.ChannelLayer = channelLayer
+			meshIntegrator.ChannelLayer = channelLayer
+		}
+		if config.ObservationTracking {
+			observationLayer := voxblox.NewObservationLayer(singleLayer)
+			fastIntegrator.ObservationLayer = observationLayer
+			meshIntegrator.ObservationLayer = observationLayer
+		}
+	}
 
-	// PointCloud2 subscriber
-	sub, err := goroslib.NewSubscriber(goroslib.SubscriberConf{
-		Node:  n,
-		Topic: config.TopicPointCloud2,
-		Callback: func(msg *sensor_msgs.PointCloud2) {
-			onPointCloud2(msg, tsdfIntegrator, tfListener)
-		},
+	// Metrics
+	instrumentation := NewInstrumentation(tsdfLayer)
+	voxblox.SetDurationObserver(instrumentation)
+	if config.MetricsAddress != "" {
+		lifecycle.OnStop(serveMetrics(config.MetricsAddress, instrumentation.Registry))
+	}
+
+	// Transformer
+	tfListener := NewTransformListener(&config)
+	cloudQueue := NewCloudQueue(config.PendingCloudMaxWait, config.PendingCloudQueueSize)
+	cloudQueue.Instrumentation = instrumentation
+
+	pipeline := NewPipeline(config, tsdfIntegrator, meshIntegrator)
+	pipeline.Instrumentation = instrumentation
+	pipeline.Start()
+
+	// Subscribers
+	err = subscribe(n, lifecycle, config.TopicPointCloud2, func(msg *sensor_msgs.PointCloud2) {
+		lifecycle.Do(func() {
+			onPointCloud2(msg, pipeline, tfListener, cloudQueue)
+		})
 	})
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer sub.Close()
-
-	// Transform subscriber
-	sub, err = goroslib.NewSubscriber(goroslib.SubscriberConf{
-		Node:  n,
-		Topic: config.TopicTransform,
-		Callback: func(msg *geometry_msgs.TransformStamped) {
-			tfListener.addTransform(msg)
-		},
+	err = subscribe(n, lifecycle, config.TopicTransform, func(msg *geometry_msgs.TransformStamped) {
+		lifecycle.Do(func() {
+			tfListener.addTransformStamped(msg)
+			cloudQueue.process(tfListener, pipeline)
+		})
 	})
 	if err != nil {
-		panic(err)
+		return err
+	}
+	err = subscribe(n, lifecycle, config.TopicOdometry, func(msg *nav_msgs.Odometry) {
+		lifecycle.Do(func() {
+			tfListener.addOdometry(msg)
+			cloudQueue.process(tfListener, pipeline)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	err = subscribe(n, lifecycle, config.TopicPose, func(msg *geometry_msgs.PoseStamped) {
+		lifecycle.Do(func() {
+			tfListener.addPoseStamped(msg)
+			cloudQueue.process(tfListener, pipeline)
+		})
+	})
+	if err != nil {
+		return err
 	}
-	defer sub.Close()
+
+	// Drain the integration pipeline once the subscribers are stopped.
+	lifecycle.OnStop(pipeline.Stop)
+
+	// ROS map publishers
+	mapPublisher, err := NewMapPublisher(n, config, meshIntegrator)
+	if err != nil {
+		return err
+	}
+	defer mapPublisher.Close()
+	publishTicker := time.NewTicker(config.PublishPeriod)
+	lifecycle.OnStop(publishTicker.Stop)
+	go func() {
+		for range publishTicker.C {
+			lifecycle.Do(mapPublisher.Publish)
+		}
+	}()
+
+	// ROS map services
+	mapServices, err := NewMapServices(n, config, pipeline, mapPublisher)
+	if err != nil {
+		return err
+	}
+	lifecycle.OnStop(mapServices.Close)
 
 	// gRPC mesh server
-	meshServer := NewMeshServer(&meshIntegrator)
+	meshServer := NewMeshServer(pipeline)
+	meshServer.Instrumentation = instrumentation
 	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", 50051))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	grpcServer := grpc.NewServer()
 	proto.RegisterMeshServiceServer(grpcServer, meshServer)
 	go func() {
 		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatalf("Failed to serve gRPC server: %v", err)
+			slog.Error("Failed to serve gRPC server", "error", err)
+			os.Exit(1)
 		}
 	}()
-	defer grpcServer.Stop()
+	lifecycle.OnStop(grpcServer.GracefulStop)
 
-	// Wait for CTRL-C
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	// Wait for CTRL-C or SIGTERM
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	slog.Info("Shutting down", "signal", sig)
 
-	// TODO: This is temporary.
-	meshIntegrator.Integrate()
-	voxblox.WriteMeshLayerToObjFiles(meshLayer, "output")
+	// A second signal aborts the graceful shutdown.
+	go func() {
+		<-c
+		slog.Warn("Received second signal, exiting without export")
+		os.Exit(1)
+	}()
+
+	// Stop subscribers and servers and drain in-flight integration.
+	lifecycle.Shutdown()
+
+	// Flush the final mesh and map.
+	if err := exportMap(meshIntegrator, config.OutputPath); err != nil {
+		return err
+	}
+	slog.Info("Wrote mesh and map", "path", config.OutputPath)
+	return nil
 }
@@ -0,0 +1,314 @@
+// Package metrics is a minimal metrics registry exposed in the Prometheus text format.
+// All metric types are safe for concurrent use and their methods are no-ops on nil receivers.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultDurationBuckets are histogram buckets in seconds suited to per-cloud latencies.
+var DefaultDurationBuckets = []float64{
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5,
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	bits uint64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by the given non-negative value.
+func (c *Counter) Add(v float64) {
+	if c == nil {
+		return
+	}
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&c.bits, old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current value.
+func (c *Counter) Value() float64 {
+	if c == nil {
+		return 0
+	}
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	buckets []float64
+	sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Observe adds an observation.
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	for i, upperBound := range h.buckets {
+		if v <= upperBound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	if h == nil {
+		return 0
+	}
+	h.Lock()
+	defer h.Unlock()
+	return h.count
+}
+
+// vec holds one metric per value of a single label.
+type vec struct {
+	labelName string
+	sync.Mutex
+	children map[string]interface{}
+	create   func() interface{}
+}
+
+func (v *vec) with(labelValue string) interface{} {
+	v.Lock()
+	defer v.Unlock()
+	child, ok := v.children[labelValue]
+	if !ok {
+		child = v.create()
+		v.children[labelValue] = child
+	}
+	return child
+}
+
+// sorted returns the label values and metrics sorted by label value.
+func (v *vec) sorted() ([]string, []interface{}) {
+	v.Lock()
+	defer v.Unlock()
+	labelValues := make([]string, 0, len(v.children))
+	for labelValue := range v.children {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+	children := make([]interface{}, len(labelValues))
+	for i, labelValue := range labelValues {
+		children[i] = v.children[labelValue]
+	}
+	return labelValues, children
+}
+
+// CounterVec is a set of counters partitioned by one label.
+type CounterVec struct {
+	vec
+}
+
+// With returns the counter for the given label value, creating it if needed.
+func (v *CounterVec) With(labelValue string) *Counter {
+	if v == nil {
+		return nil
+	}
+	return v.with(labelValue).(*Counter)
+}
+
+// HistogramVec is a set of histograms partitioned by one label.
+type HistogramVec struct {
+	vec
+}
+
+// With returns the histogram for the given label value, creating it if needed.
+func (v *HistogramVec) With(labelValue string) *Histogram {
+	if v == nil {
+		return nil
+	}
+	return v.with(labelValue).(*Histogram)
+}
+
+// family is a named metric with its help text.
+type family struct {
+	name       string
+	help       string
+	metricType string
+	metric     interface{}
+}
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	sync.Mutex
+	families []family
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name, help, metricType string, metric interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			panic(fmt.Sprintf("metric %s registered twice", name))
+		}
+	}
+	r.families = append(r.families, family{name, help, metricType, metric})
+}
+
+// NewCounter registers a new Counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// NewCounterVec registers a new CounterVec partitioned by labelName.
+func (r *Registry) NewCounterVec(name, help, labelName string) *CounterVec {
+	v := &CounterVec{vec{
+		labelName: labelName,
+		children:  make(map[string]interface{}),
+		create:    func() interface{} { return &Counter{} },
+	}}
+	r.register(name, help, "counter", v)
+	return v
+}
+
+// NewHistogramVec registers a new HistogramVec partitioned by labelName.
+func (r *Registry) NewHistogramVec(name, help, labelName string, buckets []float64) *HistogramVec {
+	v := &HistogramVec{vec{
+		labelName: labelName,
+		children:  make(map[string]interface{}),
+		create:    func() interface{} { return newHistogram(buckets) },
+	}}
+	r.register(name, help, "histogram", v)
+	return v
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are written.
+type GaugeFunc func() float64
+
+// NewGaugeFunc registers a gauge that calls f for its value.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(name, help, "gauge", GaugeFunc(f))
+}
+
+// formatFloat formats a value as expected by the Prometheus text format.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabelValue escapes a label value for the Prometheus text format.
+func escapeLabelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// labels formats label pairs as {a="b",c="d"}.
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escapeLabelValue(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// writeHistogram writes the bucket, sum and count series of a histogram.
+func writeHistogram(w io.Writer, name string, h *Histogram, labelPairs ...string) {
+	h.Lock()
+	defer h.Unlock()
+	for i, upperBound := range h.buckets {
+		pairs := append(append([]string{}, labelPairs...), "le", formatFloat(upperBound))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(pairs...), h.counts[i])
+	}
+	pairs := append(append([]string{}, labelPairs...), "le", "+Inf")
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(pairs...), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels(labelPairs...), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels(labelPairs...), h.count)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(writer io.Writer) error {
+	r.Lock()
+	families := make([]family, len(r.families))
+	copy(families, r.families)
+	r.Unlock()
+
+	w := bufio.NewWriter(writer)
+	for _, f := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.metricType)
+		switch m := f.metric.(type) {
+		case *Counter:
+			fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(m.Value()))
+		case GaugeFunc:
+			fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(m()))
+		case *CounterVec:
+			labelValues, children := m.sorted()
+			for i, child := range children {
+				fmt.Fprintf(
+					w,
+					"%s%s %s\n",
+					f.name,
+					labels(m.labelName, labelValues[i]),
+					formatFloat(child.(*Counter).Value()),
+				)
+			}
+		case *HistogramVec:
+			labelValues, children := m.sorted()
+			for i, child := range children {
+				writeHistogram(w, f.name, child.(*Histogram), m.labelName, labelValues[i])
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.WriteText(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
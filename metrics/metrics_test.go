@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilMetrics(t *testing.T) {
+	var counter *Counter
+	counter.Inc()
+	assert.Equal(t, 0.0, counter.Value())
+
+	var histogram *Histogram
+	histogram.Observe(1)
+	assert.Equal(t, uint64(0), histogram.Count())
+
+	var counterVec *CounterVec
+	counterVec.With("a").Inc()
+	var histogramVec *HistogramVec
+	histogramVec.With("a").Observe(1)
+}
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("test_total", "A counter.")
+	counterVec := registry.NewCounterVec("test_by_reason_total", "A counter vec.", "reason")
+	histogramVec := registry.NewHistogramVec("test_seconds", "A histogram.", "op", []float64{0.1, 1})
+	registry.NewGaugeFunc("test_gauge", "A gauge.", func() float64 { return 42 })
+
+	counter.Add(2)
+	counterVec.With("b").Inc()
+	counterVec.With("a").Add(3)
+	histogramVec.With(`x"y`).Observe(0.5)
+	histogramVec.With(`x"y`).Observe(2)
+
+	var text strings.Builder
+	assert.NoError(t, registry.WriteText(&text))
+	assert.Equal(t, `# HELP test_total A counter.
+# TYPE test_total counter
+test_total 2
+# HELP test_by_reason_total A counter vec.
+# TYPE test_by_reason_total counter
+test_by_reason_total{reason="a"} 3
+test_by_reason_total{reason="b"} 1
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="x\"y",le="0.1"} 0
+test_seconds_bucket{op="x\"y",le="1"} 1
+test_seconds_bucket{op="x\"y",le="+Inf"} 2
+test_seconds_sum{op="x\"y"} 2.5
+test_seconds_count{op="x\"y"} 2
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 42
+`, text.String())
+}
+
+func TestRegisterTwice(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("test_total", "A counter.")
+	assert.Panics(t, func() { registry.NewCounter("test_total", "A counter.") })
+}
+
+func TestServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("test_total", "A counter.").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, recorder.Body.String(), "test_total 1\n")
+}
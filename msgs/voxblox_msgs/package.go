@@ -0,0 +1,48 @@
+// Package voxblox_msgs contains goroslib definitions of the upstream voxblox_msgs messages.
+package voxblox_msgs
+
+import (
+	"github.com/aler9/goroslib/pkg/msg"
+	"github.com/aler9/goroslib/pkg/msgs/std_msgs"
+)
+
+// MeshBlock is a voxblox_msgs/MeshBlock.
+// Vertices are quantized relative to the block origin and every three
+// consecutive vertices form a triangle.
+type MeshBlock struct {
+	msg.Package `ros:"voxblox_msgs"`
+	Index       [3]int64
+	X           []uint16
+	Y           []uint16
+	Z           []uint16
+	R           []uint8
+	G           []uint8
+	B           []uint8
+}
+
+// Mesh is a voxblox_msgs/Mesh.
+// The field order defines the wire layout and MD5 sum and must match upstream.
+type Mesh struct {
+	msg.Package     `ros:"voxblox_msgs"`
+	Header          std_msgs.Header
+	BlockEdgeLength float32
+	MeshBlocks      []MeshBlock
+}
+
+// FilePathReq is the request of a voxblox_msgs/FilePath service.
+type FilePathReq struct {
+	msg.Package `ros:"voxblox_msgs"`
+	FilePath    string
+}
+
+// FilePathRes is the response of a voxblox_msgs/FilePath service.
+type FilePathRes struct {
+	msg.Package `ros:"voxblox_msgs"`
+}
+
+// FilePath is a voxblox_msgs/FilePath service.
+type FilePath struct {
+	msg.Package `ros:"voxblox_msgs"`
+	FilePathReq
+	FilePathRes
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"go-voxblox/voxblox"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errPipelineStopped is returned for operations requested once the pipeline is stopped.
+var errPipelineStopped = errors.New("pipeline is stopped")
+
+// Pipeline integrates clouds on a single goroutine fed by a bounded queue.
+// The mesh is updated on the same goroutine every MeshUpdatePeriod so
+// meshing never races with a cloud integration started by the pipeline.
+// Other map operations are run on the same goroutine with Run.
+// Integrators implementing voxblox.WeightDecayer have their weights decayed before each mesh update.
+type Pipeline struct {
+	TsdfIntegrator   voxblox.TsdfIntegrator
+	MeshIntegrator   *voxblox.MeshIntegrator
+	DropPolicy       string
+	MeshUpdatePeriod time.Duration
+	// Channels are the point cloud fields converted into voxel channels.
+	Channels        []string
+	Instrumentation *Instrumentation
+	sync.RWMutex
+	start    sync.Once
+	stopped  bool
+	queue    chan readyCloud
+	commands chan func()
+	done     chan struct{}
+	dropped  uint64
+}
+
+// NewPipeline returns a new Pipeline.
+// Call Start to begin integrating.
+func NewPipeline(
+	config voxblox.Config,
+	tsdfIntegrator voxblox.TsdfIntegrator,
+	meshIntegrator *voxblox.MeshIntegrator,
+) *Pipeline {
+	return &Pipeline{
+		TsdfIntegrator:   tsdfIntegrator,
+		MeshIntegrator:   meshIntegrator,
+		DropPolicy:       config.IntegrationDropPolicy,
+		MeshUpdatePeriod: config.MeshUpdatePeriod,
+		Channels:         config.VoxelChannels,
+		queue:            make(chan readyCloud, config.IntegrationQueueSize),
+		commands:         make(chan func()),
+		done:             make(chan struct{}),
+	}
+}
+
+// Start starts the integration goroutine.
+// Thread-safe.
+func (p *Pipeline) Start() {
+	p.start.Do(func() { go p.run() })
+}
+
+// run integrates queued clouds, runs commands and updates the mesh until the queue is closed.
+func (p *Pipeline) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.MeshUpdatePeriod)
+	defer ticker.Stop()
+	lastDecay := time.Now()
+	for {
+		select {
+		case cloud, ok := <-p.queue:
+			if !ok {
+				return
+			}
+			p.integrate(cloud)
+		case command := <-p.commands:
+			command()
+		case now := <-ticker.C:
+			if decayer, ok := p.TsdfIntegrator.(voxblox.WeightDecayer); ok {
+				decayer.DecayWeights(now.Sub(lastDecay))
+			}
+			lastDecay = now
+			p.MeshIntegrator.Integrate()
+		}
+	}
+}
+
+// integrate converts a cloud and integrates it into the TSDF Layer.
+func (p *Pipeline) integrate(cloud readyCloud) {
+	pointCloud := PointCloud2ToPointCloud(cloud.msg, p.Channels...)
+	p.TsdfIntegrator.IntegratePointCloud(cloud.transform, pointCloud)
+}
+
+// Submit queues a cloud for integration.
+// With the latest-wins policy the oldest queued cloud is dropped when the
+// queue is full, otherwise Submit blocks until there is room.
+// Returns false if the pipeline is stopped.
+// Thread-safe.
+func (p *Pipeline) Submit(cloud readyCloud) bool {
+	p.RLock()
+	defer p.RUnlock()
+	if p.stopped {
+		return false
+	}
+
+	if p.DropPolicy == voxblox.DropPolicyBlock {
+		p.queue <- cloud
+		return true
+	}
+
+	for {
+		select {
+		case p.queue <- cloud:
+			return true
+		default:
+		}
+		// Queue full, drop the oldest cloud.
+		select {
+		case oldest := <-p.queue:
+			atomic.AddUint64(&p.dropped, 1)
+			p.Instrumentation.cloudDropped(dropReasonIntegrationQueueFull)
+			slog.Warn("Integration queue full, dropping cloud", "stamp", oldest.msg.Header.Stamp)
+		default:
+		}
+	}
+}
+
+// Run runs the command on the integration goroutine, between cloud integrations and mesh updates,
+// and waits for it to return.
+// Returns false without running the command if the pipeline is stopped.
+// Thread-safe.
+func (p *Pipeline) Run(command func()) bool {
+	done := make(chan struct{})
+	p.RLock()
+	if p.stopped {
+		p.RUnlock()
+		return false
+	}
+	p.commands <- func() {
+		defer close(done)
+		command()
+	}
+	p.RUnlock()
+	<-done
+	return true
+}
+
+// UpdateMesh re-meshes the updated blocks on the integration goroutine.
+// Returns false if the pipeline is stopped.
+// Thread-safe.
+func (p *Pipeline) UpdateMesh() bool {
+	return p.Run(p.MeshIntegrator.Integrate)
+}
+
+// Dropped returns the number of clouds dropped because the queue was full.
+// Thread-safe.
+func (p *Pipeline) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Stop rejects new clouds, integrates the clouds still queued and waits for
+// the integration goroutine to exit.
+// Thread-safe.
+func (p *Pipeline) Stop() {
+	p.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.queue)
+	}
+	p.Unlock()
+	// Drain the queue even if the pipeline was never started.
+	p.Start()
+	<-p.done
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"go-voxblox/voxblox"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingIntegrator records the translations of the integrated clouds.
+type recordingIntegrator struct {
+	sync.Mutex
+	translations []float64
+}
+
+func (r *recordingIntegrator) IntegratePointCloud(pose voxblox.Transform, _ voxblox.PointCloud) {
+	r.Lock()
+	defer r.Unlock()
+	r.translations = append(r.translations, pose.Translation[0])
+}
+
+func newTestPipeline(dropPolicy string) (*Pipeline, *recordingIntegrator) {
+	config := voxblox.Config{
+		IntegrationQueueSize:  2,
+		IntegrationDropPolicy: dropPolicy,
+		MeshUpdatePeriod:      time.Hour,
+	}
+	tsdfLayer := voxblox.NewTsdfLayer(0.1, 8)
+	meshIntegrator := voxblox.NewMeshIntegrator(config, tsdfLayer, voxblox.NewMeshLayer(tsdfLayer))
+	integrator := &recordingIntegrator{}
+	return NewPipeline(config, integrator, meshIntegrator), integrator
+}
+
+func newTestReadyCloud(x float64) readyCloud {
+	return readyCloud{
+		msg:       newStampedPointCloud2(time.Unix(int64(x), 0)),
+		transform: voxblox.Transform{Translation: voxblox.Point{x, 0, 0}},
+	}
+}
+
+func TestPipelineLatestWins(t *testing.T) {
+	pipeline, integrator := newTestPipeline(voxblox.DropPolicyLatest)
+
+	// Fill the queue before starting so nothing is consumed.
+	for x := 1.0; x <= 4; x++ {
+		assert.True(t, pipeline.Submit(newTestReadyCloud(x)))
+	}
+	assert.Equal(t, uint64(2), pipeline.Dropped())
+
+	pipeline.Start()
+	pipeline.Stop()
+	assert.Equal(t, []float64{3, 4}, integrator.translations)
+
+	// Clouds are rejected once stopped.
+	assert.False(t, pipeline.Submit(newTestReadyCloud(5)))
+}
+
+func TestPipelineBlock(t *testing.T) {
+	pipeline, integrator := newTestPipeline(voxblox.DropPolicyBlock)
+	for x := 1.0; x <= 2; x++ {
+		assert.True(t, pipeline.Submit(newTestReadyCloud(x)))
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		pipeline.Submit(newTestReadyCloud(3))
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("Submit should block while the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pipeline.Start()
+	<-submitted
+	pipeline.Stop()
+	assert.Equal(t, uint64(0), pipeline.Dropped())
+	assert.Equal(t, []float64{1, 2, 3}, integrator.translations)
+}
+
+func TestPipelineRun(t *testing.T) {
+	pipeline, integrator := newTestPipeline(voxblox.DropPolicyBlock)
+	pipeline.Start()
+	assert.True(t, pipeline.Submit(newTestReadyCloud(1)))
+
+	// Commands run on the integration goroutine, so they see no cloud half integrated.
+	ran := false
+	assert.True(t, pipeline.Run(func() {
+		ran = true
+		assert.True(t, integrator.TryLock())
+		integrator.Unlock()
+	}))
+	assert.True(t, ran)
+	assert.True(t, pipeline.UpdateMesh())
+
+	// Commands are rejected once stopped.
+	pipeline.Stop()
+	assert.False(t, pipeline.Run(func() { t.Error("command should not run once stopped") }))
+	assert.False(t, pipeline.UpdateMesh())
+}
+
+// decayingIntegrator records the elapsed times of the weight decays.
+type decayingIntegrator struct {
+	recordingIntegrator
+	decays chan time.Duration
+}
+
+func (d *decayingIntegrator) DecayWeights(elapsed time.Duration) {
+	d.decays <- elapsed
+}
+
+func TestPipelineDecayWeights(t *testing.T) {
+	config := voxblox.Config{
+		IntegrationQueueSize:  2,
+		IntegrationDropPolicy: voxblox.DropPolicyLatest,
+		MeshUpdatePeriod:      10 * time.Millisecond,
+	}
+	tsdfLayer := voxblox.NewTsdfLayer(0.1, 8)
+	meshIntegrator := voxblox.NewMeshIntegrator(config, tsdfLayer, voxblox.NewMeshLayer(tsdfLayer))
+	integrator := &decayingIntegrator{decays: make(chan time.Duration, 1)}
+	pipeline := NewPipeline(config, integrator, meshIntegrator)
+	pipeline.Start()
+	defer pipeline.Stop()
+
+	// Weights are decayed by the time since the previous mesh update.
+	select {
+	case elapsed := <-integrator.decays:
+		assert.GreaterOrEqual(t, elapsed, 5*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("weights should be decayed on mesh updates")
+	}
+}
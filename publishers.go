@@ -0,0 +1,240 @@
+package main
+
+import (
+	"go-voxblox/msgs/voxblox_msgs"
+	"go-voxblox/voxblox"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aler9/goroslib"
+	"github.com/aler9/goroslib/pkg/msgs/geometry_msgs"
+	"github.com/aler9/goroslib/pkg/msgs/sensor_msgs"
+	"github.com/aler9/goroslib/pkg/msgs/std_msgs"
+	"github.com/aler9/goroslib/pkg/msgs/visualization_msgs"
+)
+
+// kPointConvFactor quantizes vertex coordinates relative to the block origin,
+// matching the upstream voxblox_msgs encoding.
+const kPointConvFactor = 2.0 / math.MaxUint16
+
+// MeshBlockToVoxbloxMeshBlock converts a voxblox MeshBlock to a voxblox_msgs MeshBlock.
+// Triangles are unrolled so that every three consecutive vertices form a triangle.
+func MeshBlockToVoxbloxMeshBlock(block *voxblox.MeshBlock) voxblox_msgs.MeshBlock {
+	vertices, triangles, colors := block.GetMesh()
+	msg := voxblox_msgs.MeshBlock{
+		Index: [3]int64{int64(block.Index[0]), int64(block.Index[1]), int64(block.Index[2])},
+	}
+	for _, triangle := range triangles {
+		for _, vertexIndex := range triangle {
+			vertex := vertices[vertexIndex]
+			msg.X = append(msg.X, quantizeVertex(vertex[0], block.Origin[0], block.BlockSize))
+			msg.Y = append(msg.Y, quantizeVertex(vertex[1], block.Origin[1], block.BlockSize))
+			msg.Z = append(msg.Z, quantizeVertex(vertex[2], block.Origin[2], block.BlockSize))
+
+			color := voxblox.ColorWhite
+			if vertexIndex < len(colors) {
+				color = colors[vertexIndex]
+			}
+			msg.R = append(msg.R, color[0])
+			msg.G = append(msg.G, color[1])
+			msg.B = append(msg.B, color[2])
+		}
+	}
+	return msg
+}
+
+// quantizeVertex converts a vertex coordinate to the voxblox_msgs uint16 encoding.
+func quantizeVertex(coordinate, origin, blockSize float64) uint16 {
+	normalized := (coordinate - origin) / blockSize
+	return uint16(math.Max(0, math.Min(normalized/kPointConvFactor, math.MaxUint16)))
+}
+
+// MeshBlockToMarker converts a voxblox MeshBlock to a TRIANGLE_LIST Marker.
+func MeshBlockToMarker(
+	header std_msgs.Header,
+	block *voxblox.MeshBlock,
+	id int32,
+) visualization_msgs.Marker {
+	vertices, triangles, colors := block.GetMesh()
+	marker := visualization_msgs.Marker{
+		Header: header,
+		Ns:     "mesh",
+		Id:     id,
+		Type:   int32(visualization_msgs.Marker_TRIANGLE_LIST),
+		Action: int32(visualization_msgs.Marker_ADD),
+		Pose:   geometry_msgs.Pose{Orientation: geometry_msgs.Quaternion{W: 1}},
+		Scale:  geometry_msgs.Vector3{X: 1, Y: 1, Z: 1},
+		Color:  std_msgs.ColorRGBA{R: 1, G: 1, B: 1, A: 1},
+	}
+	for _, triangle := range triangles {
+		for _, vertexIndex := range triangle {
+			vertex := vertices[vertexIndex]
+			marker.Points = append(marker.Points, geometry_msgs.Point{
+				X: vertex[0],
+				Y: vertex[1],
+				Z: vertex[2],
+			})
+			color := voxblox.ColorWhite
+			if vertexIndex < len(colors) {
+				color = colors[vertexIndex]
+			}
+			marker.Colors = append(marker.Colors, std_msgs.ColorRGBA{
+				R: float32(color[0]) / 255.0,
+				G: float32(color[1]) / 255.0,
+				B: float32(color[2]) / 255.0,
+				A: 1,
+			})
+		}
+	}
+	return marker
+}
+
+// MapPublisher publishes the mesh and map to ROS.
+// Publishers are only created for topics set in the Config.
+type MapPublisher struct {
+	Config         voxblox.Config
+	MeshIntegrator *voxblox.MeshIntegrator
+	mesh           *goroslib.Publisher
+	meshMarkers    *goroslib.Publisher
+	surface        *goroslib.Publisher
+	tsdfSlice      *goroslib.Publisher
+	sync.Mutex
+	publishedMesh map[voxblox.IndexType]*voxblox.MeshBlock
+	markerIds     map[voxblox.IndexType]int32
+}
+
+// NewMapPublisher creates a new MapPublisher.
+func NewMapPublisher(
+	node *goroslib.Node,
+	config voxblox.Config,
+	meshIntegrator *voxblox.MeshIntegrator,
+) (*MapPublisher, error) {
+	p := &MapPublisher{
+		Config:         config,
+		MeshIntegrator: meshIntegrator,
+		publishedMesh:  make(map[voxblox.IndexType]*voxblox.MeshBlock),
+		markerIds:      make(map[voxblox.IndexType]int32),
+	}
+
+	var err error
+	topics := []struct {
+		topic     string
+		msg       interface{}
+		publisher **goroslib.Publisher
+	}{
+		{config.TopicMesh, &voxblox_msgs.Mesh{}, &p.mesh},
+		{config.TopicMeshMarkers, &visualization_msgs.MarkerArray{}, &p.meshMarkers},
+		{config.TopicSurfacePointCloud, &sensor_msgs.PointCloud2{}, &p.surface},
+		{config.TopicTsdfSlice, &sensor_msgs.PointCloud2{}, &p.tsdfSlice},
+	}
+	for _, t := range topics {
+		if t.topic == "" {
+			continue
+		}
+		*t.publisher, err = goroslib.NewPublisher(goroslib.PublisherConf{
+			Node:  node,
+			Topic: t.topic,
+			Msg:   t.msg,
+		})
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Close closes all publishers.
+func (p *MapPublisher) Close() {
+	for _, publisher := range []*goroslib.Publisher{p.mesh, p.meshMarkers, p.surface, p.tsdfSlice} {
+		if publisher != nil {
+			publisher.Close()
+		}
+	}
+}
+
+// Clear removes the published mesh from RViz and forgets the published blocks.
+// Thread-safe.
+func (p *MapPublisher) Clear() {
+	p.Lock()
+	defer p.Unlock()
+	p.publishedMesh = make(map[voxblox.IndexType]*voxblox.MeshBlock)
+	p.markerIds = make(map[voxblox.IndexType]int32)
+	if p.meshMarkers != nil {
+		p.meshMarkers.Write(&visualization_msgs.MarkerArray{
+			Markers: []visualization_msgs.Marker{{
+				Header: std_msgs.Header{Stamp: time.Now(), FrameId: p.Config.WorldFrame},
+				Ns:     "mesh",
+				Action: int32(visualization_msgs.Marker_DELETEALL),
+			}},
+		})
+	}
+}
+
+// Publish publishes the mesh blocks updated since the last call,
+// the surface point cloud and the TSDF slice.
+// Thread-safe.
+func (p *MapPublisher) Publish() {
+	defer voxblox.TimeTrack(p.Config.GetLogger(), time.Now(), "Publish ROS")
+
+	p.Lock()
+	defer p.Unlock()
+
+	header := std_msgs.Header{Stamp: time.Now(), FrameId: p.Config.WorldFrame}
+
+	// A new MeshBlock is allocated every time a block is re-meshed
+	// and only added to the mesh layer once its mesh is complete.
+	// Empty blocks may have been cleared by the gRPC server and are skipped.
+	var updatedBlocks []*voxblox.MeshBlock
+	for index, block := range p.MeshIntegrator.MeshLayer.GetBlocks() {
+		if p.publishedMesh[index] == block || !block.HasData() {
+			continue
+		}
+		p.publishedMesh[index] = block
+		updatedBlocks = append(updatedBlocks, block)
+	}
+
+	if p.mesh != nil && len(updatedBlocks) > 0 {
+		msg := &voxblox_msgs.Mesh{
+			Header:          header,
+			BlockEdgeLength: float32(p.MeshIntegrator.MeshLayer.BlockSize),
+		}
+		for _, block := range updatedBlocks {
+			msg.MeshBlocks = append(msg.MeshBlocks, MeshBlockToVoxbloxMeshBlock(block))
+		}
+		p.mesh.Write(msg)
+	}
+
+	if p.meshMarkers != nil && len(updatedBlocks) > 0 {
+		msg := &visualization_msgs.MarkerArray{}
+		for _, block := range updatedBlocks {
+			id, ok := p.markerIds[block.Index]
+			if !ok {
+				id = int32(len(p.markerIds))
+				p.markerIds[block.Index] = id
+			}
+			msg.Markers = append(msg.Markers, MeshBlockToMarker(header, block, id))
+		}
+		p.meshMarkers.Write(msg)
+	}
+
+	if p.surface != nil {
+		pointCloud := voxblox.GetSurfacePoints(
+			p.MeshIntegrator.TsdfLayer,
+			p.MeshIntegrator.TsdfLayer.VoxelSize*0.75,
+			p.Config.MinWeight,
+		)
+		p.surface.Write(PointCloudToPointCloud2(header, pointCloud))
+	}
+
+	if p.tsdfSlice != nil {
+		points, distances := voxblox.GetTsdfSlice(
+			p.MeshIntegrator.TsdfLayer,
+			2,
+			p.Config.TsdfSliceLevel,
+			p.Config.MinWeight,
+		)
+		p.tsdfSlice.Write(PointsToPointCloud2(header, points, distances))
+	}
+}
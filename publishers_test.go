@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"go-voxblox/msgs/voxblox_msgs"
+	"go-voxblox/voxblox"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aler9/goroslib/pkg/msgproc"
+	"github.com/aler9/goroslib/pkg/msgs/std_msgs"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPlaneCloud returns a red 1x1m plane 1m in front of the sensor.
+func newTestPlaneCloud() voxblox.PointCloud {
+	pointCloud := voxblox.PointCloud{}
+	for x := -0.5; x <= 0.5; x += 0.01 {
+		for y := -0.5; y <= 0.5; y += 0.01 {
+			pointCloud.Points = append(pointCloud.Points, voxblox.Point{x, y, 1.0})
+			pointCloud.Colors = append(pointCloud.Colors, voxblox.ColorRed)
+		}
+	}
+	return pointCloud
+}
+
+// newTestMeshLayer integrates a flat surface into a mesh layer.
+func newTestMeshLayer(t *testing.T) *voxblox.MeshLayer {
+	config, err := voxblox.ReadConfig("testdata/test.yaml")
+	assert.NoError(t, err)
+	tsdfLayer := voxblox.NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	tsdfIntegrator := voxblox.NewSimpleTsdfIntegrator(&config, tsdfLayer)
+	meshLayer := voxblox.NewMeshLayer(tsdfLayer)
+	meshIntegrator := voxblox.NewMeshIntegrator(config, tsdfLayer, meshLayer)
+
+	tsdfIntegrator.IntegratePointCloud(
+		voxblox.Transform{Rotation: [4]float64{0, 0, 0, 1}},
+		newTestPlaneCloud(),
+	)
+	meshIntegrator.Integrate()
+
+	hasData := false
+	for _, block := range meshLayer.GetBlocks() {
+		hasData = hasData || block.HasData()
+	}
+	assert.True(t, hasData)
+	return meshLayer
+}
+
+func TestMeshBlockToVoxbloxMeshBlock(t *testing.T) {
+	meshLayer := newTestMeshLayer(t)
+	assert.NotEmpty(t, meshLayer.GetBlocks())
+	for _, block := range meshLayer.GetBlocks() {
+		vertices, triangles, _ := block.GetMesh()
+		msg := MeshBlockToVoxbloxMeshBlock(block)
+		assert.Len(t, msg.X, len(triangles)*3)
+		assert.Len(t, msg.R, len(triangles)*3)
+		assert.Equal(t, int64(block.Index[0]), msg.Index[0])
+		if len(triangles) == 0 {
+			continue
+		}
+		vertex := vertices[triangles[0][0]]
+		decoded := float64(msg.X[0])*kPointConvFactor*block.BlockSize + block.Origin[0]
+		assert.InDelta(t, vertex[0], decoded, block.BlockSize*kPointConvFactor)
+	}
+}
+
+func TestVoxbloxMeshDefinition(t *testing.T) {
+	// The fields must be in the order of the upstream Mesh.msg for subscribers to accept the topic.
+	text, err := msgproc.Text(voxblox_msgs.Mesh{})
+	assert.NoError(t, err)
+	var fields []string
+	for _, line := range strings.Split(text, "\n") {
+		fields = append(fields, strings.Fields(line)[1])
+	}
+	assert.Equal(t, []string{"header", "block_edge_length", "mesh_blocks"}, fields)
+}
+
+func TestMeshBlockToMarker(t *testing.T) {
+	meshLayer := newTestMeshLayer(t)
+	for _, block := range meshLayer.GetBlocks() {
+		_, triangles, _ := block.GetMesh()
+		marker := MeshBlockToMarker(std_msgs.Header{FrameId: "world"}, block, 3)
+		assert.Equal(t, int32(3), marker.Id)
+		assert.Len(t, marker.Points, len(triangles)*3)
+		assert.Len(t, marker.Colors, len(triangles)*3)
+	}
+}
+
+func TestPointCloudToPointCloud2(t *testing.T) {
+	pointCloud := voxblox.PointCloud{
+		Points: []voxblox.Point{{1, 2, 3}, {4, 5, 6}},
+		Colors: []voxblox.Color{{95, 84, 71}, voxblox.ColorRed},
+	}
+	msg := PointCloudToPointCloud2(std_msgs.Header{}, pointCloud)
+	assert.Equal(t, uint32(2), msg.Width)
+	assert.Len(t, msg.Data, 2*16)
+
+	y := math.Float32frombits(binary.LittleEndian.Uint32(msg.Data[20:24]))
+	assert.Equal(t, float32(5), y)
+	rgb := math.Float32frombits(binary.LittleEndian.Uint32(msg.Data[12:16]))
+	assert.Equal(t, voxblox.Color{95, 84, 71}, float32ToRGB(rgb))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"go-voxblox/msgs/voxblox_msgs"
+	"go-voxblox/voxblox"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/aler9/goroslib"
+	"github.com/aler9/goroslib/pkg/msgs/std_srvs"
+)
+
+// MapServices provides the upstream voxblox map management services.
+// The services run on the pipeline goroutine, so they never race with a cloud integration or mesh update.
+type MapServices struct {
+	Config         voxblox.Config
+	Pipeline       *Pipeline
+	MeshIntegrator *voxblox.MeshIntegrator
+	MapPublisher   *MapPublisher
+	providers      []*goroslib.ServiceProvider
+}
+
+// NewMapServices creates the generate_mesh, save_map, load_map and clear_map services.
+func NewMapServices(
+	node *goroslib.Node,
+	config voxblox.Config,
+	pipeline *Pipeline,
+	mapPublisher *MapPublisher,
+) (*MapServices, error) {
+	s := &MapServices{
+		Config:         config,
+		Pipeline:       pipeline,
+		MeshIntegrator: pipeline.MeshIntegrator,
+		MapPublisher:   mapPublisher,
+	}
+
+	services := []struct {
+		name     string
+		srv      interface{}
+		callback interface{}
+	}{
+		{"generate_mesh", &std_srvs.Empty{}, s.onGenerateMesh},
+		{"save_map", &voxblox_msgs.FilePath{}, s.onSaveMap},
+		{"load_map", &voxblox_msgs.FilePath{}, s.onLoadMap},
+		{"clear_map", &std_srvs.Empty{}, s.onClearMap},
+	}
+	for _, service := range services {
+		provider, err := goroslib.NewServiceProvider(goroslib.ServiceProviderConf{
+			Node:     node,
+			Name:     service.name,
+			Srv:      service.srv,
+			Callback: service.callback,
+		})
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.providers = append(s.providers, provider)
+	}
+	return s, nil
+}
+
+// Close closes all service providers.
+func (s *MapServices) Close() {
+	for _, provider := range s.providers {
+		provider.Close()
+	}
+}
+
+// run runs the command on the pipeline goroutine.
+// Returns errPipelineStopped if the pipeline is stopped.
+func (s *MapServices) run(command func() error) error {
+	var err error
+	if !s.Pipeline.Run(func() { err = command() }) {
+		return errPipelineStopped
+	}
+	return err
+}
+
+// onGenerateMesh re-meshes the whole map, publishes it and writes it to the output path.
+func (s *MapServices) onGenerateMesh(*std_srvs.EmptyReq) (*std_srvs.EmptyRes, bool) {
+	err := s.run(func() error {
+		s.MeshIntegrator.IntegrateAll()
+		s.MapPublisher.Publish()
+		return voxblox.WriteMeshLayerToObjFiles(s.MeshIntegrator.MeshLayer, s.Config.OutputPath)
+	})
+	if err != nil {
+		slog.Error("Failed to write mesh", "path", s.Config.OutputPath, "error", err)
+		return nil, false
+	}
+	return &std_srvs.EmptyRes{}, true
+}
+
+// kVxblxExtension is the file extension of upstream Voxblox layer files.
+const kVxblxExtension = ".vxblx"
+
+// getSavedLayer returns the TSDF Layer to save, a multi-resolution map at the resolution of its finest level.
+func getSavedLayer(meshIntegrator *voxblox.MeshIntegrator) *voxblox.TsdfLayer {
+	if meshIntegrator.MultiResolutionLayer != nil {
+		return meshIntegrator.MultiResolutionLayer.Flatten()
+	}
+	return meshIntegrator.TsdfLayer
+}
+
+// onSaveMap writes the TSDF Layer to the requested file, in the upstream format for .vxblx files.
+func (s *MapServices) onSaveMap(req *voxblox_msgs.FilePathReq) (*voxblox_msgs.FilePathRes, bool) {
+	write := voxblox.WriteTsdfLayer
+	if filepath.Ext(req.FilePath) == kVxblxExtension {
+		write = voxblox.WriteTsdfLayerProto
+	}
+	err := s.run(func() error {
+		return write(getSavedLayer(s.MeshIntegrator), req.FilePath)
+	})
+	if err != nil {
+		slog.Error("Failed to save map", "path", req.FilePath, "error", err)
+		return nil, false
+	}
+	return &voxblox_msgs.FilePathRes{}, true
+}
+
+// onLoadMap reads the requested file into the TSDF Layer, in the upstream format for .vxblx files,
+// and re-meshes the map.
+// A multi-resolution map loads the file into its finest level.
+func (s *MapServices) onLoadMap(req *voxblox_msgs.FilePathReq) (*voxblox_msgs.FilePathRes, bool) {
+	read := voxblox.ReadTsdfLayer
+	if filepath.Ext(req.FilePath) == kVxblxExtension {
+		read = voxblox.ReadTsdfLayerProto
+	}
+	err := s.run(func() error {
+		layer := s.MeshIntegrator.TsdfLayer
+		multiResolutionLayer := s.MeshIntegrator.MultiResolutionLayer
+		if multiResolutionLayer != nil {
+			layer = voxblox.NewTsdfLayer(layer.VoxelSize, layer.VoxelsPerSide)
+		}
+		err := read(layer, req.FilePath)
+		if err == nil && multiResolutionLayer != nil {
+			err = multiResolutionLayer.MergeTsdfLayer(layer)
+		}
+		if err != nil {
+			return err
+		}
+		s.MeshIntegrator.IntegrateAll()
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to load map", "path", req.FilePath, "error", err)
+		return nil, false
+	}
+	return &voxblox_msgs.FilePathRes{}, true
+}
+
+// onClearMap removes all blocks from the TSDF, Semantic, Instance, Channel, Observation and Mesh Layers.
+func (s *MapServices) onClearMap(*std_srvs.EmptyReq) (*std_srvs.EmptyRes, bool) {
+	err := s.run(func() error {
+		s.clearMap()
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to clear map", "error", err)
+		return nil, false
+	}
+	return &std_srvs.EmptyRes{}, true
+}
+
+// clearMap removes all blocks from the layers.
+func (s *MapServices) clearMap() {
+	s.MeshIntegrator.TsdfLayer.Clear()
+	if s.MeshIntegrator.MultiResolutionLayer != nil {
+		s.MeshIntegrator.MultiResolutionLayer.Clear()
+	}
+	if s.MeshIntegrator.SemanticLayer != nil {
+		s.MeshIntegrator.SemanticLayer.Clear()
+	}
+	if s.MeshIntegrator.InstanceLayer != nil {
+		s.MeshIntegrator.InstanceLayer.Clear()
+	}
+	if s.MeshIntegrator.ChannelLayer != nil {
+		s.MeshIntegrator.ChannelLayer.Clear()
+	}
+	if s.MeshIntegrator.ObservationLayer != nil {
+		s.MeshIntegrator.ObservationLayer.Clear()
+	}
+	s.MeshIntegrator.MeshLayer.Clear()
+	s.MapPublisher.Clear()
+}
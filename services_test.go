@@ -0,0 +1,112 @@
+package main
+
+import (
+	"go-voxblox/msgs/voxblox_msgs"
+	"go-voxblox/voxblox"
+	"path/filepath"
+	"testing"
+
+	"github.com/aler9/goroslib/pkg/msgs/std_srvs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapServices(t *testing.T) {
+	config, err := voxblox.ReadConfig("testdata/test.yaml")
+	assert.NoError(t, err)
+	config.OutputPath = t.TempDir()
+	tsdfLayer := voxblox.NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	tsdfIntegrator := voxblox.NewSimpleTsdfIntegrator(&config, tsdfLayer)
+	meshLayer := voxblox.NewMeshLayer(tsdfLayer)
+	meshIntegrator := voxblox.NewMeshIntegrator(config, tsdfLayer, meshLayer)
+	mapPublisher := &MapPublisher{Config: config, MeshIntegrator: meshIntegrator}
+	mapPublisher.Clear()
+	pipeline := NewPipeline(config, tsdfIntegrator, meshIntegrator)
+	pipeline.Start()
+	defer pipeline.Stop()
+	services := MapServices{
+		Config:         config,
+		Pipeline:       pipeline,
+		MeshIntegrator: meshIntegrator,
+		MapPublisher:   mapPublisher,
+	}
+
+	tsdfIntegrator.IntegratePointCloud(
+		voxblox.Transform{Rotation: [4]float64{0, 0, 0, 1}},
+		newTestPlaneCloud(),
+	)
+	blockCount := tsdfLayer.GetBlockCount()
+	assert.Greater(t, blockCount, 0)
+
+	_, ok := services.onGenerateMesh(&std_srvs.EmptyReq{})
+	assert.True(t, ok)
+	objFiles, _ := filepath.Glob(filepath.Join(config.OutputPath, "*.obj"))
+	assert.NotEmpty(t, objFiles)
+
+	mapFile := filepath.Join(t.TempDir(), "map.tsdf")
+	_, ok = services.onSaveMap(&voxblox_msgs.FilePathReq{FilePath: mapFile})
+	assert.True(t, ok)
+
+	_, ok = services.onClearMap(&std_srvs.EmptyReq{})
+	assert.True(t, ok)
+	assert.Equal(t, 0, tsdfLayer.GetBlockCount())
+	assert.Empty(t, meshLayer.GetBlocks())
+
+	_, ok = services.onLoadMap(&voxblox_msgs.FilePathReq{FilePath: mapFile})
+	assert.True(t, ok)
+	assert.Equal(t, blockCount, tsdfLayer.GetBlockCount())
+	assert.NotEmpty(t, meshLayer.GetBlocks())
+
+	_, ok = services.onLoadMap(&voxblox_msgs.FilePathReq{FilePath: "missing.tsdf"})
+	assert.False(t, ok)
+
+	// The services fail once the pipeline is stopped.
+	pipeline.Stop()
+	_, ok = services.onClearMap(&std_srvs.EmptyReq{})
+	assert.False(t, ok)
+	assert.NotEmpty(t, meshLayer.GetBlocks())
+}
+
+func TestMapServicesMultiResolution(t *testing.T) {
+	config, err := voxblox.ReadConfig("testdata/test.yaml")
+	assert.NoError(t, err)
+	layer := voxblox.NewMultiResolutionTsdfLayer(config.VoxelSize, config.VoxelsPerSide, 2, 1.0)
+	tsdfIntegrator := voxblox.NewMultiResolutionTsdfIntegrator(&config, layer)
+	meshLayer := voxblox.NewMeshLayer(layer.Levels[0])
+	meshIntegrator := voxblox.NewMultiResolutionMeshIntegrator(config, layer, meshLayer)
+	mapPublisher := &MapPublisher{Config: config, MeshIntegrator: meshIntegrator}
+	mapPublisher.Clear()
+	pipeline := NewPipeline(config, tsdfIntegrator, meshIntegrator)
+	pipeline.Start()
+	defer pipeline.Stop()
+	services := MapServices{
+		Config:         config,
+		Pipeline:       pipeline,
+		MeshIntegrator: meshIntegrator,
+		MapPublisher:   mapPublisher,
+	}
+
+	// The plane is beyond the level distance, so it is integrated into the coarse level.
+	tsdfIntegrator.IntegratePointCloud(
+		voxblox.Transform{Rotation: [4]float64{0, 0, 0, 1}},
+		newTestPlaneCloud(),
+	)
+	blockCount := layer.GetBlockCount()
+	assert.Greater(t, layer.Levels[1].GetBlockCount(), 0)
+
+	for _, name := range []string{"map.tsdf", "map.vxblx"} {
+		mapFile := filepath.Join(t.TempDir(), name)
+		_, ok := services.onSaveMap(&voxblox_msgs.FilePathReq{FilePath: mapFile})
+		assert.True(t, ok)
+
+		_, ok = services.onClearMap(&std_srvs.EmptyReq{})
+		assert.True(t, ok)
+		assert.Equal(t, 0, layer.GetBlockCount())
+
+		// The coarse blocks are loaded into the finest level and meshed.
+		_, ok = services.onLoadMap(&voxblox_msgs.FilePathReq{FilePath: mapFile})
+		assert.True(t, ok)
+		assert.Equal(t, blockCount, layer.GetBlockCount())
+		assert.Equal(t, blockCount, layer.Levels[0].GetBlockCount())
+		assert.NotEmpty(t, meshLayer.GetBlocks())
+	}
+}
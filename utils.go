@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"go-voxblox/voxblox"
+	"log/slog"
 	"math"
 	"sync"
 	"time"
@@ -12,6 +14,8 @@ import (
 	"github.com/ungerik/go3d/float64/quaternion"
 
 	"github.com/aler9/goroslib/pkg/msgs/geometry_msgs"
+	"github.com/aler9/goroslib/pkg/msgs/nav_msgs"
+	"github.com/aler9/goroslib/pkg/msgs/std_msgs"
 )
 
 func TransformStampedToTransform(msg *geometry_msgs.TransformStamped) *voxblox.Transform {
@@ -30,80 +34,152 @@ func TransformStampedToTransform(msg *geometry_msgs.TransformStamped) *voxblox.T
 	}
 }
 
-// TransformListener is a queue of goroslib TransformStamped messages
+// PoseToTransform converts a goroslib Pose to a voxblox Transform
+func PoseToTransform(pose *geometry_msgs.Pose) *voxblox.Transform {
+	return &voxblox.Transform{
+		Translation: voxblox.Point{
+			pose.Position.X,
+			pose.Position.Y,
+			pose.Position.Z,
+		},
+		Rotation: quaternion.T{
+			pose.Orientation.X,
+			pose.Orientation.Y,
+			pose.Orientation.Z,
+			pose.Orientation.W,
+		},
+	}
+}
+
+// errTransformNotReady is returned by LookupTransform when the requested
+// timestamp is newer than the latest buffered transform.
+// The lookup may succeed once a later transform arrives.
+var errTransformNotReady = errors.New("no transform after timestamp yet")
+
+// stampedTransform is a Transform with its timestamp.
+type stampedTransform struct {
+	Stamp     time.Time
+	Transform voxblox.Transform
+}
+
+// TransformListener is a queue of timestamped poses received from
+// TransformStamped, Odometry or PoseStamped messages.
 type TransformListener struct {
-	StaticTransform voxblox.Transform
+	StaticTransform  voxblox.Transform
+	MaxInterpolation time.Duration
+	MaxExtrapolation time.Duration
+	BufferDuration   time.Duration
 	sync.Mutex
-	transforms []*geometry_msgs.TransformStamped
+	transforms []stampedTransform
 }
 
 // NewTransformListener returns a new TransformListener.
-func NewTransformListener(staticTransform voxblox.Transform) *TransformListener {
+func NewTransformListener(config *voxblox.Config) *TransformListener {
 	return &TransformListener{
-		StaticTransform: staticTransform,
+		StaticTransform: voxblox.Transform{
+			Rotation:    config.Rotation,
+			Translation: config.Translation,
+		},
+		MaxInterpolation: config.TransformMaxInterpolation,
+		MaxExtrapolation: config.TransformMaxExtrapolation,
+		BufferDuration:   config.TransformBufferDuration,
 	}
 }
 
 // addTransform adds a transform to the TransformListener.
-func (t *TransformListener) addTransform(transform *geometry_msgs.TransformStamped) {
+// Transforms older than the buffer duration are discarded.
+// Out of order transforms are ignored.
+func (t *TransformListener) addTransform(stamp time.Time, transform voxblox.Transform) {
 	t.Lock()
 	defer t.Unlock()
-	t.transforms = append(t.transforms, transform)
+	if len(t.transforms) > 0 && !stamp.After(t.transforms[len(t.transforms)-1].Stamp) {
+		return
+	}
+	t.transforms = append(t.transforms, stampedTransform{Stamp: stamp, Transform: transform})
+	t.removePreviousTransforms(stamp.Add(-t.BufferDuration))
+}
+
+// addTransformStamped adds a TransformStamped message to the TransformListener.
+func (t *TransformListener) addTransformStamped(msg *geometry_msgs.TransformStamped) {
+	t.addTransform(msg.Header.Stamp, *TransformStampedToTransform(msg))
+}
+
+// addOdometry adds the pose of an Odometry message to the TransformListener.
+func (t *TransformListener) addOdometry(msg *nav_msgs.Odometry) {
+	t.addTransform(msg.Header.Stamp, *PoseToTransform(&msg.Pose.Pose))
+}
+
+// addPoseStamped adds a PoseStamped message to the TransformListener.
+func (t *TransformListener) addPoseStamped(msg *geometry_msgs.PoseStamped) {
+	t.addTransform(msg.Header.Stamp, *PoseToTransform(&msg.Pose))
 }
 
 // removePreviousTransforms removes transforms older than the given timestamp.
 func (t *TransformListener) removePreviousTransforms(timestamp time.Time) {
-	for len(t.transforms) > 0 && t.transforms[0].Header.Stamp.Before(timestamp) {
+	for len(t.transforms) > 0 && t.transforms[0].Stamp.Before(timestamp) {
 		t.transforms = t.transforms[1:]
 	}
 }
 
+// interpolate returns the transform at the given timestamp between two stamped transforms.
+// Extrapolates if the timestamp is after the second transform.
+func interpolate(t0, t1 stampedTransform, timeStamp time.Time) voxblox.Transform {
+	tsDelta := t1.Stamp.Sub(t0.Stamp)
+	f := float64(timeStamp.Sub(t0.Stamp)) / float64(tsDelta)
+	return voxblox.InterpolateTransform(t0.Transform, t1.Transform, f)
+}
+
 // LookupTransform interpolates a transform from the TransformListener given a timestamp.
+// If MaxExtrapolation is set, timestamps shortly after the latest transform are
+// extrapolated using the velocity between the two latest transforms.
+// Returns errTransformNotReady if the timestamp is newer than the latest transform.
 func (t *TransformListener) LookupTransform(
 	timeStamp time.Time,
 ) (*voxblox.Transform, error) {
 	t.Lock()
 	defer t.Unlock()
 
-	// If there are no transforms, return nil.
+	// If there are no transforms, wait for one.
 	if len(t.transforms) == 0 {
-		return nil, fmt.Errorf("no transforms in queue")
+		return nil, errTransformNotReady
+	}
+
+	// If the timestamp is newer than the latest transform, extrapolate or wait for the next one.
+	latest := len(t.transforms) - 1
+	if !t.transforms[latest].Stamp.After(timeStamp) {
+		if latest == 0 ||
+			t.transforms[latest].Stamp.Add(t.MaxExtrapolation).Before(timeStamp) ||
+			t.transforms[latest-1].Stamp.Add(t.MaxInterpolation).Before(t.transforms[latest].Stamp) {
+			return nil, errTransformNotReady
+		}
+		t0 := interpolate(t.transforms[latest-1], t.transforms[latest], timeStamp)
+		t1 := voxblox.ApplyTransform(&t0, &t.StaticTransform)
+		return &t1, nil
 	}
 
 	// Get the t0 before and after the timeStamp.
 	var i int
 	found := false
 	for i = 1; i < len(t.transforms); i++ {
-		if t.transforms[i-1].Header.Stamp.Before(timeStamp) &&
-			t.transforms[i].Header.Stamp.After(timeStamp) {
+		if !t.transforms[i-1].Stamp.After(timeStamp) &&
+			t.transforms[i].Stamp.After(timeStamp) {
 			found = true
 			break
 		}
 	}
 
 	if !found {
-		return nil, fmt.Errorf("no transform before and after timestamp")
+		return nil, fmt.Errorf("no transform before timestamp")
 	}
 
-	// Check that the timestamp is not more than 100ms from either the previous or next t0
-	if t.transforms[i-1].Header.Stamp.Add(100*time.Millisecond).Before(timeStamp) ||
-		t.transforms[i].Header.Stamp.Add(-100*time.Millisecond).After(timeStamp) {
+	// Check that the timestamp is not too far from either the previous or next transform.
+	if t.transforms[i-1].Stamp.Add(t.MaxInterpolation).Before(timeStamp) ||
+		t.transforms[i].Stamp.Add(-t.MaxInterpolation).After(timeStamp) {
 		return nil, fmt.Errorf("timestamp too far from t0 and t1")
 	}
 
-	// Calculate the interpolation factor.
-	tsLower := t.transforms[i-1].Header.Stamp
-	tsUpper := t.transforms[i].Header.Stamp
-	tsDelta := tsUpper.Sub(tsLower)
-	f := float64(timeStamp.Sub(tsLower)) / float64(tsDelta)
-
-	t0 := voxblox.InterpolateTransform(
-		*TransformStampedToTransform(t.transforms[i-1]),
-		*TransformStampedToTransform(t.transforms[i]),
-		f,
-	)
+	t0 := interpolate(t.transforms[i-1], t.transforms[i], timeStamp)
 	t1 := voxblox.ApplyTransform(&t0, &t.StaticTransform)
-	t.removePreviousTransforms(timeStamp)
 
 	return &t1, nil
 }
@@ -124,14 +200,98 @@ type xyzrgb struct {
 	RGB     float32
 }
 
+// kLabelField is the name of the PointCloud2 field holding the semantic labels.
+const kLabelField = "label"
+
+// fieldReader returns a function reading the named numeric field of a point from its data,
+// or nil if the PointCloud2 has no such field.
+func fieldReader(msg *sensor_msgs.PointCloud2, name string) func(point []uint8) float64 {
+	for _, field := range msg.Fields {
+		if field.Name != name {
+			continue
+		}
+		offset := int(field.Offset)
+		var read func(point []uint8) float64
+		switch field.Datatype {
+		case sensor_msgs.PointField_INT8:
+			read = func(point []uint8) float64 { return float64(int8(point[offset])) }
+		case sensor_msgs.PointField_UINT8:
+			read = func(point []uint8) float64 { return float64(point[offset]) }
+		case sensor_msgs.PointField_INT16:
+			read = func(point []uint8) float64 {
+				return float64(int16(binary.LittleEndian.Uint16(point[offset:])))
+			}
+		case sensor_msgs.PointField_UINT16:
+			read = func(point []uint8) float64 { return float64(binary.LittleEndian.Uint16(point[offset:])) }
+		case sensor_msgs.PointField_INT32:
+			read = func(point []uint8) float64 {
+				return float64(int32(binary.LittleEndian.Uint32(point[offset:])))
+			}
+		case sensor_msgs.PointField_UINT32:
+			read = func(point []uint8) float64 { return float64(binary.LittleEndian.Uint32(point[offset:])) }
+		case sensor_msgs.PointField_FLOAT32:
+			read = func(point []uint8) float64 {
+				return float64(math.Float32frombits(binary.LittleEndian.Uint32(point[offset:])))
+			}
+		case sensor_msgs.PointField_FLOAT64:
+			read = func(point []uint8) float64 {
+				return math.Float64frombits(binary.LittleEndian.Uint64(point[offset:]))
+			}
+		default:
+			return nil
+		}
+		return read
+	}
+	return nil
+}
+
+// labelFieldReader returns a function reading the label of a point from its data,
+// or nil if the PointCloud2 has no numeric label field.
+// Labels outside the range of voxblox.Label are unknown.
+func labelFieldReader(msg *sensor_msgs.PointCloud2) func(point []uint8) voxblox.Label {
+	read := fieldReader(msg, kLabelField)
+	if read == nil {
+		return nil
+	}
+	return func(point []uint8) voxblox.Label {
+		label := read(point)
+		if !(label >= 0 && label <= math.MaxUint16) {
+			return voxblox.LabelUnknown
+		}
+		return voxblox.Label(label)
+	}
+}
+
 // PointCloud2ToPointCloud converts a goroslib PointCloud2 to a voxblox PointCloud
+// Labels are read from the label field if the message has one.
+// The named channel fields are read into the point cloud channels, missing fields being nil.
 // TODO: Make this dynamic based on the message fields.
-func PointCloud2ToPointCloud(msg *sensor_msgs.PointCloud2) voxblox.PointCloud {
-	defer voxblox.TimeTrack(time.Now(), "Convert PointCloud2")
+func PointCloud2ToPointCloud(msg *sensor_msgs.PointCloud2, channels ...string) voxblox.PointCloud {
+	defer voxblox.TimeTrack(
+		slog.Default(),
+		time.Now(),
+		"Convert PointCloud2",
+		"stamp", msg.Header.Stamp,
+		"points", int(msg.Width)*int(msg.Height),
+	)
 
-	pointCloud := voxblox.PointCloud{}
+	pointCloud := voxblox.PointCloud{Stamp: msg.Header.Stamp}
 	pointCloud.Points = make([]voxblox.Point, 0, int(msg.Width)*int(msg.Height))
 	pointCloud.Colors = make([]voxblox.Color, 0, int(msg.Width)*int(msg.Height))
+	readLabel := labelFieldReader(msg)
+	if readLabel != nil {
+		pointCloud.Labels = make([]voxblox.Label, 0, int(msg.Width)*int(msg.Height))
+	}
+	readChannels := make([]func(point []uint8) float64, len(channels))
+	if len(channels) > 0 {
+		pointCloud.Channels = make([][]float32, len(channels))
+		for c, channel := range channels {
+			readChannels[c] = fieldReader(msg, channel)
+			if readChannels[c] != nil {
+				pointCloud.Channels[c] = make([]float32, 0, int(msg.Width)*int(msg.Height))
+			}
+		}
+	}
 
 	for v := 0; v < int(msg.Height); v++ {
 		offset := int(msg.RowStep) * v
@@ -154,6 +314,14 @@ func PointCloud2ToPointCloud(msg *sensor_msgs.PointCloud2) voxblox.PointCloud {
 					float64(p.Z),
 				})
 				pointCloud.Colors = append(pointCloud.Colors, float32ToRGB(p.RGB))
+				if readLabel != nil {
+					pointCloud.Labels = append(pointCloud.Labels, readLabel(msg.Data[offset:]))
+				}
+				for c, readChannel := range readChannels {
+					if readChannel != nil {
+						pointCloud.Channels[c] = append(pointCloud.Channels[c], float32(readChannel(msg.Data[offset:])))
+					}
+				}
 			}
 			offset += int(msg.PointStep)
 		}
@@ -163,3 +331,68 @@ func PointCloud2ToPointCloud(msg *sensor_msgs.PointCloud2) voxblox.PointCloud {
 
 	return pointCloud
 }
+
+// rgbToFloat32 converts uint8 RGB to a PCL float32 color
+func rgbToFloat32(c voxblox.Color) float32 {
+	return math.Float32frombits(uint32(c[0])<<16 | uint32(c[1])<<8 | uint32(c[2]))
+}
+
+// newPointCloud2 returns an unordered little-endian PointCloud2 with the given float32 fields.
+// The data is left empty for the caller to fill.
+func newPointCloud2(header std_msgs.Header, fieldNames []string, pointCount int) *sensor_msgs.PointCloud2 {
+	fields := make([]sensor_msgs.PointField, len(fieldNames))
+	for i, name := range fieldNames {
+		fields[i] = sensor_msgs.PointField{
+			Name:     name,
+			Offset:   uint32(i * 4),
+			Datatype: sensor_msgs.PointField_FLOAT32,
+			Count:    1,
+		}
+	}
+	pointStep := uint32(len(fieldNames) * 4)
+	return &sensor_msgs.PointCloud2{
+		Header:    header,
+		Height:    1,
+		Width:     uint32(pointCount),
+		Fields:    fields,
+		PointStep: pointStep,
+		RowStep:   pointStep * uint32(pointCount),
+		Data:      make([]uint8, int(pointStep)*pointCount),
+		IsDense:   true,
+	}
+}
+
+// putFloat32 writes a little-endian float32 to the buffer.
+func putFloat32(buf []uint8, f float32) {
+	binary.LittleEndian.PutUint32(buf, math.Float32bits(f))
+}
+
+// PointCloudToPointCloud2 converts a voxblox PointCloud to an XYZRGB goroslib PointCloud2
+func PointCloudToPointCloud2(header std_msgs.Header, pointCloud voxblox.PointCloud) *sensor_msgs.PointCloud2 {
+	msg := newPointCloud2(header, []string{"x", "y", "z", "rgb"}, len(pointCloud.Points))
+	for i, point := range pointCloud.Points {
+		offset := i * int(msg.PointStep)
+		putFloat32(msg.Data[offset:], float32(point[0]))
+		putFloat32(msg.Data[offset+4:], float32(point[1]))
+		putFloat32(msg.Data[offset+8:], float32(point[2]))
+		putFloat32(msg.Data[offset+12:], rgbToFloat32(pointCloud.Colors[i]))
+	}
+	return msg
+}
+
+// PointsToPointCloud2 converts points with intensities to an XYZI goroslib PointCloud2
+func PointsToPointCloud2(
+	header std_msgs.Header,
+	points []voxblox.Point,
+	intensities []float64,
+) *sensor_msgs.PointCloud2 {
+	msg := newPointCloud2(header, []string{"x", "y", "z", "intensity"}, len(points))
+	for i, point := range points {
+		offset := i * int(msg.PointStep)
+		putFloat32(msg.Data[offset:], float32(point[0]))
+		putFloat32(msg.Data[offset+4:], float32(point[1]))
+		putFloat32(msg.Data[offset+8:], float32(point[2]))
+		putFloat32(msg.Data[offset+12:], float32(intensities[i]))
+	}
+	return msg
+}
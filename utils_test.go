@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/binary"
 	"go-voxblox/voxblox"
+	"math"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/quaternion"
 
+	"github.com/aler9/goroslib/pkg/msgs/geometry_msgs"
+	"github.com/aler9/goroslib/pkg/msgs/nav_msgs"
 	"github.com/aler9/goroslib/pkg/msgs/sensor_msgs"
 	"github.com/aler9/goroslib/pkg/msgs/std_msgs"
 )
@@ -81,3 +87,135 @@ func TestPointCloud2ToPointCloud(t *testing.T) {
 	)
 	assert.Equal(t, voxblox.Color{65, 69, 69}, pointCloud.Colors[0])
 }
+
+func TestPointCloud2ToPointCloudLabels(t *testing.T) {
+	stamp := time.Unix(1700000000, 0)
+	pointCloud2 := newPointCloud2(std_msgs.Header{Stamp: stamp}, []string{"x", "y", "z", "_", "rgb", "label"}, 3)
+	pointCloud2.Fields[5].Datatype = sensor_msgs.PointField_UINT32
+	for i, label := range []uint32{3, 7, 70000} {
+		offset := i * int(pointCloud2.PointStep)
+		putFloat32(pointCloud2.Data[offset:], float32(i+1))
+		putFloat32(pointCloud2.Data[offset+16:], rgbToFloat32(voxblox.ColorRed))
+		binary.LittleEndian.PutUint32(pointCloud2.Data[offset+20:], label)
+	}
+	// NaN points are dropped with their label.
+	putFloat32(pointCloud2.Data[int(pointCloud2.PointStep)+4:], float32(math.NaN()))
+
+	pointCloud := PointCloud2ToPointCloud(pointCloud2)
+	assert.Equal(t, stamp, pointCloud.Stamp)
+	assert.Len(t, pointCloud.Points, 2)
+	assert.Equal(t, []voxblox.Label{3, voxblox.LabelUnknown}, pointCloud.Labels)
+
+	// Clouds without a label field are unlabeled.
+	pointCloud2.Fields = pointCloud2.Fields[:5]
+	assert.Nil(t, PointCloud2ToPointCloud(pointCloud2).Labels)
+}
+
+func TestPointCloud2ToPointCloudChannels(t *testing.T) {
+	pointCloud2 := newPointCloud2(std_msgs.Header{}, []string{"x", "y", "z", "_", "rgb", "intensity"}, 3)
+	for i, intensity := range []float32{10, 20, 30} {
+		offset := i * int(pointCloud2.PointStep)
+		putFloat32(pointCloud2.Data[offset:], float32(i+1))
+		putFloat32(pointCloud2.Data[offset+16:], rgbToFloat32(voxblox.ColorRed))
+		putFloat32(pointCloud2.Data[offset+20:], intensity)
+	}
+	// NaN points are dropped with their channels.
+	putFloat32(pointCloud2.Data[int(pointCloud2.PointStep)+4:], float32(math.NaN()))
+
+	// Missing channel fields are nil.
+	pointCloud := PointCloud2ToPointCloud(pointCloud2, "intensity", "temperature")
+	assert.Len(t, pointCloud.Channels, 2)
+	assert.Equal(t, []float32{10, 30}, pointCloud.Channels[0])
+	assert.Nil(t, pointCloud.Channels[1])
+
+	assert.Nil(t, PointCloud2ToPointCloud(pointCloud2).Channels)
+}
+
+// newTransformStamped returns a TransformStamped with the given stamp and x translation.
+func newTransformStamped(stamp time.Time, x float64) *geometry_msgs.TransformStamped {
+	return &geometry_msgs.TransformStamped{
+		Header: std_msgs.Header{Stamp: stamp},
+		Transform: geometry_msgs.Transform{
+			Translation: geometry_msgs.Vector3{X: x},
+			Rotation:    geometry_msgs.Quaternion{W: 1},
+		},
+	}
+}
+
+func TestLookupTransform(t *testing.T) {
+	config := voxblox.Config{
+		Rotation:                  quaternion.Ident,
+		TransformMaxInterpolation: 100 * time.Millisecond,
+		TransformBufferDuration:   time.Second,
+	}
+	tf := NewTransformListener(&config)
+	t0 := time.Unix(100, 0)
+
+	_, err := tf.LookupTransform(t0)
+	assert.ErrorIs(t, err, errTransformNotReady)
+
+	tf.addTransformStamped(newTransformStamped(t0, 0))
+	tf.addTransformStamped(newTransformStamped(t0.Add(100*time.Millisecond), 1))
+
+	transform, err := tf.LookupTransform(t0.Add(50 * time.Millisecond))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.5, transform.Translation[0], 1e-9)
+
+	// Transforms are kept for repeated lookups.
+	transform, err = tf.LookupTransform(t0.Add(25 * time.Millisecond))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.25, transform.Translation[0], 1e-9)
+
+	// Newer than the latest transform.
+	_, err = tf.LookupTransform(t0.Add(150 * time.Millisecond))
+	assert.ErrorIs(t, err, errTransformNotReady)
+
+	// Gap larger than the interpolation tolerance.
+	tf.addTransformStamped(newTransformStamped(t0.Add(500*time.Millisecond), 2))
+	_, err = tf.LookupTransform(t0.Add(300 * time.Millisecond))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errTransformNotReady)
+
+	// Transforms older than the buffer duration are discarded.
+	tf.addTransformStamped(newTransformStamped(t0.Add(2*time.Second), 3))
+	_, err = tf.LookupTransform(t0.Add(50 * time.Millisecond))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errTransformNotReady)
+}
+
+func TestLookupTransformExtrapolation(t *testing.T) {
+	config := voxblox.Config{
+		Rotation:                  quaternion.Ident,
+		TransformMaxInterpolation: 100 * time.Millisecond,
+		TransformMaxExtrapolation: 50 * time.Millisecond,
+		TransformBufferDuration:   time.Second,
+	}
+	tf := NewTransformListener(&config)
+	t0 := time.Unix(100, 0)
+
+	tf.addOdometry(&nav_msgs.Odometry{
+		Header: std_msgs.Header{Stamp: t0},
+		Pose: geometry_msgs.PoseWithCovariance{
+			Pose: geometry_msgs.Pose{Orientation: geometry_msgs.Quaternion{W: 1}},
+		},
+	})
+
+	// A single pose has no velocity to extrapolate with.
+	_, err := tf.LookupTransform(t0.Add(10 * time.Millisecond))
+	assert.ErrorIs(t, err, errTransformNotReady)
+
+	tf.addPoseStamped(&geometry_msgs.PoseStamped{
+		Header: std_msgs.Header{Stamp: t0.Add(100 * time.Millisecond)},
+		Pose: geometry_msgs.Pose{
+			Position:    geometry_msgs.Point{X: 1},
+			Orientation: geometry_msgs.Quaternion{W: 1},
+		},
+	})
+
+	transform, err := tf.LookupTransform(t0.Add(150 * time.Millisecond))
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.5, transform.Translation[0], 1e-9)
+
+	_, err = tf.LookupTransform(t0.Add(200 * time.Millisecond))
+	assert.ErrorIs(t, err, errTransformNotReady)
+}
@@ -0,0 +1,135 @@
+package voxblox
+
+import "sync"
+
+// kBlockHashShardBits is the log2 of the number of shards of a blockHash.
+const kBlockHashShardBits = 6
+
+// kBlockHashShards is the number of shards of a blockHash.
+const kBlockHashShards = 1 << kBlockHashShardBits
+
+// blockHashShard is a single lock-protected partition of a blockHash.
+type blockHashShard[B any] struct {
+	sync.RWMutex
+	blocks map[IndexType]B
+}
+
+// blockHash is a concurrent spatial hash table of blocks keyed by block index.
+// Blocks are spread over independently locked shards so workers touching
+// different regions of the map do not contend on a single lock.
+type blockHash[B any] struct {
+	shards [kBlockHashShards]blockHashShard[B]
+}
+
+// newBlockHash returns an empty blockHash.
+func newBlockHash[B any]() *blockHash[B] {
+	h := new(blockHash[B])
+	for i := range h.shards {
+		h.shards[i].blocks = make(map[IndexType]B)
+	}
+	return h
+}
+
+// hashIndex is the spatial hash of a block index.
+// Uses the large primes of Teschner et al. so neighboring blocks land in different shards.
+func hashIndex(index IndexType) uint64 {
+	return uint64(index[0])*73856093 ^ uint64(index[1])*19349669 ^ uint64(index[2])*83492791
+}
+
+// shard returns the shard holding the given block index.
+// The hash is mixed with Fibonacci hashing so the shard uses its high bits.
+func (h *blockHash[B]) shard(index IndexType) *blockHashShard[B] {
+	return &h.shards[(hashIndex(index)*0x9E3779B97F4A7C15)>>(64-kBlockHashShardBits)]
+}
+
+// get returns the block at the given index and whether it exists.
+// Thread-safe.
+func (h *blockHash[B]) get(index IndexType) (B, bool) {
+	s := h.shard(index)
+	s.RLock()
+	defer s.RUnlock()
+	block, ok := s.blocks[index]
+	return block, ok
+}
+
+// getOrCreate returns the block at the given index, calling create to
+// allocate it if it does not exist.
+// Concurrent callers for the same index always get the same block.
+// Thread-safe.
+func (h *blockHash[B]) getOrCreate(index IndexType, create func() B) B {
+	s := h.shard(index)
+	s.RLock()
+	block, ok := s.blocks[index]
+	s.RUnlock()
+	if ok {
+		return block
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	// Another goroutine may have created the block since the read lock was released.
+	if block, ok = s.blocks[index]; ok {
+		return block
+	}
+	block = create()
+	s.blocks[index] = block
+	return block
+}
+
+// set stores a block at the given index, replacing any existing block.
+// Thread-safe.
+func (h *blockHash[B]) set(index IndexType, block B) {
+	s := h.shard(index)
+	s.Lock()
+	defer s.Unlock()
+	s.blocks[index] = block
+}
+
+// delete removes the block at the given index if it exists.
+// Thread-safe.
+func (h *blockHash[B]) delete(index IndexType) {
+	s := h.shard(index)
+	s.Lock()
+	defer s.Unlock()
+	delete(s.blocks, index)
+}
+
+// len returns the number of blocks.
+// Thread-safe.
+func (h *blockHash[B]) len() int {
+	count := 0
+	for i := range h.shards {
+		s := &h.shards[i]
+		s.RLock()
+		count += len(s.blocks)
+		s.RUnlock()
+	}
+	return count
+}
+
+// clear removes all blocks.
+// Thread-safe.
+func (h *blockHash[B]) clear() {
+	for i := range h.shards {
+		s := &h.shards[i]
+		s.Lock()
+		s.blocks = make(map[IndexType]B)
+		s.Unlock()
+	}
+}
+
+// snapshot returns a copy of the blocks keyed by index.
+// The copy can be iterated without holding any lock.
+// Thread-safe.
+func (h *blockHash[B]) snapshot() map[IndexType]B {
+	blocks := make(map[IndexType]B)
+	for i := range h.shards {
+		s := &h.shards[i]
+		s.RLock()
+		for index, block := range s.blocks {
+			blocks[index] = block
+		}
+		s.RUnlock()
+	}
+	return blocks
+}
@@ -0,0 +1,105 @@
+package voxblox
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockHash(t *testing.T) {
+	h := newBlockHash[int]()
+	_, ok := h.get(IndexType{1, 2, 3})
+	assert.False(t, ok)
+
+	assert.Equal(t, 1, h.getOrCreate(IndexType{1, 2, 3}, func() int { return 1 }))
+	assert.Equal(t, 1, h.getOrCreate(IndexType{1, 2, 3}, func() int { return 2 }))
+	h.set(IndexType{-1, -2, -3}, 3)
+	assert.Equal(t, 2, h.len())
+	assert.Equal(t, map[IndexType]int{{1, 2, 3}: 1, {-1, -2, -3}: 3}, h.snapshot())
+
+	h.set(IndexType{1, 2, 3}, 4)
+	block, ok := h.get(IndexType{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, 4, block)
+
+	h.delete(IndexType{1, 2, 3})
+	_, ok = h.get(IndexType{1, 2, 3})
+	assert.False(t, ok)
+	assert.Equal(t, 1, h.len())
+
+	h.clear()
+	assert.Equal(t, 0, h.len())
+}
+
+func TestBlockHashShardDistribution(t *testing.T) {
+	// Neighboring blocks should not all share a shard.
+	shards := make(map[*blockHashShard[int]]bool)
+	h := newBlockHash[int]()
+	for x := -2; x < 2; x++ {
+		for y := -2; y < 2; y++ {
+			for z := -2; z < 2; z++ {
+				shards[h.shard(IndexType{x, y, z})] = true
+			}
+		}
+	}
+	assert.Greater(t, len(shards), kBlockHashShards/2)
+}
+
+func TestTsdfLayerConcurrentGetBlock(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	numGoroutines := 64
+
+	// Every goroutine requests the same blocks.
+	results := make([]map[IndexType]*TsdfBlock, numGoroutines)
+	wg := sync.WaitGroup{}
+	for g := 0; g < numGoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			results[g] = make(map[IndexType]*TsdfBlock)
+			for x := -2; x <= 2; x++ {
+				for y := -2; y <= 2; y++ {
+					index := IndexType{x, y, 0}
+					results[g][index] = tsdfLayer.getBlockByIndex(index)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 25, tsdfLayer.GetBlockCount())
+	for index, block := range tsdfLayer.getBlocks() {
+		for g := 0; g < numGoroutines; g++ {
+			assert.Same(t, block, results[g][index])
+		}
+	}
+}
+
+func TestFastIntegratorConcurrentClouds(t *testing.T) {
+	pointCloud := world.getPointCloudFromTransform(
+		&poses[0],
+		cameraResolution,
+		fovHorizontal,
+		maxDistance,
+	)
+	poseInverse := poses[0].inverse()
+	transformedPointCloud := transformPointCloud(poseInverse, pointCloud)
+
+	threadConfig := config
+	threadConfig.Threads = 16
+	tsdfLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	integrator := NewFastTsdfIntegrator(&threadConfig, tsdfLayer)
+
+	// Integrators hitting the same blocks must not lose or duplicate blocks.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			integrator.IntegratePointCloud(poses[0], transformedPointCloud)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 62, tsdfLayer.GetBlockCount())
+}
@@ -0,0 +1,161 @@
+package voxblox
+
+import (
+	"math"
+	"sync"
+)
+
+// Colormaps of the channel mesh color mode.
+const (
+	// ColormapGray maps low values to black and high values to white.
+	ColormapGray = "gray"
+	// ColormapJet maps low values to blue and high values to red through cyan, green and yellow.
+	ColormapJet = "jet"
+	// ColormapHot maps low values to black and high values to white through red and yellow.
+	ColormapHot = "hot"
+)
+
+// channelVoxel is the weighted average of the samples of a channel observed in a voxel.
+type channelVoxel struct {
+	value  float32
+	weight float32
+}
+
+// fuse adds a sample to the weighted average, capping the weight at maxWeight.
+func (v *channelVoxel) fuse(value, weight, maxWeight float32) {
+	newWeight := v.weight + weight
+	v.value = (v.value*v.weight + value*weight) / newWeight
+	v.weight = min(newWeight, maxWeight)
+}
+
+// channelBlock contains a dense array of channel voxels per channel.
+// The block lock protects the voxels.
+type channelBlock struct {
+	Index IndexType
+	sync.RWMutex
+	voxels []voxelArray[channelVoxel]
+}
+
+// ChannelLayer holds the fused float channels of the voxels of a TSDF Layer,
+// such as LiDAR intensity or temperature.
+// The channels of the point clouds are fused by the TSDF integrators alongside the TSDF updates.
+type ChannelLayer struct {
+	// Channels are the channel names, in the order of PointCloud.Channels.
+	Channels         []string
+	VoxelSize        float64
+	VoxelSizeInv     float64
+	VoxelsPerSide    int
+	VoxelsPerSideInv float64
+	blocks           *blockHash[*channelBlock]
+}
+
+// NewChannelLayer creates a new ChannelLayer with the named channels on the voxel grid of the TSDF Layer.
+func NewChannelLayer(tsdfLayer *TsdfLayer, channels []string) *ChannelLayer {
+	return &ChannelLayer{
+		Channels:         channels,
+		VoxelSize:        tsdfLayer.VoxelSize,
+		VoxelSizeInv:     tsdfLayer.VoxelSizeInv,
+		VoxelsPerSide:    tsdfLayer.VoxelsPerSide,
+		VoxelsPerSideInv: tsdfLayer.VoxelsPerSideInv,
+		blocks:           newBlockHash[*channelBlock](),
+	}
+}
+
+// Clear removes all blocks from the layer.
+// Thread-safe.
+func (l *ChannelLayer) Clear() {
+	l.blocks.clear()
+}
+
+// GetBlockCount returns the number of blocks allocated in the map
+// Thread-safe.
+func (l *ChannelLayer) GetBlockCount() int {
+	return l.blocks.len()
+}
+
+// GetChannelIndex returns the index of the named channel, -1 if the layer has no such channel.
+func (l *ChannelLayer) GetChannelIndex(name string) int {
+	for c, channel := range l.Channels {
+		if channel == name {
+			return c
+		}
+	}
+	return -1
+}
+
+// fuse adds the channel samples of the point at index j of the point cloud to the voxel at the global voxel index.
+// Missing channels and NaN samples are skipped.
+// Allocates the block if needed.
+// Thread-safe.
+func (l *ChannelLayer) fuse(config *Config, globalVoxelIndex IndexType, pointCloud *PointCloud, j int, weight float64) {
+	if weight <= 0 {
+		return
+	}
+	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, l.VoxelsPerSideInv)
+	block := l.blocks.getOrCreate(blockIndex, func() *channelBlock {
+		voxels := make([]voxelArray[channelVoxel], len(l.Channels))
+		for c := range voxels {
+			voxels[c] = newVoxelArray[channelVoxel](l.VoxelsPerSide)
+		}
+		return &channelBlock{Index: blockIndex, voxels: voxels}
+	})
+	voxelIndex := getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, l.VoxelsPerSide)
+
+	block.Lock()
+	defer block.Unlock()
+	for c := range block.voxels {
+		value := pointCloud.getChannel(c, j)
+		if math.IsNaN(float64(value)) {
+			continue
+		}
+		block.voxels[c].at(voxelIndex).fuse(value, float32(weight), float32(config.MaxWeight))
+	}
+}
+
+// getValue returns the fused value of the channel at the global voxel index.
+// Returns false if the voxel has no samples of the channel.
+// Thread-safe.
+func (l *ChannelLayer) getValue(channel int, globalVoxelIndex IndexType) (float32, bool) {
+	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, l.VoxelsPerSideInv)
+	block, ok := l.blocks.get(blockIndex)
+	if !ok {
+		return 0, false
+	}
+	voxelIndex := getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, l.VoxelsPerSide)
+
+	block.RLock()
+	defer block.RUnlock()
+	voxel := block.voxels[channel].at(voxelIndex)
+	return voxel.value, voxel.weight > 0
+}
+
+// GetValue returns the fused value of the named channel in the voxel containing the point.
+// Returns false if the layer has no such channel or the voxel has no samples of it.
+// Thread-safe.
+func (l *ChannelLayer) GetValue(name string, point Point) (float32, bool) {
+	channel := l.GetChannelIndex(name)
+	if channel < 0 {
+		return 0, false
+	}
+	return l.getValue(channel, getGridIndexFromPoint(point, l.VoxelSizeInv))
+}
+
+// channelColor maps the value to a color of the colormap, clamping it to the range [minValue, maxValue].
+func channelColor(value, minValue, maxValue float64, colormap string) Color {
+	t := math.Max(0, math.Min(1, (value-minValue)/(maxValue-minValue)))
+	var r, g, b float64
+	switch colormap {
+	case ColormapJet:
+		r = math.Min(4*t-1.5, -4*t+4.5)
+		g = math.Min(4*t-0.5, -4*t+3.5)
+		b = math.Min(4*t+0.5, -4*t+2.5)
+	case ColormapHot:
+		r, g, b = 3*t, 3*t-1, 3*t-2
+	default:
+		r, g, b = t, t, t
+	}
+	clamp := func(x float64) uint8 {
+		return uint8(math.Round(255 * math.Max(0, math.Min(1, x))))
+	}
+	return Color{clamp(r), clamp(g), clamp(b)}
+}
@@ -0,0 +1,88 @@
+package voxblox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelVoxelFuse(t *testing.T) {
+	var voxel channelVoxel
+	voxel.fuse(10, 1, 3)
+	assert.Equal(t, float32(10), voxel.value)
+	voxel.fuse(40, 2, 3)
+	assert.Equal(t, float32(30), voxel.value)
+	assert.Equal(t, float32(3), voxel.weight)
+
+	// The capped weight keeps the average responsive.
+	voxel.fuse(90, 3, 3)
+	assert.Equal(t, float32(60), voxel.value)
+	assert.Equal(t, float32(3), voxel.weight)
+}
+
+func TestChannelColor(t *testing.T) {
+	assert.Equal(t, Color{0, 0, 0}, channelColor(0, 0, 10, ColormapGray))
+	assert.Equal(t, Color{128, 128, 128}, channelColor(5, 0, 10, ColormapGray))
+	assert.Equal(t, Color{255, 255, 255}, channelColor(20, 0, 10, ColormapGray))
+
+	assert.Equal(t, Color{0, 0, 128}, channelColor(-5, 0, 10, ColormapJet))
+	assert.Equal(t, Color{128, 255, 128}, channelColor(5, 0, 10, ColormapJet))
+	assert.Equal(t, Color{128, 0, 0}, channelColor(10, 0, 10, ColormapJet))
+
+	assert.Equal(t, Color{0, 0, 0}, channelColor(0, 0, 10, ColormapHot))
+	assert.Equal(t, Color{255, 128, 0}, channelColor(5, 0, 10, ColormapHot))
+	assert.Equal(t, Color{255, 255, 255}, channelColor(10, 0, 10, ColormapHot))
+}
+
+func TestChannelMapping(t *testing.T) {
+	channelConfig := config
+	channelConfig.VoxelChannels = []string{"intensity", "temperature"}
+	channelConfig.MeshColorMode = MeshColorModeChannel
+	channelConfig.MeshColorChannel = "intensity"
+	channelConfig.MeshColormap = ColormapGray
+	channelConfig.MeshChannelMax = 255
+	tsdfLayer := NewTsdfLayer(channelConfig.VoxelSize, channelConfig.VoxelsPerSide)
+	channelLayer := NewChannelLayer(tsdfLayer, channelConfig.VoxelChannels)
+	integrator := NewMergedTsdfIntegrator(&channelConfig, tsdfLayer)
+	integrator.ChannelLayer = channelLayer
+
+	// The cylinder has intensity 100 and the ground 200, the temperature field is missing.
+	pointCloud := getTransformedPointCloud(poses[0])
+	pointCloud.Channels = [][]float32{make([]float32, len(pointCloud.Points)), nil}
+	for j, color := range pointCloud.Colors {
+		if color == ColorRed {
+			pointCloud.Channels[0][j] = 100
+		} else {
+			pointCloud.Channels[0][j] = 200
+		}
+	}
+	integrator.IntegratePointCloud(poses[0], pointCloud)
+	assert.Greater(t, channelLayer.GetBlockCount(), 0)
+
+	value, ok := channelLayer.GetValue("intensity", Point{0.05, 2.05, 2.05})
+	assert.True(t, ok)
+	assert.InDelta(t, 100, value, 1e-3)
+	value, ok = channelLayer.GetValue("intensity", Point{0.05, 4.05, 0.05})
+	assert.True(t, ok)
+	assert.InDelta(t, 200, value, 1e-3)
+	_, ok = channelLayer.GetValue("temperature", Point{0.05, 2.05, 2.05})
+	assert.False(t, ok)
+	_, ok = channelLayer.GetValue("reflectivity", Point{0.05, 2.05, 2.05})
+	assert.False(t, ok)
+
+	meshLayer := NewMeshLayer(tsdfLayer)
+	meshIntegrator := NewMeshIntegrator(channelConfig, tsdfLayer, meshLayer)
+	meshIntegrator.ChannelLayer = channelLayer
+	meshIntegrator.Integrate()
+
+	colorCounts := make(map[Color]int)
+	for _, meshBlock := range meshLayer.GetBlocks() {
+		vertices, _, colors := meshBlock.GetMesh()
+		assert.Len(t, colors, len(vertices))
+		for _, color := range colors {
+			colorCounts[color]++
+		}
+	}
+	assert.Greater(t, colorCounts[Color{100, 100, 100}], 0)
+	assert.Greater(t, colorCounts[Color{200, 200, 200}], 0)
+}
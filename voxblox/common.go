@@ -1,8 +1,9 @@
 package voxblox
 
 import (
-	"log"
+	"log/slog"
 	"math"
+	"sync"
 	"time"
 
 	"github.com/ungerik/go3d/float64/vec3"
@@ -23,11 +24,43 @@ var (
 )
 
 // PointCloud is a collection of points
+// Stamp is the acquisition time of the cloud, the zero time if unknown.
+// Labels holds the semantic label of every point and is nil for unlabeled clouds.
+// Instances holds the instance of every point, set by the instance segmentation.
+// Channels holds the values of every point per channel of the ChannelLayer, nil for missing channels.
 type PointCloud struct {
-	Width  int
-	Height int
-	Points []Point
-	Colors []Color
+	Width     int
+	Height    int
+	Stamp     time.Time
+	Points    []Point
+	Colors    []Color
+	Labels    []Label
+	Instances []InstanceID
+	Channels  [][]float32
+}
+
+// getLabel returns the label of the point at the given index.
+func (p *PointCloud) getLabel(j int) Label {
+	if p.Labels == nil {
+		return LabelUnknown
+	}
+	return p.Labels[j]
+}
+
+// getInstance returns the instance of the point at the given index.
+func (p *PointCloud) getInstance(j int) InstanceID {
+	if p.Instances == nil {
+		return InstanceUnknown
+	}
+	return p.Instances[j]
+}
+
+// getChannel returns the value of the channel of the point at the given index, NaN if the channel is missing.
+func (p *PointCloud) getChannel(c int, j int) float32 {
+	if c >= len(p.Channels) || p.Channels[c] == nil {
+		return float32(math.NaN())
+	}
+	return p.Channels[c][j]
 }
 
 // Point is 3x1 vector
@@ -66,10 +99,38 @@ func sgn(a float64) int {
 	return 0
 }
 
+// DurationObserver receives the durations measured by TimeTrack.
+type DurationObserver interface {
+	ObserveDuration(name string, elapsed time.Duration)
+}
+
+var durationObserver struct {
+	sync.RWMutex
+	observer DurationObserver
+}
+
+// SetDurationObserver sets the observer called by TimeTrack.
+// A nil observer disables it.
+// Thread-safe.
+func SetDurationObserver(observer DurationObserver) {
+	durationObserver.Lock()
+	defer durationObserver.Unlock()
+	durationObserver.observer = observer
+}
+
 // TimeTrack is a helper function for timing the execution of a function.
-func TimeTrack(start time.Time, name string) {
+// The duration is logged at debug level with the given attributes and
+// passed to the DurationObserver if one is set.
+func TimeTrack(logger *slog.Logger, start time.Time, name string, args ...any) {
 	elapsed := time.Since(start)
-	log.Printf("%s: %s", name, elapsed)
+	logger.Debug(name, append([]any{"duration", elapsed}, args...)...)
+
+	durationObserver.RLock()
+	observer := durationObserver.observer
+	durationObserver.RUnlock()
+	if observer != nil {
+		observer.ObserveDuration(name, elapsed)
+	}
 }
 
 // IndexToPoint converts an Index to a point.
@@ -168,6 +229,16 @@ func blendTwoColors(
 	return Color{newR, newG, newB}
 }
 
+// ageColor maps the age of an observation to a color
+// going from green for a fresh observation to red at maxAge.
+func ageColor(age time.Duration, maxAge time.Duration) Color {
+	ratio := 1.0
+	if maxAge > 0 {
+		ratio = math.Min(math.Max(age.Seconds()/maxAge.Seconds(), 0), 1)
+	}
+	return Color{uint8(math.Round(255 * ratio)), uint8(math.Round(255 * (1 - ratio))), 0}
+}
+
 // splitPointCloud splits a PointCloud in to a slice of smaller PointClouds
 // divided by the chunk number.
 func splitPointCloud(
@@ -178,9 +249,24 @@ func splitPointCloud(
 	chunks := make([]PointCloud, chunkCount)
 	for i := 0; i < chunkCount; i++ {
 		chunks[i] = PointCloud{
+			Stamp:  pointCloud.Stamp,
 			Points: pointCloud.Points[i*chunkSize : (i+1)*chunkSize],
 			Colors: pointCloud.Colors[i*chunkSize : (i+1)*chunkSize],
 		}
+		if pointCloud.Labels != nil {
+			chunks[i].Labels = pointCloud.Labels[i*chunkSize : (i+1)*chunkSize]
+		}
+		if pointCloud.Instances != nil {
+			chunks[i].Instances = pointCloud.Instances[i*chunkSize : (i+1)*chunkSize]
+		}
+		if pointCloud.Channels != nil {
+			chunks[i].Channels = make([][]float32, len(pointCloud.Channels))
+			for c, values := range pointCloud.Channels {
+				if values != nil {
+					chunks[i].Channels[c] = values[i*chunkSize : (i+1)*chunkSize]
+				}
+			}
+		}
 	}
 	return chunks
 }
@@ -1,7 +1,10 @@
 package voxblox
 
 import (
+	"bytes"
+	"log/slog"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -50,3 +53,41 @@ func TestBlendTwoColors(t *testing.T) {
 		Color{255, 255, 255},
 	)
 }
+
+type recordingObserver struct {
+	names []string
+}
+
+func (o *recordingObserver) ObserveDuration(name string, _ time.Duration) {
+	o.names = append(o.names, name)
+}
+
+func TestAgeColor(t *testing.T) {
+	assert.Equal(t, Color{0, 255, 0}, ageColor(0, time.Minute))
+	assert.Equal(t, Color{128, 128, 0}, ageColor(30*time.Second, time.Minute))
+	assert.Equal(t, Color{255, 0, 0}, ageColor(2*time.Minute, time.Minute))
+	assert.Equal(t, Color{0, 255, 0}, ageColor(-time.Second, time.Minute))
+}
+
+func TestTimeTrackObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	SetDurationObserver(observer)
+	TimeTrack(slog.Default(), time.Now(), "Test")
+	SetDurationObserver(nil)
+	TimeTrack(slog.Default(), time.Now(), "Ignored")
+	assert.Equal(t, []string{"Test"}, observer.names)
+}
+
+func TestTimeTrackLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	TimeTrack(logger, time.Now(), "Test", "points", 3)
+	assert.Contains(t, buf.String(), "level=DEBUG msg=Test duration=")
+	assert.Contains(t, buf.String(), "points=3")
+
+	// Timings are silenced above debug level.
+	buf.Reset()
+	logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	TimeTrack(logger, time.Now(), "Test")
+	assert.Empty(t, buf.String())
+}
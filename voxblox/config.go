@@ -3,20 +3,79 @@ package voxblox
 import (
 	"fmt"
 	"io/ioutil"
+	"log/slog"
+	"math/bits"
 	"runtime"
+	"time"
 
 	"github.com/ungerik/go3d/float64/quaternion"
 	"gopkg.in/yaml.v3"
 )
 
+// Drop policies for the integration queue.
+const (
+	// DropPolicyLatest drops the oldest queued cloud so the latest cloud is integrated.
+	DropPolicyLatest = "latest"
+	// DropPolicyBlock blocks the producer until the queue has room.
+	DropPolicyBlock = "block"
+)
+
+// Mesh color modes.
+const (
+	// MeshColorModeColor colors the mesh with the fused point cloud colors.
+	MeshColorModeColor = "color"
+	// MeshColorModeAge colors the mesh by the time since the surface was last observed.
+	MeshColorModeAge = "age"
+	// MeshColorModeLabel colors the mesh by the majority semantic label.
+	MeshColorModeLabel = "label"
+	// MeshColorModeInstance colors the mesh by the majority object instance.
+	MeshColorModeInstance = "instance"
+	// MeshColorModeChannel colors the mesh by a fused voxel channel through a colormap.
+	MeshColorModeChannel = "channel"
+)
+
 type Config struct {
 	// ROS
 	RosMaster        string       `yaml:"ros_master"`
 	TopicPointCloud2 string       `yaml:"topic_pointcloud2"`
 	TopicTransform   string       `yaml:"topic_transform"`
+	TopicOdometry    string       `yaml:"topic_odometry"`
+	TopicPose        string       `yaml:"topic_pose"`
+	WorldFrame       string       `yaml:"world_frame"`
 	Translation      Point        `yaml:"translation"`
 	Rotation         quaternion.T `yaml:"rotation"`
 
+	// Transform buffering.
+	TransformMaxInterpolation time.Duration `yaml:"transform_max_interpolation"`
+	TransformMaxExtrapolation time.Duration `yaml:"transform_max_extrapolation"`
+	TransformBufferDuration   time.Duration `yaml:"transform_buffer_duration"`
+	PendingCloudMaxWait       time.Duration `yaml:"pending_cloud_max_wait"`
+	PendingCloudQueueSize     int           `yaml:"pending_cloud_queue_size"`
+
+	// ROS publishers.
+	TopicMesh              string        `yaml:"topic_mesh"`
+	TopicMeshMarkers       string        `yaml:"topic_mesh_markers"`
+	TopicSurfacePointCloud string        `yaml:"topic_surface_pointcloud"`
+	TopicTsdfSlice         string        `yaml:"topic_tsdf_slice"`
+	TsdfSliceLevel         float64       `yaml:"tsdf_slice_level"`
+	PublishPeriod          time.Duration `yaml:"publish_period"`
+
+	// Integration pipeline.
+	IntegrationQueueSize  int           `yaml:"integration_queue_size"`
+	IntegrationDropPolicy string        `yaml:"integration_drop_policy"`
+	MeshUpdatePeriod      time.Duration `yaml:"mesh_update_period"`
+
+	// Output.
+	OutputPath string `yaml:"output_path"`
+
+	// Metrics.
+	MetricsAddress string `yaml:"metrics_address"`
+
+	// Logging.
+	LogLevel slog.Level `yaml:"log_level"`
+	// Logger receives the library logs, slog.Default is used if nil.
+	Logger *slog.Logger `yaml:"-"`
+
 	// TSDF configuration.
 	VoxelSize                   float64 `yaml:"voxel_size"`
 	VoxelsPerSide               int     `yaml:"voxels_per_side"`
@@ -31,10 +90,55 @@ type Config struct {
 	StartVoxelSubsamplingFactor float64 `yaml:"start_voxel_subsampling_factor"`
 	MaxConsecutiveRayCollisions int     `yaml:"max_consecutive_ray_collisions"`
 	Threads                     int     `yaml:"integrator_threads"`
+	BatchVoxelUpdates           bool    `yaml:"batch_voxel_updates"`
+
+	// Dynamic mapping configuration.
+	DynamicMapping bool          `yaml:"dynamic_mapping"`
+	FreeSpaceDecay float64       `yaml:"free_space_decay"`
+	WeightHalfLife time.Duration `yaml:"weight_half_life"`
+
+	// Semantic mapping configuration.
+	SemanticMapping bool `yaml:"semantic_mapping"`
+
+	// Instance segmentation configuration.
+	InstanceSegmentation     bool    `yaml:"instance_segmentation"`
+	InstanceMinSegmentPoints int     `yaml:"instance_min_segment_points"`
+	InstanceOverlapThreshold float64 `yaml:"instance_overlap_threshold"`
+
+	// Voxel channel configuration.
+	VoxelChannels []string `yaml:"voxel_channels"`
+
+	// Map age configuration.
+	ObservationTracking bool `yaml:"observation_tracking"`
+
+	// Multi-resolution TSDF configuration.
+	ResolutionLevels              int     `yaml:"resolution_levels"`
+	ResolutionLevelDistance       float64 `yaml:"resolution_level_distance"`
+	ResolutionComplexityThreshold float64 `yaml:"resolution_complexity_threshold"`
 
 	// Mesh configuration.
-	UseColor  bool    `yaml:"use_color"`
-	MinWeight float64 `yaml:"min_weight"`
+	UseColor      bool          `yaml:"use_color"`
+	MinWeight     float64       `yaml:"min_weight"`
+	MeshColorMode string        `yaml:"mesh_color_mode"`
+	MeshAgeRange  time.Duration `yaml:"mesh_age_range"`
+	// MeshDecimationError is the maximum RMS error in meters of the mesh decimation, 0 disables it.
+	MeshDecimationError float64 `yaml:"mesh_decimation_error"`
+	// MeshMaxPrimitiveVertices splits the glTF mesh blocks into primitives of at most this many vertices,
+	// 0 writes a single primitive per block.
+	MeshMaxPrimitiveVertices int `yaml:"mesh_max_primitive_vertices"`
+	// Channel color mode configuration.
+	MeshColorChannel string  `yaml:"mesh_color_channel"`
+	MeshColormap     string  `yaml:"mesh_colormap"`
+	MeshChannelMin   float64 `yaml:"mesh_channel_min"`
+	MeshChannelMax   float64 `yaml:"mesh_channel_max"`
+}
+
+// GetLogger returns the configured Logger or slog.Default if none is set.
+func (c *Config) GetLogger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
 }
 
 // ReadConfig reads a yaml config file and returns a Config struct.
@@ -51,6 +155,10 @@ func ReadConfig(filename string) (Config, error) {
 		return *config, err
 	}
 
+	if config.TopicTransform == "" && config.TopicOdometry == "" && config.TopicPose == "" {
+		return *config, fmt.Errorf("at least one transform, odometry or pose topic is required")
+	}
+
 	if config.VoxelSize <= 0 {
 		return *config, fmt.Errorf("voxel size must be positive")
 	}
@@ -83,6 +191,218 @@ func ReadConfig(filename string) (Config, error) {
 		return *config, fmt.Errorf("min weight must be positive")
 	}
 
+	if config.TransformMaxInterpolation < 0 {
+		return *config, fmt.Errorf("transform max interpolation must be positive")
+	}
+	if config.TransformMaxInterpolation == 0 {
+		config.TransformMaxInterpolation = 100 * time.Millisecond
+	}
+
+	if config.TransformMaxExtrapolation < 0 {
+		return *config, fmt.Errorf("transform max extrapolation must be positive")
+	}
+
+	if config.TransformBufferDuration < 0 {
+		return *config, fmt.Errorf("transform buffer duration must be positive")
+	}
+	if config.TransformBufferDuration == 0 {
+		config.TransformBufferDuration = 10 * time.Second
+	}
+
+	if config.PendingCloudMaxWait < 0 {
+		return *config, fmt.Errorf("pending cloud max wait must be positive")
+	}
+	if config.PendingCloudMaxWait == 0 {
+		config.PendingCloudMaxWait = time.Second
+	}
+
+	if config.PendingCloudQueueSize < 0 {
+		return *config, fmt.Errorf("pending cloud queue size must be positive")
+	}
+	if config.PendingCloudQueueSize == 0 {
+		config.PendingCloudQueueSize = 10
+	}
+
+	if config.WorldFrame == "" {
+		config.WorldFrame = "world"
+	}
+
+	if config.PublishPeriod < 0 {
+		return *config, fmt.Errorf("publish period must be positive")
+	}
+	if config.PublishPeriod == 0 {
+		config.PublishPeriod = time.Second
+	}
+
+	if config.IntegrationQueueSize < 0 {
+		return *config, fmt.Errorf("integration queue size must be positive")
+	}
+	if config.IntegrationQueueSize == 0 {
+		config.IntegrationQueueSize = 4
+	}
+
+	switch config.IntegrationDropPolicy {
+	case "":
+		config.IntegrationDropPolicy = DropPolicyLatest
+	case DropPolicyLatest, DropPolicyBlock:
+	default:
+		return *config, fmt.Errorf(
+			"integration drop policy must be %q or %q",
+			DropPolicyLatest,
+			DropPolicyBlock,
+		)
+	}
+
+	if config.MeshUpdatePeriod < 0 {
+		return *config, fmt.Errorf("mesh update period must be positive")
+	}
+	if config.MeshUpdatePeriod == 0 {
+		config.MeshUpdatePeriod = time.Second
+	}
+
+	if config.OutputPath == "" {
+		config.OutputPath = "output"
+	}
+
+	if config.FreeSpaceDecay < 0 || config.FreeSpaceDecay > 1 {
+		return *config, fmt.Errorf("free space decay must be between 0 and 1")
+	}
+	if config.FreeSpaceDecay == 0 {
+		config.FreeSpaceDecay = 0.5
+	}
+
+	if config.WeightHalfLife < 0 {
+		return *config, fmt.Errorf("weight half life must be positive")
+	}
+
+	if config.ResolutionLevels < 0 {
+		return *config, fmt.Errorf("resolution levels must be positive")
+	}
+	if config.ResolutionLevels == 0 {
+		config.ResolutionLevels = 1
+	}
+	// Every level halves the voxels per side, so the coarsest level has at least one voxel per side.
+	if maxLevels := bits.Len(uint(config.VoxelsPerSide)); config.ResolutionLevels > maxLevels {
+		return *config, fmt.Errorf(
+			"resolution levels must be at most %d for %d voxels per side",
+			maxLevels,
+			config.VoxelsPerSide,
+		)
+	}
+	if config.VoxelsPerSide%(1<<(config.ResolutionLevels-1)) != 0 {
+		return *config, fmt.Errorf(
+			"voxels per side must be divisible by %d for %d resolution levels",
+			1<<(config.ResolutionLevels-1),
+			config.ResolutionLevels,
+		)
+	}
+
+	if config.ResolutionLevelDistance < 0 {
+		return *config, fmt.Errorf("resolution level distance must be positive")
+	}
+	if config.ResolutionLevelDistance == 0 {
+		config.ResolutionLevelDistance = 2.0
+	}
+
+	if config.ResolutionComplexityThreshold < 0 || config.ResolutionComplexityThreshold > 1 {
+		return *config, fmt.Errorf("resolution complexity threshold must be between 0 and 1")
+	}
+
+	if config.SemanticMapping && config.ResolutionLevels > 1 {
+		return *config, fmt.Errorf("semantic mapping is not supported with multiple resolution levels")
+	}
+
+	if config.InstanceSegmentation && config.ResolutionLevels > 1 {
+		return *config, fmt.Errorf("instance segmentation is not supported with multiple resolution levels")
+	}
+	if config.InstanceMinSegmentPoints < 0 {
+		return *config, fmt.Errorf("instance min segment points must be positive")
+	}
+	if config.InstanceMinSegmentPoints == 0 {
+		config.InstanceMinSegmentPoints = 50
+	}
+	if config.InstanceOverlapThreshold < 0 || config.InstanceOverlapThreshold > 1 {
+		return *config, fmt.Errorf("instance overlap threshold must be between 0 and 1")
+	}
+	if config.InstanceOverlapThreshold == 0 {
+		config.InstanceOverlapThreshold = 0.2
+	}
+
+	channels := make(map[string]struct{}, len(config.VoxelChannels))
+	for _, channel := range config.VoxelChannels {
+		if channel == "" {
+			return *config, fmt.Errorf("voxel channel names must not be empty")
+		}
+		if _, ok := channels[channel]; ok {
+			return *config, fmt.Errorf("voxel channel %q is listed twice", channel)
+		}
+		channels[channel] = struct{}{}
+	}
+	if len(config.VoxelChannels) > 0 && config.ResolutionLevels > 1 {
+		return *config, fmt.Errorf("voxel channels are not supported with multiple resolution levels")
+	}
+
+	if config.ObservationTracking && config.ResolutionLevels > 1 {
+		return *config, fmt.Errorf("observation tracking is not supported with multiple resolution levels")
+	}
+
+	switch config.MeshColorMode {
+	case "":
+		config.MeshColorMode = MeshColorModeColor
+	case MeshColorModeColor:
+	case MeshColorModeAge:
+		if !config.ObservationTracking {
+			return *config, fmt.Errorf("mesh color mode %q requires observation tracking", MeshColorModeAge)
+		}
+	case MeshColorModeLabel:
+		if !config.SemanticMapping {
+			return *config, fmt.Errorf("mesh color mode %q requires semantic mapping", MeshColorModeLabel)
+		}
+	case MeshColorModeInstance:
+		if !config.InstanceSegmentation {
+			return *config, fmt.Errorf("mesh color mode %q requires instance segmentation", MeshColorModeInstance)
+		}
+	case MeshColorModeChannel:
+		if _, ok := channels[config.MeshColorChannel]; !ok {
+			return *config, fmt.Errorf("mesh color channel %q is not a voxel channel", config.MeshColorChannel)
+		}
+		if config.MeshChannelMax <= config.MeshChannelMin {
+			return *config, fmt.Errorf("mesh channel max must be greater than mesh channel min")
+		}
+	default:
+		return *config, fmt.Errorf(
+			"mesh color mode must be %q, %q, %q, %q or %q",
+			MeshColorModeColor,
+			MeshColorModeAge,
+			MeshColorModeLabel,
+			MeshColorModeInstance,
+			MeshColorModeChannel,
+		)
+	}
+
+	switch config.MeshColormap {
+	case "":
+		config.MeshColormap = ColormapJet
+	case ColormapGray, ColormapJet, ColormapHot:
+	default:
+		return *config, fmt.Errorf("mesh colormap must be %q, %q or %q", ColormapGray, ColormapJet, ColormapHot)
+	}
+
+	if config.MeshAgeRange < 0 {
+		return *config, fmt.Errorf("mesh age range must be positive")
+	}
+	if config.MeshAgeRange == 0 {
+		config.MeshAgeRange = time.Minute
+	}
+
+	if config.MeshDecimationError < 0 {
+		return *config, fmt.Errorf("mesh decimation error must be positive")
+	}
+
+	if config.MeshMaxPrimitiveVertices < 0 || config.MeshMaxPrimitiveVertices > 0 && config.MeshMaxPrimitiveVertices < 3 {
+		return *config, fmt.Errorf("mesh max primitive vertices must be 0 or at least 3")
+	}
+
 	if config.Threads <= 0 {
 		config.Threads = runtime.NumCPU()
 	}
@@ -1,8 +1,13 @@
 package voxblox
 
 import (
+	"log/slog"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,6 +19,26 @@ func TestReadConfigValid(t *testing.T) {
 	assert.Equal(t, 16, config.VoxelsPerSide, "voxels per side should be 16")
 	assert.Equal(t, 0.1, config.MinRange, "min range should be 0.1")
 	assert.Equal(t, 5.0, config.MaxRange, "max range should be 5.0")
+	assert.Equal(
+		t,
+		100*time.Millisecond,
+		config.TransformMaxInterpolation,
+		"transform max interpolation should be 100ms",
+	)
+	assert.Equal(t, 0.5, config.FreeSpaceDecay, "free space decay should be 0.5")
+	assert.Equal(t, 30*time.Second, config.WeightHalfLife, "weight half life should be 30s")
+	assert.Equal(t, 1, config.ResolutionLevels, "resolution levels should be 1")
+	assert.Equal(t, 2.0, config.ResolutionLevelDistance, "resolution level distance should be 2.0")
+	assert.Equal(t, 50, config.InstanceMinSegmentPoints, "instance min segment points should be 50")
+	assert.Equal(t, 0.2, config.InstanceOverlapThreshold, "instance overlap threshold should be 0.2")
+	assert.Equal(t, MeshColorModeColor, config.MeshColorMode, "mesh color mode should be color")
+	assert.Equal(t, time.Minute, config.MeshAgeRange, "mesh age range should be 60s")
+	assert.False(t, config.ObservationTracking, "observation tracking should be disabled")
+	assert.Empty(t, config.VoxelChannels, "voxel channels should be empty")
+	assert.Equal(t, ColormapJet, config.MeshColormap, "mesh colormap should be jet")
+	assert.Equal(t, 255.0, config.MeshChannelMax, "mesh channel max should be 255")
+	assert.Equal(t, 0, config.MeshMaxPrimitiveVertices, "mesh max primitive vertices should be 0")
+	assert.Equal(t, slog.LevelDebug, config.LogLevel, "log level should be debug")
 	assert.Equal(
 		t,
 		runtime.NumCPU(),
@@ -21,3 +46,21 @@ func TestReadConfigValid(t *testing.T) {
 		"num workers should be equal to number of cores",
 	)
 }
+
+func TestReadConfigResolutionLevels(t *testing.T) {
+	data, err := os.ReadFile("../testdata/test.yaml")
+	assert.NoError(t, err)
+	readLevels := func(levels string) error {
+		fileName := filepath.Join(t.TempDir(), "config.yaml")
+		yaml := strings.Replace(string(data), "resolution_levels: 1 ", "resolution_levels: "+levels+" ", 1)
+		assert.NoError(t, os.WriteFile(fileName, []byte(yaml), 0o644))
+		_, err := ReadConfig(fileName)
+		return err
+	}
+
+	// 16 voxels per side allow up to 5 levels.
+	assert.NoError(t, readLevels("5"))
+	assert.Error(t, readLevels("6"))
+	assert.Error(t, readLevels("65"))
+	assert.Error(t, readLevels("1000"))
+}
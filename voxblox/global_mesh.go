@@ -0,0 +1,76 @@
+package voxblox
+
+import (
+	"slices"
+)
+
+// kVertexWeldTolerance is the distance in voxels below which mesh vertices are welded.
+const kVertexWeldTolerance = 1e-4
+
+// GlobalMesh is a single mesh assembled from all blocks of a MeshLayer.
+// Vertex attributes are nil unless every block has them.
+type GlobalMesh struct {
+	Vertices  []Point
+	Triangles [][3]int
+	Colors    []Color
+	Labels    []Label
+	Instances []InstanceID
+}
+
+// GetGlobalMesh assembles the mesh blocks of the mesh layer into a single mesh, ordered by block index.
+// The vertices on the seams between blocks are welded so the triangles of neighboring blocks share them.
+// Thread-safe.
+func GetGlobalMesh(meshLayer *MeshLayer) GlobalMesh {
+	blocks := meshLayer.GetBlocks()
+	blockIndexes := make([]IndexType, 0, len(blocks))
+	for blockIndex := range blocks {
+		blockIndexes = append(blockIndexes, blockIndex)
+	}
+	slices.SortFunc(blockIndexes, func(a, b IndexType) int {
+		return slices.Compare(a[:], b[:])
+	})
+
+	var mesh GlobalMesh
+	hasColors, hasLabels, hasInstances := true, true, true
+	for _, blockIndex := range blockIndexes {
+		meshBlock := blocks[blockIndex]
+		meshBlock.RLock()
+		offset := len(mesh.Vertices)
+		mesh.Vertices = append(mesh.Vertices, meshBlock.vertices...)
+		for _, triangle := range meshBlock.triangles {
+			mesh.Triangles = append(mesh.Triangles, [3]int{
+				triangle[0] + offset,
+				triangle[1] + offset,
+				triangle[2] + offset,
+			})
+		}
+		hasColors = hasColors && len(meshBlock.colors) == len(meshBlock.vertices)
+		hasLabels = hasLabels && len(meshBlock.labels) == len(meshBlock.vertices)
+		hasInstances = hasInstances && len(meshBlock.instances) == len(meshBlock.vertices)
+		if hasColors {
+			mesh.Colors = append(mesh.Colors, meshBlock.colors...)
+		}
+		if hasLabels {
+			mesh.Labels = append(mesh.Labels, meshBlock.labels...)
+		}
+		if hasInstances {
+			mesh.Instances = append(mesh.Instances, meshBlock.instances...)
+		}
+		meshBlock.RUnlock()
+	}
+	if len(mesh.Vertices) == 0 {
+		return GlobalMesh{}
+	}
+
+	vertexCount := len(mesh.Vertices)
+	var originalIndexes []int
+	mesh.Vertices, mesh.Triangles, originalIndexes = weldVertices(
+		mesh.Vertices,
+		mesh.Triangles,
+		kVertexWeldTolerance*meshLayer.VoxelSize,
+	)
+	mesh.Colors = remapVertexAttributes(mesh.Colors, vertexCount, originalIndexes)
+	mesh.Labels = remapVertexAttributes(mesh.Labels, vertexCount, originalIndexes)
+	mesh.Instances = remapVertexAttributes(mesh.Instances, vertexCount, originalIndexes)
+	return mesh
+}
@@ -0,0 +1,76 @@
+package voxblox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countEdges returns the number of triangles using every undirected edge of the mesh.
+func countEdges(triangles [][3]int) map[[2]int]int {
+	edges := make(map[[2]int]int)
+	for _, triangle := range triangles {
+		for k := 0; k < 3; k++ {
+			a, b := triangle[k], triangle[(k+1)%3]
+			edges[[2]int{min(a, b), max(a, b)}]++
+		}
+	}
+	return edges
+}
+
+// assertClosedMesh asserts that the mesh is a closed manifold surface of genus 0.
+func assertClosedMesh(t *testing.T, vertices []Point, triangles [][3]int) {
+	assert.NotEmpty(t, triangles)
+	edges := countEdges(triangles)
+	for edge, count := range edges {
+		assert.Equal(t, 2, count, "edge %v from %v to %v", edge, vertices[edge[0]], vertices[edge[1]])
+	}
+	// The Euler characteristic of a sphere.
+	assert.Equal(t, 2, len(vertices)-len(edges)+len(triangles))
+}
+
+// sphereBlockIndexes are the blocks around the origin, the lower half in x first.
+var sphereBlockIndexes = []IndexType{
+	{-1, -1, -1}, {-1, -1, 0}, {-1, 0, -1}, {-1, 0, 0},
+	{0, -1, -1}, {0, -1, 0}, {0, 0, -1}, {0, 0, 0},
+}
+
+func TestGlobalMesh(t *testing.T) {
+	// A sphere around the corner shared by eight blocks.
+	layer := getTestSdfLayer(
+		NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide),
+		sphereSdf(Point{0.01, 0.02, 0.03}, 0.53),
+		sphereBlockIndexes...,
+	)
+	meshLayer := NewMeshLayer(layer)
+	NewMeshIntegrator(config, layer, meshLayer).IntegrateAll()
+
+	blockVertices := 0
+	for _, meshBlock := range meshLayer.GetBlocks() {
+		blockVertices += meshBlock.getVertexCount()
+	}
+	mesh := GetGlobalMesh(meshLayer)
+	assert.Less(t, len(mesh.Vertices), blockVertices)
+	assert.Len(t, mesh.Colors, len(mesh.Vertices))
+	assert.Nil(t, mesh.Labels)
+	assert.Nil(t, mesh.Instances)
+	assertClosedMesh(t, mesh.Vertices, mesh.Triangles)
+
+	assert.Equal(t, GlobalMesh{}, GetGlobalMesh(NewMeshLayer(layer)))
+}
+
+func TestGlobalMeshNewNeighborBlocks(t *testing.T) {
+	// Mesh half of the sphere, then the other half in new blocks.
+	layer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	meshLayer := NewMeshLayer(layer)
+	meshIntegrator := NewMeshIntegrator(config, layer, meshLayer)
+	sphere := sphereSdf(Point{0.01, 0.02, 0.03}, 0.53)
+	getTestSdfLayer(layer, sphere, sphereBlockIndexes[:4]...)
+	meshIntegrator.Integrate()
+	getTestSdfLayer(layer, sphere, sphereBlockIndexes[4:]...)
+	meshIntegrator.Integrate()
+
+	// The lower half re-meshed its border cubes reaching into the new blocks.
+	mesh := GetGlobalMesh(meshLayer)
+	assertClosedMesh(t, mesh.Vertices, mesh.Triangles)
+}
@@ -1,7 +1,6 @@
 package voxblox
 
 import (
-	"fmt"
 	"math/rand"
 	"time"
 
@@ -73,8 +72,8 @@ func getGradient(tsdfLayer *TsdfLayer, globalVoxelIndex IndexType) (Point, bool)
 		for sign := -1; sign <= 1; sign += 2 {
 			neighborIndex := globalVoxelIndex
 			neighborIndex[i] = globalVoxelIndex[i] + sign
-			_, voxel := getBlockAndVoxelFromGlobalVoxelIndexIfExists(tsdfLayer, neighborIndex)
-			if voxel == nil {
+			_, voxel, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(tsdfLayer, neighborIndex)
+			if !ok {
 				return gradient, false
 			}
 			gradient[i] += voxel.getDistance() * float64(sign)
@@ -116,8 +115,8 @@ func matchPoints(tsdfLayer *TsdfLayer, pose Transform, pointCloud *PointCloud) (
 		pointG := pose.transformPoint(point)
 
 		globalVoxelIndex := getGridIndexFromPoint(pointG, tsdfLayer.VoxelSizeInv)
-		block, voxel := getBlockAndVoxelFromGlobalVoxelIndexIfExists(tsdfLayer, globalVoxelIndex)
-		if block == nil || voxel == nil {
+		_, voxel, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(tsdfLayer, globalVoxelIndex)
+		if !ok {
 			continue
 		}
 		distance := voxel.getDistance()
@@ -150,8 +149,16 @@ func vectorToTransform(vector [6]float64) Transform {
 	return Transform{Rotation: rotation, Translation: translation}
 }
 
-func GetIcpTransform(tsdfLayer *TsdfLayer, pose Transform, pointCloud PointCloud) Transform {
-	defer TimeTrack(time.Now(), "ICP")
+// GetIcpTransform returns the correction that aligns the point cloud to the TSDF Layer.
+// The result is logged to the config logger at debug level.
+func GetIcpTransform(
+	config *Config,
+	tsdfLayer *TsdfLayer,
+	pose Transform,
+	pointCloud PointCloud,
+) Transform {
+	logger := config.GetLogger()
+	defer TimeTrack(logger, time.Now(), "ICP", "points", len(pointCloud.Points))
 
 	// Shuffle the point cloud.
 	rand.Seed(time.Now().UnixNano())
@@ -178,6 +185,10 @@ func GetIcpTransform(tsdfLayer *TsdfLayer, pose Transform, pointCloud PointCloud
 		}
 	}
 	temp := vectorToTransform(transform)
-	fmt.Println("ICP transform:", temp)
+	logger.Debug(
+		"ICP transform",
+		"translation", temp.Translation,
+		"rotation", temp.Rotation,
+	)
 	return temp
 }
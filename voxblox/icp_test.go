@@ -90,8 +90,7 @@ func TestGetGradient(t *testing.T) {
 
 	for i := 0; i < 6; i++ {
 		neighborIndex := getGridIndexFromPoint(vec3.Add(&pointG, &offsets[i]), tsdfLayer.VoxelSizeInv)
-		_, neighborVoxel := getBlockAndVoxelFromGlobalVoxelIndex(tsdfLayer, neighborIndex)
-		neighborVoxel.setDistance(distances[i])
+		setTestVoxel(tsdfLayer, neighborIndex, distances[i], 1.0, Color{})
 	}
 
 	globalVoxelIndex := getGridIndexFromPoint(pointG, tsdfLayer.VoxelSizeInv)
@@ -0,0 +1,299 @@
+package voxblox
+
+import (
+	"sort"
+	"sync"
+)
+
+// InstanceID is a persistent object instance identifier.
+type InstanceID = uint32
+
+// InstanceUnknown marks points and voxels which do not belong to an instance.
+const InstanceUnknown InstanceID = 0
+
+// InstanceVoxel is the instance vote histogram of a voxel.
+type InstanceVoxel = voteVoxel[InstanceID]
+
+// InstanceLayer holds the instance votes of the voxels of a TSDF Layer.
+// Incoming point clouds are segmented and their segments associated to
+// the instances of the layer before they are fused by the TSDF integrators.
+type InstanceLayer struct {
+	voteLayer[InstanceID]
+	// Serializes the segmentation and the instance allocation.
+	sync.Mutex
+	lastInstance InstanceID
+}
+
+// NewInstanceLayer creates a new InstanceLayer on the voxel grid of the TSDF Layer.
+func NewInstanceLayer(tsdfLayer *TsdfLayer) *InstanceLayer {
+	return &InstanceLayer{voteLayer: newVoteLayer[InstanceID](tsdfLayer)}
+}
+
+// Clear removes all blocks and instances from the layer.
+// Thread-safe.
+func (l *InstanceLayer) Clear() {
+	l.Lock()
+	defer l.Unlock()
+	l.voteLayer.Clear()
+	l.lastInstance = InstanceUnknown
+}
+
+// GetInstance returns the majority instance of the voxel containing the point.
+// Returns false if the voxel has no votes.
+// Thread-safe.
+func (l *InstanceLayer) GetInstance(point Point) (InstanceID, bool) {
+	return l.getLabel(getGridIndexFromPoint(point, l.VoxelSizeInv))
+}
+
+// segmentCell is an occupied voxel of a segmented point cloud.
+// Points with different labels in the same voxel occupy different cells.
+type segmentCell struct {
+	index IndexType
+	label Label
+}
+
+// segmentMatch is a candidate association of a segment to an instance.
+type segmentMatch struct {
+	segment  int
+	instance InstanceID
+	overlap  int
+}
+
+// findRoot returns the root of the cell in the union-find forest, compressing the path.
+func findRoot(parents []int, cell int) int {
+	for parents[cell] != cell {
+		parents[cell] = parents[parents[cell]]
+		cell = parents[cell]
+	}
+	return cell
+}
+
+// segmentPointCloud segments the point cloud into the connected components of
+// the voxels occupied by its points, split by label, and sets the instance of every point.
+// Every segment is associated to the existing instance it overlaps most in the layer,
+// segments without a sufficient overlap get a new instance.
+// Segments smaller than InstanceMinSegmentPoints and out of range points get InstanceUnknown.
+// Thread-safe.
+func (l *InstanceLayer) segmentPointCloud(config *Config, pose Transform, pointCloud *PointCloud) {
+	l.Lock()
+	defer l.Unlock()
+
+	// Voxelize the valid points in the global frame.
+	cellIndexes := make(map[segmentCell]int)
+	var cells []segmentCell
+	pointCells := make([]int, len(pointCloud.Points))
+	for j, point := range pointCloud.Points {
+		var ray Ray
+		if !validateRay(&ray, point, config.MinRange, config.MaxRange, false) || ray.Length > config.MaxRange {
+			pointCells[j] = -1
+			continue
+		}
+		pointG := pose.transformPoint(point)
+		cell := segmentCell{getGridIndexFromPoint(pointG, l.VoxelSizeInv), pointCloud.getLabel(j)}
+		c, ok := cellIndexes[cell]
+		if !ok {
+			c = len(cells)
+			cellIndexes[cell] = c
+			cells = append(cells, cell)
+		}
+		pointCells[j] = c
+	}
+
+	// Join the cells with their 26 neighbors of the same label.
+	// The lowest cell is the root so segments are ordered by their first point.
+	parents := make([]int, len(cells))
+	for c := range parents {
+		parents[c] = c
+	}
+	for c, cell := range cells {
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for dz := -1; dz <= 1; dz++ {
+					neighbor := segmentCell{
+						IndexType{cell.index[0] + dx, cell.index[1] + dy, cell.index[2] + dz},
+						cell.label,
+					}
+					n, ok := cellIndexes[neighbor]
+					if !ok {
+						continue
+					}
+					rootC, rootN := findRoot(parents, c), findRoot(parents, n)
+					if rootC < rootN {
+						parents[rootN] = rootC
+					} else {
+						parents[rootC] = rootN
+					}
+				}
+			}
+		}
+	}
+
+	// Number the segments and count their points.
+	cellSegments := make([]int, len(cells))
+	rootSegments := make(map[int]int)
+	for c := range cells {
+		root := findRoot(parents, c)
+		segment, ok := rootSegments[root]
+		if !ok {
+			segment = len(rootSegments)
+			rootSegments[root] = segment
+		}
+		cellSegments[c] = segment
+	}
+	segmentPoints := make([]int, len(rootSegments))
+	for _, c := range pointCells {
+		if c >= 0 {
+			segmentPoints[cellSegments[c]]++
+		}
+	}
+
+	// Count the cells of every segment voting for the existing instances.
+	segmentCells := make([]int, len(rootSegments))
+	overlaps := make([]map[InstanceID]int, len(rootSegments))
+	for c, cell := range cells {
+		segment := cellSegments[c]
+		segmentCells[segment]++
+		instance, ok := l.getLabel(cell.index)
+		if !ok {
+			continue
+		}
+		if overlaps[segment] == nil {
+			overlaps[segment] = make(map[InstanceID]int)
+		}
+		overlaps[segment][instance]++
+	}
+
+	// Associate the segments to the instances one to one by descending overlap.
+	var matches []segmentMatch
+	for segment, overlap := range overlaps {
+		if segmentPoints[segment] < config.InstanceMinSegmentPoints {
+			continue
+		}
+		for instance, count := range overlap {
+			if float64(count) >= config.InstanceOverlapThreshold*float64(segmentCells[segment]) {
+				matches = append(matches, segmentMatch{segment, instance, count})
+			}
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].overlap != matches[b].overlap {
+			return matches[a].overlap > matches[b].overlap
+		}
+		if matches[a].segment != matches[b].segment {
+			return matches[a].segment < matches[b].segment
+		}
+		return matches[a].instance < matches[b].instance
+	})
+	segmentInstances := make([]InstanceID, len(rootSegments))
+	matchedInstances := make(map[InstanceID]struct{})
+	for _, match := range matches {
+		if segmentInstances[match.segment] != InstanceUnknown {
+			continue
+		}
+		if _, ok := matchedInstances[match.instance]; ok {
+			continue
+		}
+		segmentInstances[match.segment] = match.instance
+		matchedInstances[match.instance] = struct{}{}
+	}
+	for segment := range segmentInstances {
+		if segmentInstances[segment] == InstanceUnknown && segmentPoints[segment] >= config.InstanceMinSegmentPoints {
+			l.lastInstance++
+			segmentInstances[segment] = l.lastInstance
+		}
+	}
+
+	pointCloud.Instances = make([]InstanceID, len(pointCloud.Points))
+	for j, c := range pointCells {
+		if c >= 0 {
+			pointCloud.Instances[j] = segmentInstances[cellSegments[c]]
+		}
+	}
+}
+
+// InstanceMesh is the mesh of a single instance extracted from a MeshLayer.
+// Min and Max are the corners of the axis-aligned bounding box of the vertices.
+type InstanceMesh struct {
+	Instance  InstanceID
+	Vertices  []Point
+	Triangles [][3]int
+	Colors    []Color
+	Min       Point
+	Max       Point
+}
+
+// GetInstanceMeshes returns the mesh of every instance in the mesh layer, ordered by instance.
+// Every triangle belongs to the majority instance of its vertices, the first vertex on ties.
+// Triangles of InstanceUnknown are skipped.
+func GetInstanceMeshes(meshLayer *MeshLayer) []InstanceMesh {
+	meshes := make(map[InstanceID]*InstanceMesh)
+	for _, meshBlock := range meshLayer.GetBlocks() {
+		meshBlock.RLock()
+		if len(meshBlock.instances) != len(meshBlock.vertices) {
+			meshBlock.RUnlock()
+			continue
+		}
+		vertexIndexes := make(map[InstanceID]map[int]int)
+		for _, triangle := range meshBlock.triangles {
+			instance := triangleInstance(meshBlock.instances, triangle)
+			if instance == InstanceUnknown {
+				continue
+			}
+			mesh, ok := meshes[instance]
+			if !ok {
+				mesh = &InstanceMesh{Instance: instance}
+				meshes[instance] = mesh
+			}
+			indexes, ok := vertexIndexes[instance]
+			if !ok {
+				indexes = make(map[int]int)
+				vertexIndexes[instance] = indexes
+			}
+			var instanceTriangle [3]int
+			for k, vertexIndex := range triangle {
+				index, ok := indexes[vertexIndex]
+				if !ok {
+					index = len(mesh.Vertices)
+					indexes[vertexIndex] = index
+					mesh.addVertex(meshBlock.vertices[vertexIndex], meshBlock.colors, vertexIndex)
+				}
+				instanceTriangle[k] = index
+			}
+			mesh.Triangles = append(mesh.Triangles, instanceTriangle)
+		}
+		meshBlock.RUnlock()
+	}
+
+	instanceMeshes := make([]InstanceMesh, 0, len(meshes))
+	for _, mesh := range meshes {
+		instanceMeshes = append(instanceMeshes, *mesh)
+	}
+	sort.Slice(instanceMeshes, func(a, b int) bool {
+		return instanceMeshes[a].Instance < instanceMeshes[b].Instance
+	})
+	return instanceMeshes
+}
+
+// triangleInstance returns the majority instance of the triangle vertices, the first vertex on ties.
+func triangleInstance(instances []InstanceID, triangle [3]int) InstanceID {
+	a, b, c := instances[triangle[0]], instances[triangle[1]], instances[triangle[2]]
+	if a != b && b == c {
+		return b
+	}
+	return a
+}
+
+// addVertex appends the vertex and its color and grows the bounding box.
+func (m *InstanceMesh) addVertex(vertex Point, colors []Color, vertexIndex int) {
+	if len(m.Vertices) == 0 {
+		m.Min, m.Max = vertex, vertex
+	}
+	for k := 0; k < 3; k++ {
+		m.Min[k] = min(m.Min[k], vertex[k])
+		m.Max[k] = max(m.Max[k], vertex[k])
+	}
+	m.Vertices = append(m.Vertices, vertex)
+	if vertexIndex < len(colors) {
+		m.Colors = append(m.Colors, colors[vertexIndex])
+	}
+}
@@ -0,0 +1,148 @@
+package voxblox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/quaternion"
+)
+
+// getTestInstanceConfig returns the test config with instance segmentation enabled.
+func getTestInstanceConfig() Config {
+	instanceConfig := config
+	instanceConfig.InstanceSegmentation = true
+	instanceConfig.InstanceMinSegmentPoints = 50
+	instanceConfig.InstanceOverlapThreshold = 0.2
+	return instanceConfig
+}
+
+// getLabeledTestPointCloud returns the simulated point cloud of a pose with the cylinder labeled 1 and the ground 2.
+func getLabeledTestPointCloud(pose Transform) PointCloud {
+	pointCloud := getTransformedPointCloud(pose)
+	pointCloud.Labels = make([]Label, len(pointCloud.Points))
+	for j, color := range pointCloud.Colors {
+		if color == ColorRed {
+			pointCloud.Labels[j] = 1
+		} else {
+			pointCloud.Labels[j] = 2
+		}
+	}
+	return pointCloud
+}
+
+func TestSegmentPointCloud(t *testing.T) {
+	instanceConfig := getTestInstanceConfig()
+	instanceConfig.InstanceMinSegmentPoints = 3
+	instanceLayer := NewInstanceLayer(NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide))
+
+	// Two connected lines, a line with a different label, a too small segment and an out of range point.
+	pointCloud := PointCloud{Labels: []Label{}}
+	addPoint := func(point Point, label Label) {
+		pointCloud.Points = append(pointCloud.Points, point)
+		pointCloud.Colors = append(pointCloud.Colors, ColorWhite)
+		pointCloud.Labels = append(pointCloud.Labels, label)
+	}
+	for k := 0; k < 5; k++ {
+		addPoint(Point{1, 0.05 + 0.1*float64(k), 0.05}, 1)
+		addPoint(Point{1.15, 0.05 + 0.1*float64(k), 0.15}, 1)
+		addPoint(Point{1, 0.05 + 0.1*float64(k), 0.15}, 2)
+	}
+	addPoint(Point{2, 2, 2}, 1)
+	addPoint(Point{2, 2, 2.1}, 1)
+	addPoint(Point{9, 0, 0}, 1)
+
+	pose := Transform{Rotation: quaternion.Ident}
+	instanceLayer.segmentPointCloud(&instanceConfig, pose, &pointCloud)
+	assert.Len(t, pointCloud.Instances, len(pointCloud.Points))
+	for k := 0; k < 5; k++ {
+		assert.Equal(t, InstanceID(1), pointCloud.Instances[3*k])
+		assert.Equal(t, InstanceID(1), pointCloud.Instances[3*k+1])
+		assert.Equal(t, InstanceID(2), pointCloud.Instances[3*k+2])
+	}
+	assert.Equal(t, InstanceUnknown, pointCloud.Instances[15])
+	assert.Equal(t, InstanceUnknown, pointCloud.Instances[16])
+	assert.Equal(t, InstanceUnknown, pointCloud.Instances[17])
+
+	// Segments overlapping the voxels of an instance are associated to it.
+	for k := 0; k < 5; k++ {
+		instanceLayer.vote(getGridIndexFromPoint(Point{1, 0.05 + 0.1*float64(k), 0.15}, instanceLayer.VoxelSizeInv), 2)
+	}
+	pointCloud.Labels[0] = 2
+	instanceLayer.segmentPointCloud(&instanceConfig, pose, &pointCloud)
+	for k := 1; k < 5; k++ {
+		assert.Equal(t, InstanceID(3), pointCloud.Instances[3*k])
+		assert.Equal(t, InstanceID(2), pointCloud.Instances[3*k+2])
+	}
+	assert.Equal(t, InstanceID(2), pointCloud.Instances[0])
+
+	instanceLayer.Clear()
+	assert.Equal(t, 0, instanceLayer.GetBlockCount())
+	instanceLayer.segmentPointCloud(&instanceConfig, pose, &pointCloud)
+	assert.Equal(t, InstanceID(1), pointCloud.Instances[0])
+	assert.Equal(t, InstanceID(2), pointCloud.Instances[3])
+}
+
+func TestInstanceSegmentation(t *testing.T) {
+	instanceConfig := getTestInstanceConfig()
+	instanceConfig.MeshColorMode = MeshColorModeInstance
+	tsdfLayer := NewTsdfLayer(instanceConfig.VoxelSize, instanceConfig.VoxelsPerSide)
+	instanceLayer := NewInstanceLayer(tsdfLayer)
+	integrator := SimpleTsdfIntegrator{Config: &instanceConfig, Layer: tsdfLayer, InstanceLayer: instanceLayer}
+
+	// The cylinder keeps its instance across views.
+	integrator.IntegratePointCloud(poses[0], getLabeledTestPointCloud(poses[0]))
+	cylinderInstance, ok := instanceLayer.GetInstance(Point{0.05, 2.05, 2.05})
+	assert.True(t, ok)
+	assert.NotEqual(t, InstanceUnknown, cylinderInstance)
+	groundInstance, ok := instanceLayer.GetInstance(Point{0.05, 4.05, 0.05})
+	assert.True(t, ok)
+	assert.NotEqual(t, cylinderInstance, groundInstance)
+
+	integrator.IntegratePointCloud(poses[3], getLabeledTestPointCloud(poses[3]))
+	instance, ok := instanceLayer.GetInstance(Point{0.05, 2.05, 2.05})
+	assert.True(t, ok)
+	assert.Equal(t, cylinderInstance, instance)
+	instance, ok = instanceLayer.GetInstance(Point{0.05, 4.05, 0.05})
+	assert.True(t, ok)
+	assert.Equal(t, groundInstance, instance)
+
+	meshLayer := NewMeshLayer(tsdfLayer)
+	meshIntegrator := NewMeshIntegrator(instanceConfig, tsdfLayer, meshLayer)
+	meshIntegrator.InstanceLayer = instanceLayer
+	meshIntegrator.Integrate()
+	for _, meshBlock := range meshLayer.GetBlocks() {
+		vertices, _, colors := meshBlock.GetMesh()
+		instances := meshBlock.GetInstances()
+		assert.Len(t, instances, len(vertices))
+		for j, instance := range instances {
+			assert.Equal(t, labelColor(instance), colors[j])
+		}
+	}
+
+	// The cylinder mesh is bounded by the cylinder.
+	instanceMeshes := GetInstanceMeshes(meshLayer)
+	var cylinderMesh *InstanceMesh
+	for k := range instanceMeshes {
+		if k > 0 {
+			assert.Less(t, instanceMeshes[k-1].Instance, instanceMeshes[k].Instance)
+		}
+		if instanceMeshes[k].Instance == cylinderInstance {
+			cylinderMesh = &instanceMeshes[k]
+		}
+	}
+	assert.NotNil(t, cylinderMesh)
+	assert.Greater(t, len(cylinderMesh.Triangles), 0)
+	assert.Len(t, cylinderMesh.Colors, len(cylinderMesh.Vertices))
+	for _, triangle := range cylinderMesh.Triangles {
+		for _, vertexIndex := range triangle {
+			assert.Less(t, vertexIndex, len(cylinderMesh.Vertices))
+		}
+	}
+	for k := 0; k < 3; k++ {
+		assert.LessOrEqual(t, cylinderMesh.Min[k], cylinderMesh.Max[k])
+	}
+	assert.InDelta(t, 0.0, cylinderMesh.Min[2], 0.5)
+	assert.InDelta(t, 4.0, cylinderMesh.Max[2], 0.5)
+	assert.GreaterOrEqual(t, cylinderMesh.Min[0], -2.5)
+	assert.LessOrEqual(t, cylinderMesh.Max[0], 2.5)
+}
@@ -1,51 +1,269 @@
 package voxblox
 
 import (
+	"bufio"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 )
 
-// WriteMeshLayerToObjFiles writes a Mesh Layer to an obj file.
-func WriteMeshLayerToObjFiles(layer *MeshLayer, folderName string) {
+// kTsdfLayerMagic identifies a TSDF layer file written by WriteTsdfLayer.
+var kTsdfLayerMagic = [4]byte{'G', 'V', 'B', 'X'}
+
+// kTsdfLayerVersion is the version written by WriteTsdfLayer.
+// Its voxel records are the ones of version 1.
+// Version 2 files can still be read, dropping the voxel observations they store.
+const kTsdfLayerVersion = 3
+
+type tsdfLayerHeader struct {
+	Magic         [4]byte
+	Version       uint32
+	VoxelSize     float64
+	VoxelsPerSide uint32
+	BlockCount    uint64
+}
+
+type tsdfBlockHeader struct {
+	Index      [3]int32
+	VoxelCount uint32
+}
+
+type tsdfVoxelRecord struct {
+	Index    [3]uint16
+	Distance float64
+	Weight   float64
+	Color    Color
+}
+
+type tsdfVoxelRecordV2 struct {
+	Index        [3]uint16
+	Distance     float64
+	Weight       float64
+	Color        Color
+	LastObserved uint32
+	Observations uint32
+}
+
+// WriteMeshLayerToObjFiles writes a Mesh Layer to an obj file per block.
+func WriteMeshLayerToObjFiles(layer *MeshLayer, folderName string) error {
 	// Create folder if it doesn't exist
-	if _, err := os.Stat(folderName); os.IsNotExist(err) {
-		os.Mkdir(folderName, 0o755)
+	if err := os.MkdirAll(folderName, 0o755); err != nil {
+		return err
 	}
 
 	for _, block := range layer.GetBlocks() {
 		if block.getVertexCount() == 0 {
 			continue
 		}
+		fileName := filepath.Join(folderName, fmt.Sprintf("%s.obj", block))
+		if err := writeMeshBlockToObjFile(block, fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteGlobalMeshToObjFile writes the welded global mesh of a Mesh Layer to a single obj file.
+func WriteGlobalMeshToObjFile(layer *MeshLayer, fileName string) error {
+	mesh := GetGlobalMesh(layer)
+	return writeObjFile(fileName, mesh.Vertices, mesh.Triangles, mesh.Colors)
+}
+
+// writeMeshBlockToObjFile writes a Mesh Block to an obj file.
+// Thread-safe.
+func writeMeshBlockToObjFile(block *MeshBlock, fileName string) error {
+	block.RLock()
+	defer block.RUnlock()
+	return writeObjFile(fileName, block.vertices, block.triangles, block.colors)
+}
+
+// writeObjFile writes a mesh to an obj file, vertices without a color being white.
+func writeObjFile(fileName string, vertices []Point, triangles [][3]int, colors []Color) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	for i, vertex := range vertices {
+		color := ColorWhite
+		if i < len(colors) {
+			color = colors[i]
+		}
+		r := float64(color[0]) / 255.0
+		g := float64(color[1]) / 255.0
+		b := float64(color[2]) / 255.0
+
+		fmt.Fprintf(
+			w,
+			"v %f %f %f %f %f %f\n",
+			vertex[0],
+			vertex[1],
+			vertex[2],
+			r,
+			g,
+			b,
+		)
+	}
+	for _, triangle := range triangles {
+		fmt.Fprintf(
+			w,
+			"f %d %d %d\n",
+			triangle[0]+1,
+			triangle[1]+1,
+			triangle[2]+1,
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// WriteTsdfLayer writes all blocks of a TSDF Layer to a binary file.
+// Thread-safe.
+func WriteTsdfLayer(layer *TsdfLayer, fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	blocks := layer.getBlocks()
+	header := tsdfLayerHeader{
+		Magic:         kTsdfLayerMagic,
+		Version:       kTsdfLayerVersion,
+		VoxelSize:     layer.VoxelSize,
+		VoxelsPerSide: uint32(layer.VoxelsPerSide),
+		BlockCount:    uint64(len(blocks)),
+	}
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		// Only observed voxels are written.
+		voxels := block.getVoxels()
+		blockHeader := tsdfBlockHeader{
+			Index: [3]int32{
+				int32(block.Index[0]),
+				int32(block.Index[1]),
+				int32(block.Index[2]),
+			},
+			VoxelCount: uint32(len(voxels)),
+		}
+		if err := binary.Write(w, binary.LittleEndian, &blockHeader); err != nil {
+			return err
+		}
+		for index, voxel := range voxels {
+			record := tsdfVoxelRecord{
+				Index:    [3]uint16{uint16(index[0]), uint16(index[1]), uint16(index[2])},
+				Distance: voxel.getDistance(),
+				Weight:   voxel.getWeight(),
+				Color:    voxel.getColor(),
+			}
+			if err := binary.Write(w, binary.LittleEndian, &record); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// ReadTsdfLayer reads blocks written by WriteTsdfLayer into a TSDF Layer.
+// Blocks in the file replace existing blocks with the same index.
+// Thread-safe.
+func ReadTsdfLayer(layer *TsdfLayer, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	var header tsdfLayerHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if header.Magic != kTsdfLayerMagic {
+		return fmt.Errorf("%s is not a TSDF layer file", fileName)
+	}
+	if header.Version < 1 || header.Version > kTsdfLayerVersion {
+		return fmt.Errorf("unsupported TSDF layer file version %d", header.Version)
+	}
+	if header.VoxelSize != layer.VoxelSize || int(header.VoxelsPerSide) != layer.VoxelsPerSide {
+		return fmt.Errorf(
+			"layer mismatch: file has voxel size %f and %d voxels per side",
+			header.VoxelSize,
+			header.VoxelsPerSide,
+		)
+	}
+
+	blocks := make([]*TsdfBlock, 0, header.BlockCount)
+	for j := uint64(0); j < header.BlockCount; j++ {
+		var blockHeader tsdfBlockHeader
+		if err := binary.Read(r, binary.LittleEndian, &blockHeader); err != nil {
+			return err
+		}
+		blockIndex := IndexType{
+			int(blockHeader.Index[0]),
+			int(blockHeader.Index[1]),
+			int(blockHeader.Index[2]),
+		}
+		block := NewTsdfBlock(
+			layer,
+			blockIndex,
+			getOriginPointFromGridIndex(blockIndex, layer.BlockSize),
+		)
+		for k := uint32(0); k < blockHeader.VoxelCount; k++ {
+			record, err := readTsdfVoxelRecord(r, header.Version)
+			if err != nil {
+				return err
+			}
+			voxelIndex := IndexType{int(record.Index[0]), int(record.Index[1]), int(record.Index[2])}
+			if !block.isValidVoxelIndex(voxelIndex) {
+				return fmt.Errorf("invalid voxel index %v in block %s", voxelIndex, block)
+			}
+			var voxel TsdfVoxel
+			voxel.setDistance(record.Distance)
+			voxel.setWeight(record.Weight)
+			voxel.setColor(record.Color)
+			block.setVoxel(voxelIndex, voxel)
+		}
+		blocks = append(blocks, block)
+	}
 
-		fileName := fmt.Sprintf("%s/%s.obj", folderName, block)
-		file, _ := os.Create(fileName)
-
-		block.RLock()
-		for i, vertex := range block.vertices {
-			r := float64(block.colors[i][0]) / 255.0
-			g := float64(block.colors[i][1]) / 255.0
-			b := float64(block.colors[i][2]) / 255.0
-
-			fmt.Fprintf(
-				file,
-				"v %f %f %f %f %f %f\n",
-				vertex[0],
-				vertex[1],
-				vertex[2],
-				r,
-				g,
-				b,
-			)
-		}
-		for _, triangle := range block.triangles {
-			fmt.Fprintf(
-				file,
-				"f %d %d %d\n",
-				triangle[0]+1,
-				triangle[1]+1,
-				triangle[2]+1,
-			)
-		}
-		block.RUnlock()
+	// Only modify the layer once the whole file has been read.
+	for _, block := range blocks {
+		layer.blocks.set(block.Index, block)
+	}
+	return nil
+}
+
+// readTsdfVoxelRecord reads a voxel record of the given file version.
+func readTsdfVoxelRecord(r io.Reader, version uint32) (tsdfVoxelRecord, error) {
+	if version == 2 {
+		var record tsdfVoxelRecordV2
+		if err := binary.Read(r, binary.LittleEndian, &record); err != nil {
+			return tsdfVoxelRecord{}, err
+		}
+		return tsdfVoxelRecord{
+			Index:    record.Index,
+			Distance: record.Distance,
+			Weight:   record.Weight,
+			Color:    record.Color,
+		}, nil
 	}
+	var record tsdfVoxelRecord
+	err := binary.Read(r, binary.LittleEndian, &record)
+	return record, err
 }
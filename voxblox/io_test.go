@@ -0,0 +1,102 @@
+package voxblox
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteReadTsdfLayer(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	for x := -10; x < 10; x++ {
+		setTestVoxel(
+			tsdfLayer,
+			IndexType{x, 2, -3},
+			float64(x)*0.01,
+			float64(x+11),
+			Color{uint8(x + 10), 1, 2},
+		)
+	}
+	fileName := filepath.Join(t.TempDir(), "map.tsdf")
+	assert.NoError(t, WriteTsdfLayer(tsdfLayer, fileName))
+
+	loadedLayer := NewTsdfLayer(0.1, 8)
+	assert.NoError(t, ReadTsdfLayer(loadedLayer, fileName))
+	assert.Equal(t, tsdfLayer.GetBlockCount(), loadedLayer.GetBlockCount())
+	for x := -10; x < 10; x++ {
+		voxel := getTestVoxel(t, loadedLayer, IndexType{x, 2, -3})
+		assert.Equal(t, float64(float32(float64(x)*0.01)), voxel.getDistance())
+		assert.Equal(t, float64(x+11), voxel.getWeight())
+		assert.Equal(t, Color{uint8(x + 10), 1, 2}, voxel.getColor())
+	}
+	// Layers with a different voxel size are rejected.
+	assert.Error(t, ReadTsdfLayer(NewTsdfLayer(0.05, 8), fileName))
+}
+
+func TestReadTsdfLayerVersions(t *testing.T) {
+	// Version 2 records store the voxel observations, which are dropped.
+	records := map[uint32]any{
+		1: &tsdfVoxelRecord{Index: [3]uint16{1, 2, 3}, Distance: 0.05, Weight: 2, Color: ColorRed},
+		2: &tsdfVoxelRecordV2{
+			Index:        [3]uint16{1, 2, 3},
+			Distance:     0.05,
+			Weight:       2,
+			Color:        ColorRed,
+			LastObserved: 1700000000,
+			Observations: 3,
+		},
+	}
+	for version, record := range records {
+		fileName := filepath.Join(t.TempDir(), "map.tsdf")
+		file, err := os.Create(fileName)
+		assert.NoError(t, err)
+		assert.NoError(t, binary.Write(file, binary.LittleEndian, &tsdfLayerHeader{
+			Magic:         kTsdfLayerMagic,
+			Version:       version,
+			VoxelSize:     0.1,
+			VoxelsPerSide: 8,
+			BlockCount:    1,
+		}))
+		assert.NoError(t, binary.Write(file, binary.LittleEndian, &tsdfBlockHeader{
+			Index:      [3]int32{0, 0, 0},
+			VoxelCount: 1,
+		}))
+		assert.NoError(t, binary.Write(file, binary.LittleEndian, record))
+		assert.NoError(t, file.Close())
+
+		tsdfLayer := NewTsdfLayer(0.1, 8)
+		assert.NoError(t, ReadTsdfLayer(tsdfLayer, fileName), "version %d", version)
+		voxel := getTestVoxel(t, tsdfLayer, IndexType{1, 2, 3})
+		assert.Equal(t, 2.0, voxel.getWeight())
+		assert.Equal(t, ColorRed, voxel.getColor())
+	}
+}
+
+func TestTsdfLayerClear(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	tsdfLayer.getBlockByCoordinates(Point{0, 0, 0})
+	tsdfLayer.getBlockByCoordinates(Point{1, 1, 1})
+	assert.Equal(t, 2, tsdfLayer.GetBlockCount())
+	tsdfLayer.Clear()
+	assert.Equal(t, 0, tsdfLayer.GetBlockCount())
+}
+
+func TestWriteMeshLayerToObjFiles(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	meshLayer := NewMeshLayer(tsdfLayer)
+	block := meshLayer.getBlockByIndex(IndexType{1, -2, 3})
+	block.vertices = []Point{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}
+	block.triangles = [][3]int{{0, 1, 2}}
+
+	folderName := filepath.Join(t.TempDir(), "mesh")
+	assert.NoError(t, WriteMeshLayerToObjFiles(meshLayer, folderName))
+	data, err := os.ReadFile(filepath.Join(folderName, "1_-2_3.obj"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "f 1 2 3\n")
+
+	// Writing into a file instead of a folder fails.
+	assert.Error(t, WriteMeshLayerToObjFiles(meshLayer, filepath.Join(folderName, "1_-2_3.obj")))
+}
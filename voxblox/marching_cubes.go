@@ -323,20 +323,57 @@ func interpolateEdgeVertices(
 	}
 }
 
-// vertexIndex returns the index of a vertex if it exists
-// Else adds it and returns the new index
-func vertexIndex(block *MeshBlock, vertex Point) int {
-	// Reverse search is significantly faster since we return earlier
-	for i := len(block.vertices) - 1; i >= 0; i-- {
-		if vertex == block.vertices[i] {
-			return i
-		}
+// kEdgeLowerCorners are the offsets from the cube origin of the lower corner of every cube edge.
+var kEdgeLowerCorners = [12]IndexType{
+	{0, 0, 0},
+	{1, 0, 0},
+	{0, 1, 0},
+	{0, 0, 0},
+	{0, 0, 1},
+	{1, 0, 1},
+	{0, 1, 1},
+	{0, 0, 1},
+	{0, 0, 0},
+	{1, 0, 0},
+	{1, 1, 0},
+	{0, 1, 0},
+}
+
+// kEdgeAxes are the axes along which the cube edges run.
+var kEdgeAxes = [12]int{0, 1, 0, 1, 0, 1, 0, 1, 2, 2, 2, 2}
+
+// vertexCache maps the edges of the voxel grid of a mesh block to the index of their vertex.
+// An edge is keyed by its lower corner and its axis, so the cubes sharing an edge share its vertex
+// exactly and in constant time.
+// Corners range over the voxels of the block and the first voxels of its neighbors.
+type vertexCache struct {
+	// edges holds the vertex index plus one of the edges along x, y and z from every corner,
+	// 0 if the edge has no vertex yet.
+	edges voxelArray[[3]int32]
+}
+
+// newVertexCache creates an empty vertexCache for a block of voxelsPerSide voxels per side.
+func newVertexCache(voxelsPerSide int) *vertexCache {
+	return &vertexCache{edges: newVoxelArray[[3]int32](voxelsPerSide + 1)}
+}
+
+// vertexIndex returns the index of the vertex of an edge of the cube at the local voxel index.
+// Else adds the vertex to the block and returns the new index.
+func (c *vertexCache) vertexIndex(block *MeshBlock, voxelIndex IndexType, edge int, vertex Point) int {
+	corner := addIndex(voxelIndex, kEdgeLowerCorners[edge])
+	cached := &c.edges.at(corner)[kEdgeAxes[edge]]
+	if *cached == 0 {
+		block.vertices = append(block.vertices, vertex)
+		*cached = int32(len(block.vertices))
 	}
-	block.vertices = append(block.vertices, vertex)
-	return len(block.vertices) - 1
+	return int(*cached - 1)
 }
 
+// meshCube adds the triangles of the cube at the local voxel index to the mesh block.
+// The vertices are shared with the previous cubes through the vertex cache of the block.
 func meshCube(
+	cache *vertexCache,
+	voxelIndex IndexType,
 	vertexCoords *[8][3]float64,
 	vertexSdf *[8]float64,
 	meshBlock *MeshBlock,
@@ -356,16 +393,15 @@ func meshCube(
 	defer meshBlock.Unlock()
 
 	for tableRow[tableCol] != -1 {
-		v0 := edgeVertexCoordinates[tableRow[tableCol+2]]
-		v1 := edgeVertexCoordinates[tableRow[tableCol+1]]
-		v2 := edgeVertexCoordinates[tableRow[tableCol]]
+		e0 := tableRow[tableCol+2]
+		e1 := tableRow[tableCol+1]
+		e2 := tableRow[tableCol]
 
-		// Insert or append to eliminate duplicate vertices
-		i0 := vertexIndex(meshBlock, v0)
-		i1 := vertexIndex(meshBlock, v1)
-		i2 := vertexIndex(meshBlock, v2)
-
-		triangle := [3]int{i0, i1, i2}
+		triangle := [3]int{
+			cache.vertexIndex(meshBlock, voxelIndex, e0, edgeVertexCoordinates[e0]),
+			cache.vertexIndex(meshBlock, voxelIndex, e1, edgeVertexCoordinates[e1]),
+			cache.vertexIndex(meshBlock, voxelIndex, e2, edgeVertexCoordinates[e2]),
+		}
 
 		meshBlock.triangles = append(meshBlock.triangles, triangle)
 
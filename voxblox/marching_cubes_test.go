@@ -1,9 +1,11 @@
 package voxblox
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/vec3"
 )
 
 func TestCalculateVertexConfiguration(t *testing.T) {
@@ -89,8 +91,9 @@ func TestMeshCube(t *testing.T) {
 	tsdfLayer := NewTsdfLayer(0.1, 16)
 	meshLayer := NewMeshLayer(tsdfLayer)
 	meshBlock := NewMeshBlock(meshLayer, IndexType{0, 0, 0}, Point{0, 0, 0})
+	cache := newVertexCache(meshBlock.VoxelsPerSide)
 
-	meshCube(&vertexCoords, &vertexSdf, meshBlock)
+	meshCube(cache, IndexType{0, 0, 0}, &vertexCoords, &vertexSdf, meshBlock)
 
 	assert.Equal(t, 3, meshBlock.getVertexCount())
 
@@ -127,7 +130,7 @@ func TestMeshCube(t *testing.T) {
 		-0.181599542,
 	}
 
-	meshCube(&vertexCoords, &vertexSdf, meshBlock)
+	meshCube(cache, IndexType{5, 5, 5}, &vertexCoords, &vertexSdf, meshBlock)
 
 	assert.Equal(t, 6, meshBlock.getVertexCount())
 
@@ -144,11 +147,113 @@ func TestMeshCube(t *testing.T) {
 	assert.InEpsilon(t, 1.95000005, meshBlock.vertices[5][2], kEpsilon)
 }
 
-func TestVertexIndex(t *testing.T) {
+func TestVertexCache(t *testing.T) {
 	meshBlock := new(MeshBlock)
-	assert.Equal(t, 0, vertexIndex(meshBlock, Point{0, 0, 0}))
-	assert.Equal(t, 0, vertexIndex(meshBlock, Point{0, 0, 0}))
-	assert.Equal(t, 1, vertexIndex(meshBlock, Point{1, 1, 1}))
-	assert.Equal(t, 0, vertexIndex(meshBlock, Point{0, 0, 0}))
-	assert.Len(t, meshBlock.vertices, 2)
+	cache := newVertexCache(16)
+	assert.Equal(t, 0, cache.vertexIndex(meshBlock, IndexType{0, 0, 0}, 1, Point{0, 0, 0}))
+	assert.Equal(t, 0, cache.vertexIndex(meshBlock, IndexType{0, 0, 0}, 1, Point{0, 0, 0}))
+	// The edge shared with the next cube along x.
+	assert.Equal(t, 0, cache.vertexIndex(meshBlock, IndexType{1, 0, 0}, 3, Point{1, 1, 1}))
+	// The opposite edge along z of the same corner.
+	assert.Equal(t, 1, cache.vertexIndex(meshBlock, IndexType{0, 0, 0}, 9, Point{1, 1, 1}))
+	// An edge between the first voxels of the neighboring blocks.
+	assert.Equal(t, 2, cache.vertexIndex(meshBlock, IndexType{15, 15, 15}, 6, Point{2, 2, 2}))
+	assert.Equal(t, 2, cache.vertexIndex(meshBlock, IndexType{15, 15, 15}, 6, Point{2, 2, 2}))
+	assert.Len(t, meshBlock.vertices, 3)
+}
+
+// getTestSdfLayer sets every voxel of the blocks at the given indexes, the block at the origin if none,
+// to the signed distance function at the voxel center and returns the layer.
+func getTestSdfLayer(layer *TsdfLayer, sdf func(center Point) float64, blockIndexes ...IndexType) *TsdfLayer {
+	if len(blockIndexes) == 0 {
+		blockIndexes = []IndexType{{0, 0, 0}}
+	}
+	vps := layer.VoxelsPerSide
+	for _, blockIndex := range blockIndexes {
+		blockOrigin := IndexType{blockIndex[0] * vps, blockIndex[1] * vps, blockIndex[2] * vps}
+		voxelIndex := IndexType{}
+		for voxelIndex[0] = 0; voxelIndex[0] < vps; voxelIndex[0]++ {
+			for voxelIndex[1] = 0; voxelIndex[1] < vps; voxelIndex[1]++ {
+				for voxelIndex[2] = 0; voxelIndex[2] < vps; voxelIndex[2]++ {
+					globalVoxelIndex := addIndex(blockOrigin, voxelIndex)
+					center := getCenterPointFromGridIndex(globalVoxelIndex, layer.VoxelSize)
+					setTestVoxel(layer, globalVoxelIndex, sdf(center), 1.0, ColorRed)
+				}
+			}
+		}
+	}
+	return layer
+}
+
+// sphereSdf returns the signed distance function of a sphere.
+func sphereSdf(sphereCenter Point, radius float64) func(center Point) float64 {
+	return func(center Point) float64 {
+		return vec3.Distance(&center, &sphereCenter) - radius
+	}
+}
+
+func TestMeshWelding(t *testing.T) {
+	// A sphere inside the block.
+	layer := getTestSdfLayer(NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide), sphereSdf(Point{0.8, 0.8, 0.8}, 0.53))
+	meshLayer := NewMeshLayer(layer)
+	NewMeshIntegrator(config, layer, meshLayer).IntegrateAll()
+	vertices, triangles, _ := meshLayer.GetBlocks()[IndexType{0, 0, 0}].GetMesh()
+	assertClosedMesh(t, vertices, triangles)
+}
+
+func TestMeshBlocksPublishedComplete(t *testing.T) {
+	layer := getTestSdfLayer(NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide), checkerboardSdf)
+	meshLayer := NewMeshLayer(layer)
+	meshIntegrator := NewMeshIntegrator(config, layer, meshLayer)
+	meshIntegrator.IntegrateAll()
+	_, expectedTriangles, _ := meshLayer.GetBlocks()[IndexType{0, 0, 0}].GetMesh()
+	assert.NotEmpty(t, expectedTriangles)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for j := 0; j < 10; j++ {
+			meshIntegrator.IntegrateAll()
+		}
+	}()
+
+	// Readers of the mesh layer only see blocks with their full mesh and colors.
+	for {
+		for _, meshBlock := range meshLayer.GetBlocks() {
+			vertices, triangles, colors := meshBlock.GetMesh()
+			if !assert.Len(t, triangles, len(expectedTriangles)) || !assert.Len(t, colors, len(vertices)) {
+				<-done
+				return
+			}
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+// checkerboardSdf alternates the sign of the distance between neighboring voxels,
+// which puts triangles in every cube.
+func checkerboardSdf(center Point) float64 {
+	index := getGridIndexFromPoint(center, 1/config.VoxelSize)
+	if (index[0]+index[1]+index[2])%2 == 0 {
+		return config.VoxelSize / 2
+	}
+	return -config.VoxelSize / 2
+}
+
+func BenchmarkMeshIntegrator(b *testing.B) {
+	for _, voxelsPerSide := range []int{8, 16, 32} {
+		b.Run(fmt.Sprintf("vps=%d", voxelsPerSide), func(b *testing.B) {
+			layer := getTestSdfLayer(NewTsdfLayer(config.VoxelSize, voxelsPerSide), checkerboardSdf)
+			meshLayer := NewMeshLayer(layer)
+			meshIntegrator := NewMeshIntegrator(config, layer, meshLayer)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				meshIntegrator.IntegrateAll()
+			}
+		})
+	}
 }
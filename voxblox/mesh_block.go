@@ -3,12 +3,19 @@ package voxblox
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"sync"
 
 	"github.com/qmuntal/gltf"
 	"github.com/qmuntal/gltf/modeler"
 )
 
+// kGltfLabelAttribute is the glTF custom vertex attribute holding the vertex labels.
+const kGltfLabelAttribute = "_LABEL"
+
+// kGltfInstanceAttribute is the glTF custom vertex attribute holding the vertex instances.
+const kGltfInstanceAttribute = "_INSTANCE"
+
 type MeshBlock struct {
 	Index         IndexType
 	VoxelsPerSide int
@@ -21,6 +28,10 @@ type MeshBlock struct {
 	vertices  []Point
 	triangles [][3]int
 	colors    []Color
+	// labels holds the semantic label of every vertex if the mesh has labels.
+	labels []Label
+	// instances holds the object instance of every vertex if the mesh has instances.
+	instances []InstanceID
 }
 
 // NewMeshBlock creates a new MeshBlock.
@@ -48,6 +59,8 @@ func (b *MeshBlock) Clear() {
 	b.vertices = nil
 	b.triangles = nil
 	b.colors = nil
+	b.labels = nil
+	b.instances = nil
 }
 
 // getVertexCount returns the number of vertices in the block.
@@ -74,72 +87,143 @@ func (b *MeshBlock) getVertices() []Point {
 	return b.vertices
 }
 
-// verticesAsFloat32 returns the vertices in the block as float32.
+// GetMesh returns copies of the vertices, triangles and colors in the block.
 // Thread-safe.
-func (b *MeshBlock) verticesAsFloat32() [][3]float32 {
+func (b *MeshBlock) GetMesh() ([]Point, [][3]int, []Color) {
 	b.RLock()
 	defer b.RUnlock()
-	vertices := make([][3]float32, len(b.vertices))
-	for i, v := range b.vertices {
-		vertices[i][0] = float32(v[0])
-		vertices[i][1] = float32(v[1])
-		vertices[i][2] = float32(v[2])
-	}
-	return vertices
+	vertices := make([]Point, len(b.vertices))
+	copy(vertices, b.vertices)
+	triangles := make([][3]int, len(b.triangles))
+	copy(triangles, b.triangles)
+	colors := make([]Color, len(b.colors))
+	copy(colors, b.colors)
+	return vertices, triangles, colors
 }
 
-// indicesAsInt32 returns the indices in the block as int32.
+// GetLabels returns a copy of the vertex labels in the block, nil if the mesh has no labels.
 // Thread-safe.
-func (b *MeshBlock) indicesAsUint16() []uint16 {
+func (b *MeshBlock) GetLabels() []Label {
 	b.RLock()
 	defer b.RUnlock()
-	indices := make([]uint16, len(b.triangles)*3)
-	for i, t := range b.triangles {
-		indices[i*3+0] = uint16(t[0])
-		indices[i*3+1] = uint16(t[1])
-		indices[i*3+2] = uint16(t[2])
+	if b.labels == nil {
+		return nil
 	}
-	return indices
+	labels := make([]Label, len(b.labels))
+	copy(labels, b.labels)
+	return labels
 }
 
-// colorsAsUint32 returns the colors in the block as uint32.
+// GetInstances returns a copy of the vertex instances in the block, nil if the mesh has no instances.
 // Thread-safe.
-func (b *MeshBlock) colorsAsUint8() [][3]uint8 {
+func (b *MeshBlock) GetInstances() []InstanceID {
 	b.RLock()
 	defer b.RUnlock()
-	colors := make([][3]uint8, len(b.colors))
-	for i, c := range b.colors {
-		colors[i][0] = c[0]
-		colors[i][1] = c[1]
-		colors[i][2] = c[2]
+	if b.instances == nil {
+		return nil
+	}
+	instances := make([]InstanceID, len(b.instances))
+	copy(instances, b.instances)
+	return instances
+}
+
+// gltfPrimitive is a part of the mesh of a block written as a single glTF primitive.
+// Indexes are local to the primitive.
+type gltfPrimitive struct {
+	vertices  []int
+	triangles [][3]int
+}
+
+// splitPrimitives splits the mesh of the block into primitives of at most maxVertices vertices,
+// keeping the order of the triangles. A maxVertices of 0 puts the whole mesh into one primitive.
+// The block must be locked.
+func (b *MeshBlock) splitPrimitives(maxVertices int) []gltfPrimitive {
+	if maxVertices <= 0 || len(b.vertices) <= maxVertices {
+		vertices := make([]int, len(b.vertices))
+		for v := range vertices {
+			vertices[v] = v
+		}
+		return []gltfPrimitive{{vertices: vertices, triangles: b.triangles}}
+	}
+
+	var primitives []gltfPrimitive
+	var primitive gltfPrimitive
+	localIndexes := make(map[int]int)
+	for _, triangle := range b.triangles {
+		newVertices := 0
+		for _, vertex := range triangle {
+			if _, ok := localIndexes[vertex]; !ok {
+				newVertices++
+			}
+		}
+		if len(primitive.vertices)+newVertices > maxVertices {
+			primitives = append(primitives, primitive)
+			primitive = gltfPrimitive{}
+			clear(localIndexes)
+		}
+		var localTriangle [3]int
+		for k, vertex := range triangle {
+			index, ok := localIndexes[vertex]
+			if !ok {
+				index = len(primitive.vertices)
+				localIndexes[vertex] = index
+				primitive.vertices = append(primitive.vertices, vertex)
+			}
+			localTriangle[k] = index
+		}
+		primitive.triangles = append(primitive.triangles, localTriangle)
 	}
-	return colors
+	if len(primitive.triangles) > 0 {
+		primitives = append(primitives, primitive)
+	}
+	return primitives
+}
+
+// gltfIndices returns the indices of the triangles as unsigned shorts if all vertex indexes fit
+// and as unsigned ints otherwise.
+// The largest unsigned short is reserved as primitive restart value by glTF.
+func gltfIndices(triangles [][3]int, vertexCount int) any {
+	if vertexCount < math.MaxUint16 {
+		return gltfIndicesAs[uint16](triangles)
+	}
+	return gltfIndicesAs[uint32](triangles)
+}
+
+// gltfIndicesAs returns the flattened indices of the triangles.
+func gltfIndicesAs[T uint16 | uint32](triangles [][3]int) []T {
+	indices := make([]T, len(triangles)*3)
+	for i, t := range triangles {
+		indices[i*3+0] = T(t[0])
+		indices[i*3+1] = T(t[1])
+		indices[i*3+2] = T(t[2])
+	}
+	return indices
+}
+
+// gltfAttribute returns the attribute of the vertices of a primitive.
+func gltfAttribute[T any](attributes []T, vertices []int) []T {
+	primitiveAttributes := make([]T, len(vertices))
+	for v, vertex := range vertices {
+		primitiveAttributes[v] = attributes[vertex]
+	}
+	return primitiveAttributes
 }
 
 // Gltf returns the vertices and triangles in the block as glTF bytes.
+// Vertex labels are written as the unsigned int custom attribute _LABEL
+// and vertex instances as the unsigned int custom attribute _INSTANCE.
 // Thread-safe.
 func (b *MeshBlock) Gltf() (bytes.Buffer, error) {
-	b.RLock()
-	defer b.RUnlock()
+	return b.GltfSplit(0)
+}
 
-	doc := gltf.NewDocument()
-	positionAccessor := modeler.WritePosition(doc, b.verticesAsFloat32())
-	indicesAccessor := modeler.WriteIndices(doc, b.indicesAsUint16())
-	colorIndices := modeler.WriteColor(doc, b.colorsAsUint8())
-	doc.Meshes = []*gltf.Mesh{{
-		Primitives: []*gltf.Primitive{
-			{
-				Indices: gltf.Index(indicesAccessor),
-				Attributes: map[string]uint32{
-					gltf.POSITION: positionAccessor,
-					gltf.COLOR_0:  colorIndices,
-				},
-				Mode: gltf.PrimitiveTriangles,
-			},
-		},
-	}}
-	doc.Nodes = []*gltf.Node{{Name: fmt.Sprintf(b.String()), Mesh: gltf.Index(0)}}
-	doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, 0)
+// GltfSplit returns the block as glTF bytes like Gltf, splitting the mesh into primitives of at most
+// maxPrimitiveVertices vertices for clients limited in the size of a draw call.
+// A maxPrimitiveVertices of 0 writes a single primitive.
+// Every primitive uses unsigned short indices if its vertices allow it and unsigned int indices otherwise.
+// Thread-safe.
+func (b *MeshBlock) GltfSplit(maxPrimitiveVertices int) (bytes.Buffer, error) {
+	doc := b.gltfDocument(maxPrimitiveVertices)
 
 	var buf bytes.Buffer
 	err := gltf.NewEncoder(&buf).Encode(doc)
@@ -151,3 +235,77 @@ func (b *MeshBlock) Gltf() (bytes.Buffer, error) {
 
 	return buf, nil
 }
+
+// gltfBufferSize returns the size in bytes of the binary glTF buffer of the block written as a single primitive.
+// The size is computed from the vertex and triangle counts without building the glTF document.
+// The caller must hold the lock.
+func (b *MeshBlock) gltfBufferSize() int {
+	indexSize := 4
+	if len(b.vertices) < math.MaxUint16 {
+		indexSize = 2
+	}
+	// Every accessor starts at a multiple of 4 bytes and colors are padded to 4 bytes per vertex.
+	accessorSizes := []int{len(b.vertices) * 12, len(b.triangles) * 3 * indexSize}
+	if len(b.colors) == len(b.vertices) {
+		accessorSizes = append(accessorSizes, len(b.vertices)*4)
+	}
+	if len(b.labels) > 0 && len(b.labels) == len(b.vertices) {
+		accessorSizes = append(accessorSizes, len(b.vertices)*4)
+	}
+	if len(b.instances) > 0 && len(b.instances) == len(b.vertices) {
+		accessorSizes = append(accessorSizes, len(b.vertices)*4)
+	}
+	size := 0
+	for _, accessorSize := range accessorSizes {
+		size = (size+3)/4*4 + accessorSize
+	}
+	return size
+}
+
+// gltfDocument returns the glTF document of the block with primitives of at most maxPrimitiveVertices vertices.
+// Thread-safe.
+func (b *MeshBlock) gltfDocument(maxPrimitiveVertices int) *gltf.Document {
+	b.RLock()
+	defer b.RUnlock()
+
+	doc := gltf.NewDocument()
+	mesh := &gltf.Mesh{}
+	for _, primitive := range b.splitPrimitives(maxPrimitiveVertices) {
+		positions := make([][3]float32, len(primitive.vertices))
+		for v, vertex := range primitive.vertices {
+			positions[v] = [3]float32{
+				float32(b.vertices[vertex][0]),
+				float32(b.vertices[vertex][1]),
+				float32(b.vertices[vertex][2]),
+			}
+		}
+		attributes := map[string]uint32{
+			gltf.POSITION: modeler.WritePosition(doc, positions),
+		}
+		indicesAccessor := modeler.WriteIndices(doc, gltfIndices(primitive.triangles, len(primitive.vertices)))
+		if len(b.colors) == len(b.vertices) {
+			attributes[gltf.COLOR_0] = modeler.WriteColor(doc, gltfAttribute(b.colors, primitive.vertices))
+		}
+		if len(b.labels) > 0 && len(b.labels) == len(b.vertices) {
+			// Vertex attributes must be aligned to 4 bytes, which unsigned short scalars are not.
+			labels := make([]uint32, len(primitive.vertices))
+			for v, vertex := range primitive.vertices {
+				labels[v] = uint32(b.labels[vertex])
+			}
+			attributes[kGltfLabelAttribute] = modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, labels)
+		}
+		if len(b.instances) > 0 && len(b.instances) == len(b.vertices) {
+			instances := gltfAttribute(b.instances, primitive.vertices)
+			attributes[kGltfInstanceAttribute] = modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, instances)
+		}
+		mesh.Primitives = append(mesh.Primitives, &gltf.Primitive{
+			Indices:    gltf.Index(indicesAccessor),
+			Attributes: attributes,
+			Mode:       gltf.PrimitiveTriangles,
+		})
+	}
+	doc.Meshes = []*gltf.Mesh{mesh}
+	doc.Nodes = []*gltf.Node{{Name: fmt.Sprintf(b.String()), Mesh: gltf.Index(0)}}
+	doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, 0)
+	return doc
+}
@@ -0,0 +1,130 @@
+package voxblox
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeGltfTriangles returns the vertex positions of the triangles of every primitive in the glTF bytes
+// and the component types of the indices of the primitives.
+func decodeGltfTriangles(t *testing.T, buf bytes.Buffer) ([][3][3]float32, []gltf.ComponentType) {
+	doc := new(gltf.Document)
+	assert.NoError(t, gltf.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(doc))
+	var triangles [][3][3]float32
+	var componentTypes []gltf.ComponentType
+	for _, primitive := range doc.Meshes[0].Primitives {
+		indicesAccessor := doc.Accessors[*primitive.Indices]
+		componentTypes = append(componentTypes, indicesAccessor.ComponentType)
+		indices, err := modeler.ReadIndices(doc, indicesAccessor, nil)
+		assert.NoError(t, err)
+		positions, err := modeler.ReadPosition(doc, doc.Accessors[primitive.Attributes[gltf.POSITION]], nil)
+		assert.NoError(t, err)
+		colorAccessor, ok := primitive.Attributes[gltf.COLOR_0]
+		assert.True(t, ok)
+		assert.Equal(t, uint32(len(positions)), doc.Accessors[colorAccessor].Count)
+		for i := 0; i+2 < len(indices); i += 3 {
+			triangles = append(triangles, [3][3]float32{
+				positions[indices[i]],
+				positions[indices[i+1]],
+				positions[indices[i+2]],
+			})
+		}
+	}
+	return triangles, componentTypes
+}
+
+// getTestMeshTriangles returns the vertex positions of the triangles of the mesh block as written to glTF.
+func getTestMeshTriangles(meshBlock *MeshBlock) [][3][3]float32 {
+	vertices, triangles, _ := meshBlock.GetMesh()
+	positions := make([][3][3]float32, len(triangles))
+	for i, triangle := range triangles {
+		for k, vertex := range triangle {
+			positions[i][k] = [3]float32{
+				float32(vertices[vertex][0]),
+				float32(vertices[vertex][1]),
+				float32(vertices[vertex][2]),
+			}
+		}
+	}
+	return positions
+}
+
+func TestGltfSmallBlock(t *testing.T) {
+	layer := getTestSdfLayer(NewTsdfLayer(config.VoxelSize, 8), checkerboardSdf)
+	meshLayer := NewMeshLayer(layer)
+	NewMeshIntegrator(config, layer, meshLayer).IntegrateAll()
+	meshBlock := meshLayer.GetBlocks()[IndexType{0, 0, 0}]
+
+	buf, err := meshBlock.Gltf()
+	assert.NoError(t, err)
+	triangles, componentTypes := decodeGltfTriangles(t, buf)
+	assert.Equal(t, []gltf.ComponentType{gltf.ComponentUshort}, componentTypes)
+	assert.Equal(t, getTestMeshTriangles(meshBlock), triangles)
+}
+
+func TestGltfLargeBlock(t *testing.T) {
+	layer := getTestSdfLayer(NewTsdfLayer(config.VoxelSize, 40), checkerboardSdf)
+	meshLayer := NewMeshLayer(layer)
+	NewMeshIntegrator(config, layer, meshLayer).IntegrateAll()
+	meshBlock := meshLayer.GetBlocks()[IndexType{0, 0, 0}]
+	assert.Greater(t, meshBlock.getVertexCount(), 1<<16)
+	expected := getTestMeshTriangles(meshBlock)
+
+	// A single primitive needs unsigned int indices.
+	buf, err := meshBlock.Gltf()
+	assert.NoError(t, err)
+	triangles, componentTypes := decodeGltfTriangles(t, buf)
+	assert.Equal(t, []gltf.ComponentType{gltf.ComponentUint}, componentTypes)
+	assert.Equal(t, expected, triangles)
+
+	// Split primitives fit unsigned short indices.
+	buf, err = meshBlock.GltfSplit(1 << 15)
+	assert.NoError(t, err)
+	triangles, componentTypes = decodeGltfTriangles(t, buf)
+	assert.Greater(t, len(componentTypes), 1)
+	for _, componentType := range componentTypes {
+		assert.Equal(t, gltf.ComponentUshort, componentType)
+	}
+	assert.Equal(t, expected, triangles)
+}
+
+func TestSplitPrimitives(t *testing.T) {
+	meshBlock := new(MeshBlock)
+	meshBlock.vertices = make([]Point, 5)
+	meshBlock.triangles = [][3]int{{0, 1, 2}, {2, 1, 3}, {3, 4, 0}}
+
+	primitives := meshBlock.splitPrimitives(0)
+	assert.Len(t, primitives, 1)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, primitives[0].vertices)
+
+	primitives = meshBlock.splitPrimitives(4)
+	assert.Equal(t, []gltfPrimitive{
+		{vertices: []int{0, 1, 2, 3}, triangles: [][3]int{{0, 1, 2}, {2, 1, 3}}},
+		{vertices: []int{3, 4, 0}, triangles: [][3]int{{0, 1, 2}}},
+	}, primitives)
+}
+
+func TestGltfBufferSize(t *testing.T) {
+	meshBlock := new(MeshBlock)
+	assert.Equal(t, 0, meshBlock.gltfBufferSize())
+
+	// An odd number of triangles leaves unsigned short indices unaligned.
+	meshBlock.vertices = make([]Point, 5)
+	meshBlock.triangles = [][3]int{{0, 1, 2}, {2, 1, 3}, {3, 4, 0}}
+	assert.Equal(t, int(meshBlock.gltfDocument(0).Buffers[0].ByteLength), meshBlock.gltfBufferSize())
+	meshBlock.colors = make([]Color, 5)
+	assert.Equal(t, int(meshBlock.gltfDocument(0).Buffers[0].ByteLength), meshBlock.gltfBufferSize())
+	meshBlock.labels = make([]Label, 5)
+	meshBlock.instances = make([]InstanceID, 5)
+	assert.Equal(t, int(meshBlock.gltfDocument(0).Buffers[0].ByteLength), meshBlock.gltfBufferSize())
+
+	layer := getTestSdfLayer(NewTsdfLayer(config.VoxelSize, 40), checkerboardSdf)
+	meshLayer := NewMeshLayer(layer)
+	NewMeshIntegrator(config, layer, meshLayer).IntegrateAll()
+	meshBlock = meshLayer.GetBlocks()[IndexType{0, 0, 0}]
+	assert.Equal(t, int(meshBlock.gltfDocument(0).Buffers[0].ByteLength), meshBlock.gltfBufferSize())
+}
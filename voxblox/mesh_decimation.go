@@ -0,0 +1,525 @@
+package voxblox
+
+import (
+	"container/heap"
+	"math"
+	"slices"
+
+	"github.com/ungerik/go3d/float64/vec3"
+)
+
+// kDecimationMinNormalDot is the minimum cosine between the normals of a triangle
+// before and after a collapse, and between its original normal and the collapsed one.
+const kDecimationMinNormalDot = 0.5
+
+// DecimationStats is the reduction of the mesh by the quadric error decimation.
+// Bytes are the sizes of the binary glTF buffers of the blocks.
+type DecimationStats struct {
+	Blocks          int
+	TrianglesBefore int
+	TrianglesAfter  int
+	BytesBefore     int
+	BytesAfter      int
+}
+
+// add accumulates the stats of another decimation.
+func (s *DecimationStats) add(other DecimationStats) {
+	s.Blocks += other.Blocks
+	s.TrianglesBefore += other.TrianglesBefore
+	s.TrianglesAfter += other.TrianglesAfter
+	s.BytesBefore += other.BytesBefore
+	s.BytesAfter += other.BytesAfter
+}
+
+// quadric is the sum of the squared distances to a set of planes (Garland and Heckbert).
+// The symmetric 4x4 matrix is stored as its upper triangle, count is the number of planes.
+type quadric struct {
+	a     [10]float64
+	count float64
+}
+
+// newPlaneQuadric returns the quadric of the plane through the point with the unit normal.
+func newPlaneQuadric(normal vec3.T, point Point) quadric {
+	a, b, c := normal[0], normal[1], normal[2]
+	d := -vec3.Dot(&normal, &point)
+	return quadric{
+		a:     [10]float64{a * a, a * b, a * c, a * d, b * b, b * c, b * d, c * c, c * d, d * d},
+		count: 1,
+	}
+}
+
+// add adds the planes of another quadric.
+func (q *quadric) add(other *quadric) {
+	for k := range q.a {
+		q.a[k] += other.a[k]
+	}
+	q.count += other.count
+}
+
+// evaluate returns the mean squared distance of the point to the planes.
+func (q *quadric) evaluate(p Point) float64 {
+	if q.count == 0 {
+		return 0
+	}
+	x, y, z := p[0], p[1], p[2]
+	e := q.a[0]*x*x + 2*q.a[1]*x*y + 2*q.a[2]*x*z + 2*q.a[3]*x +
+		q.a[4]*y*y + 2*q.a[5]*y*z + 2*q.a[6]*y +
+		q.a[7]*z*z + 2*q.a[8]*z +
+		q.a[9]
+	return math.Max(0, e) / q.count
+}
+
+// optimum returns the point minimizing the quadric.
+// Returns false if the planes do not constrain a single point, like on flat or cylindrical surfaces.
+func (q *quadric) optimum() (Point, bool) {
+	m := [3][3]float64{
+		{q.a[0], q.a[1], q.a[2]},
+		{q.a[1], q.a[4], q.a[5]},
+		{q.a[2], q.a[5], q.a[7]},
+	}
+	det := determinant3(m)
+	if math.Abs(det) < 1e-12*q.count*q.count*q.count {
+		return Point{}, false
+	}
+	// Cramer's rule.
+	b := [3]float64{-q.a[3], -q.a[6], -q.a[8]}
+	var optimum Point
+	for k := 0; k < 3; k++ {
+		mk := m
+		for row := 0; row < 3; row++ {
+			mk[row][k] = b[row]
+		}
+		optimum[k] = determinant3(mk) / det
+	}
+	return optimum, true
+}
+
+// determinant3 returns the determinant of the 3x3 matrix.
+func determinant3(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// edgeCollapse is a candidate collapse of the edge (keep, remove) into the target point.
+type edgeCollapse struct {
+	keep, remove   int
+	keepVersion    int
+	removeVersion  int
+	target         Point
+	cost           float64
+	sequenceNumber int
+}
+
+// edgeCollapseQueue is a min-heap of edge collapses ordered by cost.
+type edgeCollapseQueue []edgeCollapse
+
+func (q edgeCollapseQueue) Len() int { return len(q) }
+func (q edgeCollapseQueue) Less(a, b int) bool {
+	if q[a].cost != q[b].cost {
+		return q[a].cost < q[b].cost
+	}
+	return q[a].sequenceNumber < q[b].sequenceNumber
+}
+func (q edgeCollapseQueue) Swap(a, b int) { q[a], q[b] = q[b], q[a] }
+func (q *edgeCollapseQueue) Push(x any)   { *q = append(*q, x.(edgeCollapse)) }
+func (q *edgeCollapseQueue) Pop() any {
+	old := *q
+	collapse := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return collapse
+}
+
+// meshDecimator collapses the edges of an indexed mesh by increasing quadric error.
+type meshDecimator struct {
+	vertices         []Point
+	triangles        [][3]int
+	removedTriangles []bool
+	// normals are the original triangle normals, which the collapses may not tilt too far.
+	normals         []vec3.T
+	removedVertices []bool
+	vertexTriangles [][]int
+	quadrics        []quadric
+	locked          []bool
+	versions        []int
+	queue           edgeCollapseQueue
+	sequenceNumber  int
+	maxCost         float64
+}
+
+// newMeshDecimator prepares the decimation of the mesh.
+// Vertices on open or non-manifold edges or degenerate triangles and vertices flagged in locked are never moved.
+func newMeshDecimator(vertices []Point, triangles [][3]int, locked []bool, maxError float64) *meshDecimator {
+	d := &meshDecimator{
+		vertices:         append([]Point(nil), vertices...),
+		triangles:        append([][3]int(nil), triangles...),
+		removedTriangles: make([]bool, len(triangles)),
+		normals:          make([]vec3.T, len(triangles)),
+		removedVertices:  make([]bool, len(vertices)),
+		vertexTriangles:  make([][]int, len(vertices)),
+		quadrics:         make([]quadric, len(vertices)),
+		locked:           locked,
+		versions:         make([]int, len(vertices)),
+		maxCost:          maxError * maxError,
+	}
+
+	edgeTriangles := make(map[[2]int]int)
+	var edges [][2]int
+	for t, triangle := range d.triangles {
+		for k, v := range triangle {
+			d.vertexTriangles[v] = append(d.vertexTriangles[v], t)
+			edge := sortedEdge(v, triangle[(k+1)%3])
+			if edgeTriangles[edge] == 0 {
+				edges = append(edges, edge)
+			}
+			edgeTriangles[edge]++
+		}
+		// Degenerate triangles have no normal to preserve, so their vertices are never moved.
+		normal, ok := d.triangleNormal(triangle)
+		if !ok {
+			for _, v := range triangle {
+				d.locked[v] = true
+			}
+			continue
+		}
+		d.normals[t] = normal
+		planeQuadric := newPlaneQuadric(normal, d.vertices[triangle[0]])
+		for _, v := range triangle {
+			d.quadrics[v].add(&planeQuadric)
+		}
+	}
+	for edge, count := range edgeTriangles {
+		if count != 2 {
+			d.locked[edge[0]] = true
+			d.locked[edge[1]] = true
+		}
+	}
+	for _, edge := range edges {
+		d.pushCollapse(edge[0], edge[1])
+	}
+	return d
+}
+
+// sortedEdge returns the edge between the vertices with the lower vertex first.
+func sortedEdge(a, b int) [2]int {
+	if a > b {
+		return [2]int{b, a}
+	}
+	return [2]int{a, b}
+}
+
+// triangleNormal returns the unit normal of the triangle, false if it is degenerate.
+func (d *meshDecimator) triangleNormal(triangle [3]int) (vec3.T, bool) {
+	return triangleNormal(d.vertices[triangle[0]], d.vertices[triangle[1]], d.vertices[triangle[2]])
+}
+
+// triangleNormal returns the unit normal of the triangle, false if it is degenerate.
+func triangleNormal(v0, v1, v2 Point) (vec3.T, bool) {
+	e1 := vec3.Sub(&v1, &v0)
+	e2 := vec3.Sub(&v2, &v0)
+	normal := vec3.Cross(&e1, &e2)
+	length := normal.Length()
+	if length < 1e-12 {
+		return vec3.T{}, false
+	}
+	return normal.Scaled(1 / length), true
+}
+
+// pushCollapse queues the collapse of the edge if its error is within the maximum.
+// The edge collapses into its locked vertex if it has one.
+func (d *meshDecimator) pushCollapse(a, b int) {
+	if d.locked[a] && d.locked[b] {
+		return
+	}
+	keep, remove := a, b
+	if d.locked[b] {
+		keep, remove = b, a
+	}
+	q := d.quadrics[keep]
+	q.add(&d.quadrics[remove])
+
+	var target Point
+	if d.locked[keep] {
+		target = d.vertices[keep]
+	} else {
+		// Fall back to the best of the end points and the midpoint if the optimum is not unique.
+		midpoint := vec3.Interpolate(&d.vertices[keep], &d.vertices[remove], 0.5)
+		candidates := []Point{d.vertices[keep], d.vertices[remove], midpoint}
+		// Optima of nearly parallel planes lie far off, keep them close to the edge.
+		if optimum, ok := q.optimum(); ok && vec3.Distance(&optimum, &midpoint) <= vec3.Distance(&d.vertices[keep], &d.vertices[remove]) {
+			candidates = append(candidates, optimum)
+		}
+		target = candidates[0]
+		for _, candidate := range candidates[1:] {
+			if q.evaluate(candidate) < q.evaluate(target) {
+				target = candidate
+			}
+		}
+	}
+	cost := q.evaluate(target)
+	if cost > d.maxCost {
+		return
+	}
+	d.sequenceNumber++
+	heap.Push(&d.queue, edgeCollapse{
+		keep:           keep,
+		remove:         remove,
+		keepVersion:    d.versions[keep],
+		removeVersion:  d.versions[remove],
+		target:         target,
+		cost:           cost,
+		sequenceNumber: d.sequenceNumber,
+	})
+}
+
+// neighbors returns the vertices sharing a triangle with the vertex in triangle order.
+func (d *meshDecimator) neighbors(v int) []int {
+	var neighbors []int
+	for _, t := range d.vertexTriangles[v] {
+		for _, w := range d.triangles[t] {
+			if w != v && !slices.Contains(neighbors, w) {
+				neighbors = append(neighbors, w)
+			}
+		}
+	}
+	return neighbors
+}
+
+// canCollapse checks the collapse keeps the mesh manifold and does not flip any triangle.
+func (d *meshDecimator) canCollapse(collapse *edgeCollapse) bool {
+	// Link condition: the end points may only share the vertices opposite the edge.
+	keepNeighbors := d.neighbors(collapse.keep)
+	shared := 0
+	for _, w := range d.neighbors(collapse.remove) {
+		if slices.Contains(keepNeighbors, w) {
+			shared++
+		}
+	}
+	edgeTriangles := 0
+	for _, t := range d.vertexTriangles[collapse.remove] {
+		if d.triangleHas(t, collapse.keep) {
+			edgeTriangles++
+		}
+	}
+	if shared != edgeTriangles {
+		return false
+	}
+
+	for _, v := range [2]int{collapse.keep, collapse.remove} {
+		for _, t := range d.vertexTriangles[v] {
+			if d.triangleHas(t, collapse.keep) && d.triangleHas(t, collapse.remove) {
+				continue
+			}
+			var moved [3]Point
+			for k, w := range d.triangles[t] {
+				moved[k] = d.vertices[w]
+				if w == v {
+					moved[k] = collapse.target
+				}
+			}
+			after, ok := triangleNormal(moved[0], moved[1], moved[2])
+			if !ok {
+				return false
+			}
+			before, ok := d.triangleNormal(d.triangles[t])
+			if !ok || vec3.Dot(&before, &after) < kDecimationMinNormalDot ||
+				vec3.Dot(&d.normals[t], &after) < kDecimationMinNormalDot {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// triangleHas returns true if the triangle has the vertex.
+func (d *meshDecimator) triangleHas(t int, v int) bool {
+	triangle := d.triangles[t]
+	return triangle[0] == v || triangle[1] == v || triangle[2] == v
+}
+
+// collapse merges the removed vertex into the kept vertex at the target point.
+func (d *meshDecimator) collapse(collapse *edgeCollapse) {
+	keep, remove := collapse.keep, collapse.remove
+	d.vertices[keep] = collapse.target
+	d.quadrics[keep].add(&d.quadrics[remove])
+	d.removedVertices[remove] = true
+	d.versions[keep]++
+	d.versions[remove]++
+
+	var removedTriangles []int
+	keepTriangles := d.vertexTriangles[keep][:0]
+	for _, t := range d.vertexTriangles[keep] {
+		if d.triangleHas(t, remove) {
+			d.removedTriangles[t] = true
+			removedTriangles = append(removedTriangles, t)
+		} else {
+			keepTriangles = append(keepTriangles, t)
+		}
+	}
+	for _, t := range d.vertexTriangles[remove] {
+		if d.removedTriangles[t] {
+			continue
+		}
+		for k, w := range d.triangles[t] {
+			if w == remove {
+				d.triangles[t][k] = keep
+			}
+		}
+		keepTriangles = append(keepTriangles, t)
+	}
+	d.vertexTriangles[keep] = keepTriangles
+	d.vertexTriangles[remove] = nil
+
+	// Drop the removed triangles from the vertices opposite the edge.
+	for _, removed := range removedTriangles {
+		for _, w := range d.triangles[removed] {
+			if w == keep || w == remove {
+				continue
+			}
+			triangles := d.vertexTriangles[w][:0]
+			for _, t := range d.vertexTriangles[w] {
+				if !d.removedTriangles[t] {
+					triangles = append(triangles, t)
+				}
+			}
+			d.vertexTriangles[w] = triangles
+		}
+	}
+
+	// The edges of the moved vertex have new errors.
+	for _, w := range d.neighbors(keep) {
+		for _, x := range d.neighbors(w) {
+			d.pushCollapse(w, x)
+		}
+	}
+}
+
+// run collapses edges by increasing error until no collapse is within the maximum error.
+func (d *meshDecimator) run() {
+	for d.queue.Len() > 0 {
+		collapse := heap.Pop(&d.queue).(edgeCollapse)
+		if d.removedVertices[collapse.keep] || d.removedVertices[collapse.remove] ||
+			collapse.keepVersion != d.versions[collapse.keep] ||
+			collapse.removeVersion != d.versions[collapse.remove] {
+			continue
+		}
+		if !d.canCollapse(&collapse) {
+			continue
+		}
+		d.collapse(&collapse)
+	}
+}
+
+// result returns the remaining vertices and triangles, and the original index of every remaining vertex.
+func (d *meshDecimator) result() ([]Point, [][3]int, []int) {
+	newIndexes := make([]int, len(d.vertices))
+	for v := range newIndexes {
+		newIndexes[v] = -1
+	}
+	var vertices []Point
+	var triangles [][3]int
+	var originalIndexes []int
+	for t, triangle := range d.triangles {
+		if d.removedTriangles[t] {
+			continue
+		}
+		var newTriangle [3]int
+		for k, v := range triangle {
+			if newIndexes[v] < 0 {
+				newIndexes[v] = len(vertices)
+				vertices = append(vertices, d.vertices[v])
+				originalIndexes = append(originalIndexes, v)
+			}
+			newTriangle[k] = newIndexes[v]
+		}
+		triangles = append(triangles, newTriangle)
+	}
+	return vertices, triangles, originalIndexes
+}
+
+// weldVertices merges the vertices closer than the tolerance and drops the triangles collapsing to a line.
+// Returns the welded vertices and triangles, and the original index of every welded vertex.
+func weldVertices(vertices []Point, triangles [][3]int, tolerance float64) ([]Point, [][3]int, []int) {
+	keys := make(map[[3]int64]int)
+	weldedIndexes := make([]int, len(vertices))
+	var welded []Point
+	var originalIndexes []int
+	for v, vertex := range vertices {
+		key := [3]int64{
+			int64(math.Round(vertex[0] / tolerance)),
+			int64(math.Round(vertex[1] / tolerance)),
+			int64(math.Round(vertex[2] / tolerance)),
+		}
+		w, ok := keys[key]
+		if !ok {
+			w = len(welded)
+			keys[key] = w
+			welded = append(welded, vertex)
+			originalIndexes = append(originalIndexes, v)
+		}
+		weldedIndexes[v] = w
+	}
+	weldedTriangles := make([][3]int, 0, len(triangles))
+	for _, triangle := range triangles {
+		a, b, c := weldedIndexes[triangle[0]], weldedIndexes[triangle[1]], weldedIndexes[triangle[2]]
+		if a != b && b != c && a != c {
+			weldedTriangles = append(weldedTriangles, [3]int{a, b, c})
+		}
+	}
+	return welded, weldedTriangles, originalIndexes
+}
+
+// remapVertexAttributes returns the attributes of the remaining vertices,
+// nil if the attributes do not cover all original vertices.
+func remapVertexAttributes[T any](attributes []T, vertexCount int, originalIndexes []int) []T {
+	if len(attributes) != vertexCount {
+		return nil
+	}
+	remapped := make([]T, len(originalIndexes))
+	for v, original := range originalIndexes {
+		remapped[v] = attributes[original]
+	}
+	return remapped
+}
+
+// decimate simplifies the mesh of the block by collapsing edges while the mean squared
+// distance of the moved vertices to the planes of their original triangles stays below maxError squared.
+// Coincident vertices computed by neighboring cubes are welded first.
+// Vertices on the block borders and on open mesh boundaries are never moved, so neighboring blocks still meet.
+// Returns the reduction in triangles and glTF buffer bytes.
+// Thread-safe.
+func (b *MeshBlock) decimate(maxError float64) DecimationStats {
+	b.Lock()
+	defer b.Unlock()
+	stats := DecimationStats{Blocks: 1, TrianglesBefore: len(b.triangles), BytesBefore: b.gltfBufferSize()}
+
+	weldedVertices, weldedTriangles, weldedIndexes := weldVertices(b.vertices, b.triangles, kVertexWeldTolerance*b.VoxelSize)
+
+	// The block mesh spans the voxel centers from the first voxel of the block to the first voxel of the next block.
+	locked := make([]bool, len(weldedVertices))
+	epsilon := kVertexWeldTolerance * b.VoxelSize
+	for v, vertex := range weldedVertices {
+		for k := 0; k < 3; k++ {
+			low := b.Origin[k] + b.VoxelSize/2
+			if math.Abs(vertex[k]-low) < epsilon || math.Abs(vertex[k]-low-b.BlockSize) < epsilon {
+				locked[v] = true
+			}
+		}
+	}
+
+	decimator := newMeshDecimator(weldedVertices, weldedTriangles, locked, maxError)
+	decimator.run()
+	vertices, triangles, originalIndexes := decimator.result()
+	for v, welded := range originalIndexes {
+		originalIndexes[v] = weldedIndexes[welded]
+	}
+	vertexCount := len(b.vertices)
+	b.vertices = vertices
+	b.triangles = triangles
+	b.colors = remapVertexAttributes(b.colors, vertexCount, originalIndexes)
+	b.labels = remapVertexAttributes(b.labels, vertexCount, originalIndexes)
+	b.instances = remapVertexAttributes(b.instances, vertexCount, originalIndexes)
+	stats.TrianglesAfter = len(b.triangles)
+	stats.BytesAfter = b.gltfBufferSize()
+	return stats
+}
@@ -0,0 +1,138 @@
+package voxblox
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/vec3"
+)
+
+func TestQuadric(t *testing.T) {
+	var q quadric
+	for k := 0; k < 3; k++ {
+		var normal vec3.T
+		normal[k] = 1
+		plane := newPlaneQuadric(normal, Point{1, 2, 3})
+		q.add(&plane)
+	}
+	assert.InDelta(t, 0, q.evaluate(Point{1, 2, 3}), 1e-12)
+	assert.InDelta(t, 3.0/3, q.evaluate(Point{2, 3, 4}), 1e-12)
+	optimum, ok := q.optimum()
+	assert.True(t, ok)
+	assert.InDeltaSlice(t, []float64{1, 2, 3}, optimum[:], 1e-9)
+
+	// A single plane does not constrain a point.
+	plane := newPlaneQuadric(vec3.T{0, 0, 1}, Point{0, 0, 1})
+	_, ok = plane.optimum()
+	assert.False(t, ok)
+}
+
+// wallNormal is the normal of the tilted wall of wallSdf.
+var wallNormal = func() vec3.T {
+	normal := vec3.T{1, 0.3, 0}
+	return *normal.Normalize()
+}()
+
+// wallSdf is the signed distance function of a tilted wall crossing the blocks {0, 0, 0} and {0, 1, 0}.
+func wallSdf(center Point) float64 {
+	return vec3.Dot(&wallNormal, &center) - 0.93
+}
+
+func TestMeshDecimationFlatWall(t *testing.T) {
+	layer := getTestSdfLayer(
+		NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide),
+		wallSdf,
+		IndexType{0, 0, 0},
+		IndexType{0, 1, 0},
+	)
+	meshLayer := NewMeshLayer(layer)
+	NewMeshIntegrator(config, layer, meshLayer).IntegrateAll()
+
+	decimationConfig := config
+	decimationConfig.MeshDecimationError = 0.001
+	decimatedMeshLayer := NewMeshLayer(layer)
+	meshIntegrator := NewMeshIntegrator(decimationConfig, layer, decimatedMeshLayer)
+	meshIntegrator.IntegrateAll()
+
+	triangles, decimatedTriangles := 0, 0
+	for blockIndex, meshBlock := range meshLayer.GetBlocks() {
+		vertices, blockTriangles, _ := meshBlock.GetMesh()
+		decimatedVertices, decimatedBlockTriangles, colors := decimatedMeshLayer.GetBlocks()[blockIndex].GetMesh()
+		triangles += len(blockTriangles)
+		decimatedTriangles += len(decimatedBlockTriangles)
+		assert.Len(t, colors, len(decimatedVertices))
+
+		// The wall stays in place.
+		for _, vertex := range decimatedVertices {
+			assert.InDelta(t, 0.93, vec3.Dot(&wallNormal, &vertex), 1e-6)
+		}
+		for _, triangle := range decimatedBlockTriangles {
+			for _, vertexIndex := range triangle {
+				assert.Less(t, vertexIndex, len(decimatedVertices))
+			}
+		}
+
+		// The vertices on the block borders are kept so neighboring blocks still meet.
+		for _, vertex := range vertices {
+			onBorder := false
+			for k := 0; k < 3; k++ {
+				low := meshBlock.Origin[k] + meshBlock.VoxelSize/2
+				onBorder = onBorder ||
+					math.Abs(vertex[k]-low) < 1e-9 ||
+					math.Abs(vertex[k]-low-meshBlock.BlockSize) < 1e-9
+			}
+			if onBorder {
+				kept := false
+				for _, decimatedVertex := range decimatedVertices {
+					kept = kept || vec3.Distance(&vertex, &decimatedVertex) < 1e-4*meshBlock.VoxelSize
+				}
+				assert.True(t, kept, "border vertex %v should be kept", vertex)
+			}
+		}
+	}
+	assert.Less(t, decimatedTriangles*5, triangles)
+
+	stats := meshIntegrator.GetDecimationStats()
+	assert.Equal(t, layer.GetBlockCount(), stats.Blocks)
+	assert.Equal(t, triangles, stats.TrianglesBefore)
+	assert.Equal(t, decimatedTriangles, stats.TrianglesAfter)
+	assert.Less(t, stats.BytesAfter, stats.BytesBefore)
+}
+
+func TestMeshDecimationCylinder(t *testing.T) {
+	layer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	integrator := NewFastTsdfIntegrator(&config, layer)
+	for _, pose := range poses[:10] {
+		integrator.IntegratePointCloud(pose, getTransformedPointCloud(pose))
+	}
+	meshLayer := NewMeshLayer(layer)
+	NewMeshIntegrator(config, layer, meshLayer).IntegrateAll()
+
+	decimationConfig := config
+	decimationConfig.MeshDecimationError = 0.005
+	decimatedMeshLayer := NewMeshLayer(layer)
+	meshIntegrator := NewMeshIntegrator(decimationConfig, layer, decimatedMeshLayer)
+	meshIntegrator.IntegrateAll()
+	stats := meshIntegrator.GetDecimationStats()
+	assert.Less(t, stats.TrianglesAfter, stats.TrianglesBefore)
+	assert.Less(t, stats.BytesAfter, stats.BytesBefore)
+
+	// Decimated vertices stay on the surface.
+	triangles := 0
+	for blockIndex, meshBlock := range decimatedMeshLayer.GetBlocks() {
+		vertices, _, _ := meshLayer.GetBlocks()[blockIndex].GetMesh()
+		decimatedVertices, decimatedTriangles, _ := meshBlock.GetMesh()
+		triangles += len(decimatedTriangles)
+		for _, decimatedVertex := range decimatedVertices {
+			distance := math.Inf(1)
+			for _, vertex := range vertices {
+				distance = math.Min(distance, vec3.Distance(&vertex, &decimatedVertex))
+			}
+			assert.Less(t, distance, config.VoxelSize)
+		}
+	}
+	assert.Equal(t, stats.TrianglesAfter, triangles)
+	WriteMeshLayerToObjFiles(decimatedMeshLayer, filepath.Join(t.TempDir(), "decimated_mesh"))
+}
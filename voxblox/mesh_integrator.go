@@ -13,13 +13,28 @@ type MeshIntegrator struct {
 	CubeCoordOffsets []Point
 	TsdfLayer        *TsdfLayer
 	MeshLayer        *MeshLayer
+	// MultiResolutionLayer is meshed instead of TsdfLayer if set.
+	MultiResolutionLayer *MultiResolutionTsdfLayer
+	// Vertices are labeled from SemanticLayer if set.
+	SemanticLayer *SemanticLayer
+	// Vertices are assigned instances from InstanceLayer if set.
+	InstanceLayer *InstanceLayer
+	// Vertices are colored from ChannelLayer in channel color mode.
+	ChannelLayer *ChannelLayer
+	// Vertices are colored from ObservationLayer in age color mode.
+	ObservationLayer *ObservationLayer
+	// Serializes Integrate calls.
+	sync.Mutex
+	// decimationStats accumulates the reduction of the mesh decimation.
+	decimationStats     DecimationStats
+	decimationStatsLock sync.Mutex
 }
 
 func NewMeshIntegrator(
 	config Config,
 	tsdfLayer *TsdfLayer,
 	meshLayer *MeshLayer,
-) MeshIntegrator {
+) *MeshIntegrator {
 	i := new(MeshIntegrator)
 	i.Config = config
 	i.TsdfLayer = tsdfLayer
@@ -40,12 +55,25 @@ func NewMeshIntegrator(
 		offset := IndexToPoint(i.CubeIndexOffsets[j])
 		i.CubeCoordOffsets[j] = offset.Scaled(i.TsdfLayer.VoxelSize)
 	}
-	return *i
+	return i
+}
+
+// NewMultiResolutionMeshIntegrator creates a MeshIntegrator for a MultiResolutionTsdfLayer.
+// Every block is meshed at the resolution of level 0, which is TsdfLayer.
+func NewMultiResolutionMeshIntegrator(
+	config Config,
+	tsdfLayer *MultiResolutionTsdfLayer,
+	meshLayer *MeshLayer,
+) *MeshIntegrator {
+	i := NewMeshIntegrator(config, tsdfLayer.Levels[0], meshLayer)
+	i.MultiResolutionLayer = tsdfLayer
+	return i
 }
 
 func (i *MeshIntegrator) extractMeshInsideBlock(
 	tsdfBlock *TsdfBlock,
 	meshBlock *MeshBlock,
+	cache *vertexCache,
 	voxelIndex IndexType,
 ) {
 	coords := tsdfBlock.computeCoordinatesFromVoxelIndex(voxelIndex)
@@ -57,8 +85,8 @@ func (i *MeshIntegrator) extractMeshInsideBlock(
 
 	for j := 0; j < 8; j++ {
 		cornerIndex := addIndex(voxelIndex, i.CubeIndexOffsets[j])
-		voxel := tsdfBlock.getVoxelIfExists(cornerIndex)
-		if voxel == nil {
+		voxel, ok := tsdfBlock.getVoxelIfExists(cornerIndex)
+		if !ok {
 			allNeighborsObserved = false
 			break
 		}
@@ -71,6 +99,8 @@ func (i *MeshIntegrator) extractMeshInsideBlock(
 	}
 	if allNeighborsObserved {
 		meshCube(
+			cache,
+			voxelIndex,
 			&cornerCoords,
 			&cornerSdf,
 			meshBlock,
@@ -81,6 +111,7 @@ func (i *MeshIntegrator) extractMeshInsideBlock(
 func (i *MeshIntegrator) extractMeshOnBorder(
 	tsdfBlock *TsdfBlock,
 	meshBlock *MeshBlock,
+	cache *vertexCache,
 	voxelIndex IndexType,
 ) {
 	coords := tsdfBlock.computeCoordinatesFromVoxelIndex(voxelIndex)
@@ -94,8 +125,8 @@ func (i *MeshIntegrator) extractMeshOnBorder(
 		cornerIndex := addIndex(voxelIndex, i.CubeIndexOffsets[j])
 
 		if tsdfBlock.isValidVoxelIndex(cornerIndex) {
-			voxel := tsdfBlock.getVoxelIfExists(cornerIndex)
-			if voxel == nil {
+			voxel, ok := tsdfBlock.getVoxelIfExists(cornerIndex)
+			if !ok {
 				allNeighborsObserved = false
 				break
 			}
@@ -127,8 +158,8 @@ func (i *MeshIntegrator) extractMeshOnBorder(
 				break
 			}
 
-			voxel := neighborBlock.getVoxelIfExists(cornerIndex)
-			if voxel == nil {
+			voxel, ok := neighborBlock.getVoxelIfExists(cornerIndex)
+			if !ok {
 				allNeighborsObserved = false
 				break
 			}
@@ -142,6 +173,8 @@ func (i *MeshIntegrator) extractMeshOnBorder(
 	}
 	if allNeighborsObserved {
 		meshCube(
+			cache,
+			voxelIndex,
 			&cornerCoords,
 			&cornerSdf,
 			meshBlock,
@@ -150,9 +183,10 @@ func (i *MeshIntegrator) extractMeshOnBorder(
 }
 
 func (i *MeshIntegrator) updateMeshForBlock(tsdfBlock *TsdfBlock, wg *sync.WaitGroup) {
-	meshBlock := i.MeshLayer.getNewBlockByIndex(tsdfBlock.Index)
+	meshBlock := i.MeshLayer.newBlock(tsdfBlock.Index)
 
 	vps := i.TsdfLayer.VoxelsPerSide
+	cache := newVertexCache(vps)
 
 	voxelIndex := IndexType{}
 
@@ -163,6 +197,7 @@ func (i *MeshIntegrator) updateMeshForBlock(tsdfBlock *TsdfBlock, wg *sync.WaitG
 				i.extractMeshInsideBlock(
 					tsdfBlock,
 					meshBlock,
+					cache,
 					voxelIndex,
 				)
 			}
@@ -176,6 +211,7 @@ func (i *MeshIntegrator) updateMeshForBlock(tsdfBlock *TsdfBlock, wg *sync.WaitG
 			i.extractMeshOnBorder(
 				tsdfBlock,
 				meshBlock,
+				cache,
 				voxelIndex,
 			)
 		}
@@ -188,6 +224,7 @@ func (i *MeshIntegrator) updateMeshForBlock(tsdfBlock *TsdfBlock, wg *sync.WaitG
 			i.extractMeshOnBorder(
 				tsdfBlock,
 				meshBlock,
+				cache,
 				voxelIndex,
 			)
 		}
@@ -200,26 +237,35 @@ func (i *MeshIntegrator) updateMeshForBlock(tsdfBlock *TsdfBlock, wg *sync.WaitG
 			i.extractMeshOnBorder(
 				tsdfBlock,
 				meshBlock,
+				cache,
 				voxelIndex,
 			)
 		}
 	}
 
 	if i.Config.UseColor {
-		i.updateMeshColorForBlock(tsdfBlock)
+		i.updateMeshColorForBlock(tsdfBlock, meshBlock)
+	}
+	if i.labelMesh() {
+		i.updateMeshLabelsForBlock(meshBlock)
+	}
+	if i.ChannelLayer != nil && i.Config.MeshColorMode == MeshColorModeChannel {
+		i.updateMeshChannelColorsForBlock(meshBlock)
+	}
+	if i.ObservationLayer != nil && i.Config.MeshColorMode == MeshColorModeAge {
+		i.updateMeshAgeColorsForBlock(meshBlock)
 	}
+	if i.Config.MeshDecimationError > 0 {
+		i.decimateBlock(meshBlock)
+	}
+	i.MeshLayer.setBlock(meshBlock)
 
 	tsdfBlock.setNotUpdated()
 
 	wg.Done()
 }
 
-func (i *MeshIntegrator) updateMeshColorForBlock(tsdfBlock *TsdfBlock) {
-	meshBlock := i.MeshLayer.getBlockIfExists(tsdfBlock.Index)
-	if meshBlock == nil {
-		return
-	}
-
+func (i *MeshIntegrator) updateMeshColorForBlock(tsdfBlock *TsdfBlock, meshBlock *MeshBlock) {
 	meshBlock.Lock()
 	defer meshBlock.Unlock()
 
@@ -230,27 +276,307 @@ func (i *MeshIntegrator) updateMeshColorForBlock(tsdfBlock *TsdfBlock) {
 	for j := 0; j < vertexCount; j++ {
 		vertex := meshBlock.vertices[j]
 		voxelIndex := tsdfBlock.computeVoxelIndexFromCoordinates(vertex)
-		voxel := tsdfBlock.getVoxelIfExists(voxelIndex)
-		if voxel != nil && voxel.getWeight() > i.Config.MinWeight {
+		voxel, ok := tsdfBlock.getVoxelIfExists(voxelIndex)
+		if ok && voxel.getWeight() > i.Config.MinWeight {
 			meshBlock.colors[j] = voxel.getColor()
 		} else {
 			neighborBlock := i.TsdfLayer.getBlockByCoordinates(vertex)
 			voxelIndex := neighborBlock.computeVoxelIndexFromCoordinates(vertex)
-			voxel := neighborBlock.getVoxelIfExists(voxelIndex)
-			if voxel != nil && voxel.getWeight() > i.Config.MinWeight {
+			voxel, ok := neighborBlock.getVoxelIfExists(voxelIndex)
+			if ok && voxel.getWeight() > i.Config.MinWeight {
 				meshBlock.colors[j] = voxel.getColor()
 			}
 		}
 	}
 }
 
+// labelMesh returns true if the mesh vertices are labeled or assigned instances.
+func (i *MeshIntegrator) labelMesh() bool {
+	return i.SemanticLayer != nil || i.InstanceLayer != nil
+}
+
+// updateMeshLabelsForBlock labels every vertex with the majority label and instance of the voxel containing it.
+// In label and instance color modes the vertices are colored by their label or instance.
+func (i *MeshIntegrator) updateMeshLabelsForBlock(meshBlock *MeshBlock) {
+
+	meshBlock.Lock()
+	defer meshBlock.Unlock()
+
+	if i.SemanticLayer != nil {
+		meshBlock.labels = make([]Label, len(meshBlock.vertices))
+		for j, vertex := range meshBlock.vertices {
+			globalVoxelIndex := getGridIndexFromPoint(vertex, i.SemanticLayer.VoxelSizeInv)
+			meshBlock.labels[j], _ = i.SemanticLayer.getLabel(globalVoxelIndex)
+		}
+		if i.Config.MeshColorMode == MeshColorModeLabel {
+			meshBlock.colors = make([]Color, len(meshBlock.vertices))
+			for j, label := range meshBlock.labels {
+				meshBlock.colors[j] = labelColor(label)
+			}
+		}
+	}
+	if i.InstanceLayer != nil {
+		meshBlock.instances = make([]InstanceID, len(meshBlock.vertices))
+		for j, vertex := range meshBlock.vertices {
+			globalVoxelIndex := getGridIndexFromPoint(vertex, i.InstanceLayer.VoxelSizeInv)
+			meshBlock.instances[j], _ = i.InstanceLayer.getLabel(globalVoxelIndex)
+		}
+		if i.Config.MeshColorMode == MeshColorModeInstance {
+			meshBlock.colors = make([]Color, len(meshBlock.vertices))
+			for j, instance := range meshBlock.instances {
+				meshBlock.colors[j] = labelColor(instance)
+			}
+		}
+	}
+}
+
+// updateMeshChannelColorsForBlock colors every vertex by the mesh color channel of the voxel containing it.
+// Vertices in voxels without samples of the channel are white.
+func (i *MeshIntegrator) updateMeshChannelColorsForBlock(meshBlock *MeshBlock) {
+	channel := i.ChannelLayer.GetChannelIndex(i.Config.MeshColorChannel)
+	if channel < 0 {
+		return
+	}
+
+	meshBlock.Lock()
+	defer meshBlock.Unlock()
+
+	meshBlock.colors = make([]Color, len(meshBlock.vertices))
+	for j, vertex := range meshBlock.vertices {
+		globalVoxelIndex := getGridIndexFromPoint(vertex, i.ChannelLayer.VoxelSizeInv)
+		value, ok := i.ChannelLayer.getValue(channel, globalVoxelIndex)
+		if !ok {
+			meshBlock.colors[j] = ColorWhite
+			continue
+		}
+		meshBlock.colors[j] = channelColor(
+			float64(value),
+			i.Config.MeshChannelMin,
+			i.Config.MeshChannelMax,
+			i.Config.MeshColormap,
+		)
+	}
+}
+
+// updateMeshAgeColorsForBlock colors every vertex by the time between the last observation
+// of the voxel containing it and the latest observed point cloud.
+// Vertices in voxels without a known observation time are white.
+func (i *MeshIntegrator) updateMeshAgeColorsForBlock(meshBlock *MeshBlock) {
+	latestObserved := i.ObservationLayer.GetLatestObserved()
+
+	meshBlock.Lock()
+	defer meshBlock.Unlock()
+
+	meshBlock.colors = make([]Color, len(meshBlock.vertices))
+	for j, vertex := range meshBlock.vertices {
+		globalVoxelIndex := getGridIndexFromPoint(vertex, i.ObservationLayer.VoxelSizeInv)
+		observation, ok := i.ObservationLayer.getObservation(globalVoxelIndex)
+		if !ok || observation.lastObserved == 0 {
+			meshBlock.colors[j] = ColorWhite
+			continue
+		}
+		meshBlock.colors[j] = ageColor(
+			latestObserved.Sub(secondsToStamp(observation.lastObserved)),
+			i.Config.MeshAgeRange,
+		)
+	}
+}
+
+// decimateBlock simplifies the mesh of the block and accumulates the reduction.
+// Thread-safe.
+func (i *MeshIntegrator) decimateBlock(meshBlock *MeshBlock) {
+	stats := meshBlock.decimate(i.Config.MeshDecimationError)
+
+	i.decimationStatsLock.Lock()
+	defer i.decimationStatsLock.Unlock()
+	i.decimationStats.add(stats)
+}
+
+// GetDecimationStats returns the reduction of the mesh decimation accumulated over all integrations.
+// Thread-safe.
+func (i *MeshIntegrator) GetDecimationStats() DecimationStats {
+	i.decimationStatsLock.Lock()
+	defer i.decimationStatsLock.Unlock()
+	return i.decimationStats
+}
+
+// logDecimation logs the reduction of the mesh decimation since the stats were taken.
+func (i *MeshIntegrator) logDecimation(before DecimationStats) {
+	if i.Config.MeshDecimationError <= 0 {
+		return
+	}
+	after := i.GetDecimationStats()
+	i.Config.GetLogger().Debug(
+		"Decimate Mesh",
+		"blocks", after.Blocks-before.Blocks,
+		"triangles", after.TrianglesBefore-before.TrianglesBefore,
+		"decimated_triangles", after.TrianglesAfter-before.TrianglesAfter,
+		"bytes", after.BytesBefore-before.BytesBefore,
+		"decimated_bytes", after.BytesAfter-before.BytesAfter,
+	)
+}
+
+// addNewBlockNeighbors adds the existing neighbors of the updated blocks without a mesh yet to the updated blocks.
+// The border cubes of the neighbors on the lower side reach into a new block, so they were skipped
+// while the block was missing and leave a crack until the neighbors are re-meshed.
+func (i *MeshIntegrator) addNewBlockNeighbors(
+	updatedBlocks map[IndexType]*TsdfBlock,
+	getBlockIfExists func(IndexType) *TsdfBlock,
+) {
+	var newBlocks []IndexType
+	for blockIndex := range updatedBlocks {
+		if i.MeshLayer.getBlockIfExists(blockIndex) == nil {
+			newBlocks = append(newBlocks, blockIndex)
+		}
+	}
+	for _, blockIndex := range newBlocks {
+		// Skip the first offset, which is the block itself.
+		for _, offset := range i.CubeIndexOffsets[1:] {
+			neighborIndex := subIndex(blockIndex, offset)
+			if _, ok := updatedBlocks[neighborIndex]; ok {
+				continue
+			}
+			if neighbor := getBlockIfExists(neighborIndex); neighbor != nil {
+				updatedBlocks[neighborIndex] = neighbor
+			}
+		}
+	}
+}
+
+// Integrate re-meshes the updated blocks.
+// Thread-safe.
 func (i *MeshIntegrator) Integrate() {
-	defer TimeTrack(time.Now(), "Integrate Mesh")
+	i.Lock()
+	defer i.Unlock()
+
+	if i.MultiResolutionLayer != nil {
+		i.integrateMultiResolution()
+		return
+	}
+
+	updatedBlocks := i.TsdfLayer.getUpdatedBlocks()
+	i.addNewBlockNeighbors(updatedBlocks, i.TsdfLayer.getBlockIfExists)
+	defer TimeTrack(i.Config.GetLogger(), time.Now(), "Integrate Mesh", "blocks", len(updatedBlocks))
+	defer i.logDecimation(i.GetDecimationStats())
 
 	wg := sync.WaitGroup{}
-	for _, block := range i.TsdfLayer.getUpdatedBlocks() {
+	for _, block := range updatedBlocks {
 		wg.Add(1)
 		go i.updateMeshForBlock(block, &wg)
 	}
 	wg.Wait()
 }
+
+// IntegrateAll re-meshes every block in the TSDF Layer.
+func (i *MeshIntegrator) IntegrateAll() {
+	if i.MultiResolutionLayer != nil {
+		i.MultiResolutionLayer.setAllUpdated()
+	} else {
+		i.TsdfLayer.setAllUpdated()
+	}
+	i.Integrate()
+}
+
+// integrateMultiResolution re-meshes the updated blocks of every level.
+func (i *MeshIntegrator) integrateMultiResolution() {
+	updatedBlocks := i.MultiResolutionLayer.getUpdatedBlocks()
+	i.addNewBlockNeighbors(updatedBlocks, i.MultiResolutionLayer.getBlockIfExists)
+	defer TimeTrack(i.Config.GetLogger(), time.Now(), "Integrate Multi-Resolution Mesh", "blocks", len(updatedBlocks))
+	defer i.logDecimation(i.GetDecimationStats())
+
+	wg := sync.WaitGroup{}
+	for _, block := range updatedBlocks {
+		wg.Add(1)
+		go i.updateMultiResolutionMeshForBlock(block, &wg)
+	}
+	wg.Wait()
+}
+
+// updateMultiResolutionMeshForBlock meshes a block of any level on the level 0 grid.
+// The cube corners are sampled through the MultiResolutionTsdfLayer, so neighboring
+// blocks of different levels compute identical vertices on their shared faces.
+func (i *MeshIntegrator) updateMultiResolutionMeshForBlock(tsdfBlock *TsdfBlock, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	meshBlock := i.MeshLayer.newBlock(tsdfBlock.Index)
+
+	vps := i.TsdfLayer.VoxelsPerSide
+	blockOrigin := IndexType{
+		tsdfBlock.Index[0] * vps,
+		tsdfBlock.Index[1] * vps,
+		tsdfBlock.Index[2] * vps,
+	}
+
+	// Sample the voxels of the block and the first voxels of its neighbors once.
+	samples := newVoxelArray[TsdfVoxel](vps + 1)
+	for j := range samples.voxels {
+		globalVoxelIndex := addIndex(blockOrigin, samples.voxelIndex(j))
+		if voxel, ok := i.MultiResolutionLayer.sampleVoxel(globalVoxelIndex); ok {
+			samples.voxels[j] = voxel
+		}
+	}
+
+	cache := newVertexCache(vps)
+	voxelIndex := IndexType{}
+	for voxelIndex[0] = 0; voxelIndex[0] < vps; voxelIndex[0]++ {
+		for voxelIndex[1] = 0; voxelIndex[1] < vps; voxelIndex[1]++ {
+			for voxelIndex[2] = 0; voxelIndex[2] < vps; voxelIndex[2]++ {
+				cornerCoords := [8][3]float64{}
+				cornerSdf := [8]float64{}
+
+				allNeighborsObserved := true
+				for j := 0; j < 8; j++ {
+					cornerIndex := addIndex(voxelIndex, i.CubeIndexOffsets[j])
+					voxel := samples.at(cornerIndex)
+					if !voxel.isObserved() || voxel.getWeight() < i.Config.MinWeight {
+						allNeighborsObserved = false
+						break
+					}
+					cornerCoords[j] = getCenterPointFromGridIndex(
+						addIndex(blockOrigin, cornerIndex),
+						i.TsdfLayer.VoxelSize,
+					)
+					cornerSdf[j] = voxel.getDistance()
+				}
+				if allNeighborsObserved {
+					meshCube(
+						cache,
+						voxelIndex,
+						&cornerCoords,
+						&cornerSdf,
+						meshBlock,
+					)
+				}
+			}
+		}
+	}
+
+	if i.Config.UseColor {
+		// Use nearest-neighbor search.
+		meshBlock.Lock()
+		meshBlock.colors = make([]Color, len(meshBlock.vertices))
+		for j, vertex := range meshBlock.vertices {
+			globalVoxelIndex := getGridIndexFromPoint(vertex, i.TsdfLayer.VoxelSizeInv)
+			voxel, ok := i.MultiResolutionLayer.sampleVoxel(globalVoxelIndex)
+			if ok && voxel.getWeight() > i.Config.MinWeight {
+				meshBlock.colors[j] = voxel.getColor()
+			}
+		}
+		meshBlock.Unlock()
+	}
+	if i.labelMesh() {
+		i.updateMeshLabelsForBlock(meshBlock)
+	}
+	if i.ChannelLayer != nil && i.Config.MeshColorMode == MeshColorModeChannel {
+		i.updateMeshChannelColorsForBlock(meshBlock)
+	}
+	if i.ObservationLayer != nil && i.Config.MeshColorMode == MeshColorModeAge {
+		i.updateMeshAgeColorsForBlock(meshBlock)
+	}
+	if i.Config.MeshDecimationError > 0 {
+		i.decimateBlock(meshBlock)
+	}
+	i.MeshLayer.setBlock(meshBlock)
+
+	tsdfBlock.setNotUpdated()
+}
@@ -1,7 +1,5 @@
 package voxblox
 
-import "sync"
-
 type MeshLayer struct {
 	VoxelSize        float64
 	VoxelSizeInv     float64
@@ -9,8 +7,7 @@ type MeshLayer struct {
 	VoxelsPerSideInv float64
 	BlockSize        float64
 	BlockSizeInv     float64
-	sync.RWMutex
-	blocks map[IndexType]*MeshBlock
+	blocks           *blockHash[*MeshBlock]
 }
 
 func NewMeshLayer(tsdfLayer *TsdfLayer) *MeshLayer {
@@ -21,70 +18,64 @@ func NewMeshLayer(tsdfLayer *TsdfLayer) *MeshLayer {
 		VoxelsPerSideInv: tsdfLayer.VoxelsPerSideInv,
 		BlockSize:        tsdfLayer.BlockSize,
 		BlockSizeInv:     tsdfLayer.BlockSizeInv,
-		blocks:           make(map[IndexType]*MeshBlock),
+		blocks:           newBlockHash[*MeshBlock](),
 	}
 	return &meshLayer
 }
 
+// Clear removes all blocks from the layer.
+// Thread-safe.
+func (l *MeshLayer) Clear() {
+	l.blocks.clear()
+}
+
 // getBlockCount returns the number of blocks allocated in the map
+// Thread-safe.
 func (l *MeshLayer) getBlockCount() int {
-	l.RLock()
-	defer l.RUnlock()
-	return len(l.blocks)
+	return l.blocks.len()
 }
 
-// GetBlocks returns the blocks in the map
+// GetBlocks returns a copy of the map of blocks
 // Thread-safe.
 func (l *MeshLayer) GetBlocks() map[IndexType]*MeshBlock {
-	l.RLock()
-	defer l.RUnlock()
-	return l.blocks
+	return l.blocks.snapshot()
 }
 
 // getBlockByIndex allocates a new block in the map or returns an existing one
+// Concurrent calls for the same index return the same block.
+// Thread-safe.
 func (l *MeshLayer) getBlockByIndex(blockIndex IndexType) *MeshBlock {
-	// Test if block already exists
-	l.RLock()
-	block, ok := l.blocks[blockIndex]
-	l.RUnlock()
-	if ok {
-		return block
-	}
-	newBlock := NewMeshBlock(
+	return l.blocks.getOrCreate(blockIndex, func() *MeshBlock {
+		return NewMeshBlock(
+			l,
+			blockIndex,
+			getOriginPointFromGridIndex(blockIndex, l.BlockSize),
+		)
+	})
+}
+
+// newBlock allocates a new block without adding it to the map.
+// The block is added with setBlock once its mesh is complete, so readers never see a partial mesh.
+func (l *MeshLayer) newBlock(blockIndex IndexType) *MeshBlock {
+	return NewMeshBlock(
 		l,
 		blockIndex,
 		getOriginPointFromGridIndex(blockIndex, l.BlockSize),
 	)
-	l.Lock()
-	l.blocks[blockIndex] = newBlock
-	l.Unlock()
-	return newBlock
 }
 
-// getNewBlockByIndex allocates a new block in the map and returns it
+// setBlock adds the block to the map
 // Overwrites any existing block
-func (l *MeshLayer) getNewBlockByIndex(blockIndex IndexType) *MeshBlock {
-	l.Lock()
-	defer l.Unlock()
-	newBlock := NewMeshBlock(
-		l,
-		blockIndex,
-		getOriginPointFromGridIndex(blockIndex, l.BlockSize),
-	)
-	l.blocks[blockIndex] = newBlock
-	return newBlock
+// Thread-safe.
+func (l *MeshLayer) setBlock(block *MeshBlock) {
+	l.blocks.set(block.Index, block)
 }
 
 // getBlockIfExists returns a pointer to the block if it exists
 // Thread-safe.
 func (l *MeshLayer) getBlockIfExists(index IndexType) *MeshBlock {
-	l.RLock()
-	defer l.RUnlock()
-	block, ok := l.blocks[index]
-	if ok {
-		return block
-	}
-	return nil
+	block, _ := l.blocks.get(index)
+	return block
 }
 
 // getBlockByCoordinates returns a pointer to the block by coordinates
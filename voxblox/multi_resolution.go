@@ -0,0 +1,540 @@
+package voxblox
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// kMinComplexityNormals is the number of surface normals needed to estimate
+// the surface complexity of a block.
+const kMinComplexityNormals = 8
+
+// MultiResolutionTsdfLayer is an adaptive TSDF map in which every block picks its own voxel resolution.
+// Level 0 has the finest voxels, every further level doubles the voxel size and halves the
+// voxels per side so all levels share the same block size.
+// Every block index is owned by a single level.
+// Blocks are assigned a level by their distance from the sensor and are refined, never coarsened,
+// when they are observed from closer or their surface is too complex for their resolution.
+type MultiResolutionTsdfLayer struct {
+	Levels        []*TsdfLayer
+	LevelDistance float64
+	BlockSize     float64
+	BlockSizeInv  float64
+	// Protects owners and serializes changes of block ownership.
+	sync.RWMutex
+	owners map[IndexType]int
+}
+
+// NewMultiResolutionTsdfLayer creates a new MultiResolutionTsdfLayer.
+// voxelSize and voxelsPerSide describe level 0, voxelsPerSide must be divisible by 2^(levels-1).
+// Blocks closer than levelDistance to the sensor use level 0, level l is used from
+// levelDistance*2^(l-1) on.
+func NewMultiResolutionTsdfLayer(
+	voxelSize float64,
+	voxelsPerSide int,
+	levels int,
+	levelDistance float64,
+) *MultiResolutionTsdfLayer {
+	l := new(MultiResolutionTsdfLayer)
+	for level := 0; level < levels; level++ {
+		l.Levels = append(l.Levels, NewTsdfLayer(
+			voxelSize*float64(int(1)<<level),
+			voxelsPerSide>>level,
+		))
+	}
+	l.LevelDistance = levelDistance
+	l.BlockSize = l.Levels[0].BlockSize
+	l.BlockSizeInv = l.Levels[0].BlockSizeInv
+	l.owners = make(map[IndexType]int)
+	return l
+}
+
+// GetLevel returns the level owning the block at the given index and whether the block exists.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) GetLevel(blockIndex IndexType) (int, bool) {
+	l.RLock()
+	defer l.RUnlock()
+	level, ok := l.owners[blockIndex]
+	return level, ok
+}
+
+// GetBlockCount returns the number of blocks owned by every level.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) GetBlockCount() int {
+	l.RLock()
+	defer l.RUnlock()
+	return len(l.owners)
+}
+
+// Clear removes all blocks from every level.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) Clear() {
+	l.Lock()
+	defer l.Unlock()
+	for _, layer := range l.Levels {
+		layer.Clear()
+	}
+	l.owners = make(map[IndexType]int)
+}
+
+// setAllUpdated flags every block of every level as updated.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) setAllUpdated() {
+	for _, layer := range l.Levels {
+		layer.setAllUpdated()
+	}
+}
+
+// getUpdatedBlocks returns the updated blocks keyed by block index, each from its owning level.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) getUpdatedBlocks() map[IndexType]*TsdfBlock {
+	l.RLock()
+	defer l.RUnlock()
+	updatedBlocks := make(map[IndexType]*TsdfBlock)
+	for level, layer := range l.Levels {
+		for index, block := range layer.getUpdatedBlocks() {
+			if owner, ok := l.owners[index]; ok && owner == level {
+				updatedBlocks[index] = block
+			}
+		}
+	}
+	return updatedBlocks
+}
+
+// getBlockIfExists returns a pointer to the block at the given index from its owning level if it exists.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) getBlockIfExists(blockIndex IndexType) *TsdfBlock {
+	level, ok := l.GetLevel(blockIndex)
+	if !ok {
+		return nil
+	}
+	return l.Levels[level].getBlockIfExists(blockIndex)
+}
+
+// levelForDistance returns the level of a block observed at the given distance from the sensor.
+func (l *MultiResolutionTsdfLayer) levelForDistance(distance float64) int {
+	level := 0
+	for threshold := l.LevelDistance; distance >= threshold && level < len(l.Levels)-1; threshold *= 2 {
+		level++
+	}
+	return level
+}
+
+// claimBlock assigns a block to the given level unless a finer level owns it.
+// A block owned by a coarser level is refined to the given level.
+// Returns the level owning the block.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) claimBlock(blockIndex IndexType, level int) int {
+	l.Lock()
+	defer l.Unlock()
+	owner, ok := l.owners[blockIndex]
+	if !ok {
+		l.owners[blockIndex] = level
+		return level
+	}
+	if owner > level {
+		l.refineBlock(blockIndex, owner, level)
+		return level
+	}
+	return owner
+}
+
+// refineBlock moves a block from a coarser to a finer level.
+// Every observed coarse voxel is copied into the finer voxels it covers.
+// The neighbors meshing across the faces of the block are flagged as updated
+// since the samples they read from the block change.
+// The caller must hold the write lock.
+func (l *MultiResolutionTsdfLayer) refineBlock(blockIndex IndexType, from int, to int) {
+	fine := l.Levels[to].getBlockByIndex(blockIndex)
+	if coarse := l.Levels[from].getBlockIfExists(blockIndex); coarse != nil {
+		refineVoxels(coarse, fine, from-to)
+		l.Levels[from].removeBlock(blockIndex)
+	}
+	fine.setUpdated()
+	l.owners[blockIndex] = to
+
+	for dx := -1; dx <= 0; dx++ {
+		for dy := -1; dy <= 0; dy++ {
+			for dz := -1; dz <= 0; dz++ {
+				neighborIndex := addIndex(blockIndex, IndexType{dx, dy, dz})
+				if owner, ok := l.owners[neighborIndex]; ok && neighborIndex != blockIndex {
+					if neighbor := l.Levels[owner].getBlockIfExists(neighborIndex); neighbor != nil {
+						neighbor.setUpdated()
+					}
+				}
+			}
+		}
+	}
+}
+
+// refineVoxels copies every observed voxel of a coarse block into the voxels of the block
+// shift levels finer covering it.
+func refineVoxels(coarse *TsdfBlock, fine *TsdfBlock, shift int) {
+	scale := 1 << shift
+	for index, voxel := range coarse.getVoxels() {
+		for dx := 0; dx < scale; dx++ {
+			for dy := 0; dy < scale; dy++ {
+				for dz := 0; dz < scale; dz++ {
+					fine.setVoxel(IndexType{
+						index[0]<<shift + dx,
+						index[1]<<shift + dy,
+						index[2]<<shift + dz,
+					}, voxel)
+				}
+			}
+		}
+	}
+}
+
+// Flatten returns a copy of the layer at the resolution of level 0.
+// The blocks of coarser levels are refined to level 0 like refineBlock does.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) Flatten() *TsdfLayer {
+	l.RLock()
+	defer l.RUnlock()
+	layer := NewTsdfLayer(l.Levels[0].VoxelSize, l.Levels[0].VoxelsPerSide)
+	for index, owner := range l.owners {
+		if block := l.Levels[owner].getBlockIfExists(index); block != nil {
+			refineVoxels(block, layer.getBlockByIndex(index), owner)
+		}
+	}
+	return layer
+}
+
+// MergeTsdfLayer moves the blocks of a layer with the voxel size of level 0 into level 0.
+// Every merged block is owned by level 0 and replaces the block of a coarser level at its index.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) MergeTsdfLayer(layer *TsdfLayer) error {
+	if layer.VoxelSize != l.Levels[0].VoxelSize || layer.VoxelsPerSide != l.Levels[0].VoxelsPerSide {
+		return fmt.Errorf(
+			"layer mismatch: layer has voxel size %f and %d voxels per side",
+			layer.VoxelSize,
+			layer.VoxelsPerSide,
+		)
+	}
+	l.Lock()
+	defer l.Unlock()
+	for index, block := range layer.getBlocks() {
+		if owner, ok := l.owners[index]; ok && owner != 0 {
+			l.Levels[owner].removeBlock(index)
+		}
+		block.setUpdated()
+		l.Levels[0].blocks.set(index, block)
+		l.owners[index] = 0
+	}
+	return nil
+}
+
+// pruneBlocks hands the blocks allocated by the integration of a level to their owner.
+// Blocks without an owner are claimed by the finest level that allocated them.
+// Blocks owned by another level, e.g. crossed by the rays of points hitting that level,
+// are resampled into the block of the owner with the config of its level and removed.
+// Claims of points which did not allocate their block, e.g. beyond the max range, are released.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) pruneBlocks(configs []*Config) {
+	l.Lock()
+	defer l.Unlock()
+	for level, layer := range l.Levels {
+		for index := range layer.getBlocks() {
+			if _, ok := l.owners[index]; !ok {
+				l.owners[index] = level
+			}
+		}
+	}
+	for level, layer := range l.Levels {
+		for index, block := range layer.getBlocks() {
+			owner := l.owners[index]
+			if owner == level {
+				continue
+			}
+			updates := resampleVoxelUpdates(block, level-owner, configs[owner].truncationDistance)
+			if len(updates) > 0 {
+				ownerBlock := l.Levels[owner].getBlockByIndex(index)
+				ownerBlock.applyBlockUpdates(configs[owner], updates)
+				ownerBlock.setUpdated()
+			}
+			layer.removeBlock(index)
+		}
+	}
+	for index, owner := range l.owners {
+		if l.Levels[owner].getBlockIfExists(index) == nil {
+			delete(l.owners, index)
+		}
+	}
+}
+
+// resampleVoxelUpdates returns the observed voxels of a block as voxel updates of the block
+// at the same index shift levels finer, or coarser for a negative shift.
+// A coarse voxel updates every finer voxel it covers, the finer voxels covered by
+// a coarse voxel are averaged by weight into a single update.
+// Updates at the truncation distance of the target level observe free space.
+func resampleVoxelUpdates(block *TsdfBlock, shift int, truncationDistance float64) blockUpdates {
+	updates := make(blockUpdates)
+	voxels := block.getVoxels()
+	if shift >= 0 {
+		scale := 1 << shift
+		for index, voxel := range voxels {
+			update := voxelUpdate{
+				sdf:    voxel.getDistance(),
+				weight: voxel.getWeight(),
+				color:  voxel.getColor(),
+			}
+			for dx := 0; dx < scale; dx++ {
+				for dy := 0; dy < scale; dy++ {
+					for dz := 0; dz < scale; dz++ {
+						fineIndex := IndexType{
+							index[0]<<shift + dx,
+							index[1]<<shift + dy,
+							index[2]<<shift + dz,
+						}
+						updates[fineIndex] = append(updates[fineIndex], update)
+					}
+				}
+			}
+		}
+	} else {
+		counts := make(map[IndexType]int)
+		for index, voxel := range voxels {
+			coarseIndex := coarsenIndex(index, -shift)
+			update := voxelUpdate{
+				sdf:    voxel.getDistance(),
+				weight: voxel.getWeight(),
+				color:  voxel.getColor(),
+			}
+			if previous, ok := updates[coarseIndex]; ok {
+				weight := previous[0].weight + update.weight
+				update.sdf = (previous[0].sdf*previous[0].weight + update.sdf*update.weight) / weight
+				update.color = blendTwoColors(previous[0].color, previous[0].weight, update.color, update.weight)
+				update.weight = weight
+			}
+			updates[coarseIndex] = []voxelUpdate{update}
+			counts[coarseIndex]++
+		}
+		// The weight of a coarse voxel is the mean weight of the finer voxels it covers.
+		for index, count := range counts {
+			updates[index][0].weight /= float64(count)
+		}
+	}
+	for _, voxelUpdates := range updates {
+		for k := range voxelUpdates {
+			voxelUpdates[k].free = voxelUpdates[k].sdf >= float64(float32(truncationDistance))
+		}
+	}
+	return updates
+}
+
+// refineComplexBlocks refines the updated blocks whose surface complexity exceeds the threshold by one level.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) refineComplexBlocks(threshold float64) {
+	for index, block := range l.getUpdatedBlocks() {
+		level, ok := l.GetLevel(index)
+		if !ok || level == 0 {
+			continue
+		}
+		if surfaceComplexity(block) > threshold {
+			l.claimBlock(index, level-1)
+		}
+	}
+}
+
+// surfaceComplexity returns how much the surface normals of a block disagree,
+// from 0 for a plane to 1 for normals pointing in every direction.
+// The normals are the TSDF gradients of the voxels within one voxel of the surface.
+func surfaceComplexity(block *TsdfBlock) float64 {
+	voxels := block.getVoxels()
+
+	sum := Point{}
+	count := 0
+	for index, voxel := range voxels {
+		if math.Abs(voxel.getDistance()) > block.VoxelSize {
+			continue
+		}
+		gradient := Point{}
+		valid := true
+		for k := 0; k < 3; k++ {
+			previousIndex, nextIndex := index, index
+			previousIndex[k]--
+			nextIndex[k]++
+			previous, okPrevious := voxels[previousIndex]
+			next, okNext := voxels[nextIndex]
+			if !okPrevious || !okNext {
+				valid = false
+				break
+			}
+			gradient[k] = next.getDistance() - previous.getDistance()
+		}
+		if !valid || gradient.Length() == 0 {
+			continue
+		}
+		sum.Add(gradient.Normalize())
+		count++
+	}
+
+	if count < kMinComplexityNormals {
+		return 0
+	}
+	return 1 - sum.Length()/float64(count)
+}
+
+// coarsenIndex returns the index at the given level of the voxel containing a level 0 voxel.
+func coarsenIndex(index IndexType, level int) IndexType {
+	return IndexType{index[0] >> level, index[1] >> level, index[2] >> level}
+}
+
+// sampleVoxel returns the voxel at a level 0 global voxel index as seen by the level owning its block.
+// Coarse voxels are trilinearly interpolated at the center of the level 0 voxel when the eight
+// surrounding coarse voxels are observed, otherwise the containing coarse voxel is returned.
+// A position is sampled the same way by every block using it, which keeps the mesh watertight
+// across resolution boundaries.
+// Thread-safe.
+func (l *MultiResolutionTsdfLayer) sampleVoxel(globalVoxelIndex IndexType) (TsdfVoxel, bool) {
+	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, l.Levels[0].VoxelsPerSideInv)
+	level, ok := l.GetLevel(blockIndex)
+	if !ok {
+		return TsdfVoxel{}, false
+	}
+	layer := l.Levels[level]
+	if level == 0 {
+		_, voxel, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(layer, globalVoxelIndex)
+		return voxel, ok
+	}
+
+	_, containing, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(
+		layer,
+		coarsenIndex(globalVoxelIndex, level),
+	)
+	if !ok {
+		return TsdfVoxel{}, false
+	}
+
+	// Center of the level 0 voxel in units of coarse voxels, relative to coarse voxel centers.
+	scaleInv := 1.0 / float64(int(1)<<level)
+	center := [3]float64{}
+	baseIndex := IndexType{}
+	for k := 0; k < 3; k++ {
+		center[k] = (float64(globalVoxelIndex[k])+0.5)*scaleInv - 0.5
+		baseIndex[k] = int(math.Floor(center[k]))
+		center[k] -= float64(baseIndex[k])
+	}
+
+	distance := 0.0
+	weight := math.Inf(1)
+	for j := 0; j < 8; j++ {
+		offset := IndexType{j & 1, j >> 1 & 1, j >> 2 & 1}
+		_, voxel, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(layer, addIndex(baseIndex, offset))
+		if !ok {
+			return containing, true
+		}
+		factor := 1.0
+		for k := 0; k < 3; k++ {
+			if offset[k] == 1 {
+				factor *= center[k]
+			} else {
+				factor *= 1 - center[k]
+			}
+		}
+		distance += factor * voxel.getDistance()
+		weight = math.Min(weight, voxel.getWeight())
+	}
+
+	// The color is the one of the containing voxel.
+	voxel := containing
+	voxel.setDistance(distance)
+	voxel.setWeight(weight)
+	return voxel, true
+}
+
+// MultiResolutionTsdfIntegrator integrates point clouds into a MultiResolutionTsdfLayer.
+// Every point is integrated by a FastTsdfIntegrator at the level owning the block it hits.
+type MultiResolutionTsdfIntegrator struct {
+	Config      *Config
+	Layer       *MultiResolutionTsdfLayer
+	integrators []*FastTsdfIntegrator
+	// Serializes IntegratePointCloud calls since pruning moves blocks between levels.
+	sync.Mutex
+}
+
+// NewMultiResolutionTsdfIntegrator creates a new MultiResolutionTsdfIntegrator.
+// The truncation distance of every level scales with its voxel size.
+func NewMultiResolutionTsdfIntegrator(
+	config *Config,
+	layer *MultiResolutionTsdfLayer,
+) *MultiResolutionTsdfIntegrator {
+	i := &MultiResolutionTsdfIntegrator{
+		Config: config,
+		Layer:  layer,
+	}
+	for _, levelLayer := range layer.Levels {
+		levelConfig := *config
+		levelConfig.VoxelSize = levelLayer.VoxelSize
+		i.integrators = append(i.integrators, NewFastTsdfIntegrator(&levelConfig, levelLayer))
+	}
+	return i
+}
+
+// IntegratePointCloud integrates a point cloud into the levels of the layer.
+// Thread-safe.
+func (i *MultiResolutionTsdfIntegrator) IntegratePointCloud(
+	pose Transform,
+	pointCloud PointCloud,
+) {
+	i.Lock()
+	defer i.Unlock()
+	defer TimeTrack(i.Config.GetLogger(), time.Now(), "Integrate Multi-Resolution", "points", len(pointCloud.Points))
+
+	for level, levelCloud := range i.splitPointCloudByLevel(pose, pointCloud) {
+		if len(levelCloud.Points) > 0 {
+			i.integrators[level].IntegratePointCloud(pose, levelCloud)
+		}
+	}
+	i.Layer.pruneBlocks(i.levelConfigs())
+
+	if i.Config.ResolutionComplexityThreshold > 0 {
+		i.Layer.refineComplexBlocks(i.Config.ResolutionComplexityThreshold)
+	}
+}
+
+// levelConfigs returns the config of the integrator of every level.
+func (i *MultiResolutionTsdfIntegrator) levelConfigs() []*Config {
+	configs := make([]*Config, len(i.integrators))
+	for level, integrator := range i.integrators {
+		configs[level] = integrator.Config
+	}
+	return configs
+}
+
+// DecayWeights decays the voxel weights of every level by the elapsed time.
+func (i *MultiResolutionTsdfIntegrator) DecayWeights(elapsed time.Duration) {
+	i.Lock()
+	defer i.Unlock()
+	for _, integrator := range i.integrators {
+		integrator.DecayWeights(elapsed)
+	}
+}
+
+// splitPointCloudByLevel returns one point cloud per level holding the points
+// hitting the blocks owned by that level.
+func (i *MultiResolutionTsdfIntegrator) splitPointCloudByLevel(
+	pose Transform,
+	pointCloud PointCloud,
+) []PointCloud {
+	levelClouds := make([]PointCloud, len(i.Layer.Levels))
+	for level := range levelClouds {
+		levelClouds[level].Stamp = pointCloud.Stamp
+	}
+	blockLevels := make(map[IndexType]int)
+	for j, point := range pointCloud.Points {
+		pointG := pose.transformPoint(point)
+		blockIndex := getBlockIndexFromCoordinates(pointG, i.Layer.BlockSizeInv)
+		level := i.Layer.levelForDistance(point.Length())
+		if blockLevel, ok := blockLevels[blockIndex]; !ok || level < blockLevel {
+			blockLevels[blockIndex] = i.Layer.claimBlock(blockIndex, level)
+		}
+		level = blockLevels[blockIndex]
+		levelClouds[level].Points = append(levelClouds[level].Points, point)
+		levelClouds[level].Colors = append(levelClouds[level].Colors, pointCloud.Colors[j])
+	}
+	return levelClouds
+}
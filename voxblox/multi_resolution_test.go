@@ -0,0 +1,215 @@
+package voxblox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiResolutionTsdfLayer(t *testing.T) {
+	layer := NewMultiResolutionTsdfLayer(0.1, 16, 3, 2.0)
+	assert.Len(t, layer.Levels, 3)
+	assert.Equal(t, 16, layer.Levels[0].VoxelsPerSide)
+	assert.Equal(t, 4, layer.Levels[2].VoxelsPerSide)
+	assert.InEpsilon(t, 0.4, layer.Levels[2].VoxelSize, kEpsilon)
+	assert.InEpsilon(t, layer.BlockSize, layer.Levels[2].BlockSize, kEpsilon)
+
+	assert.Equal(t, 0, layer.levelForDistance(1.9))
+	assert.Equal(t, 1, layer.levelForDistance(2.0))
+	assert.Equal(t, 2, layer.levelForDistance(4.0))
+	assert.Equal(t, 2, layer.levelForDistance(100.0))
+}
+
+func TestMultiResolutionRefineBlock(t *testing.T) {
+	layer := NewMultiResolutionTsdfLayer(0.1, 16, 2, 2.0)
+	blockIndex := IndexType{1, 0, 0}
+
+	assert.Equal(t, 1, layer.claimBlock(blockIndex, 1))
+	setTestVoxel(layer.Levels[1], IndexType{9, 2, 3}, 0.25, 2, ColorRed)
+
+	// Coarser observations keep the finer level.
+	assert.Equal(t, 0, layer.claimBlock(blockIndex, 0))
+	assert.Equal(t, 0, layer.claimBlock(blockIndex, 1))
+	level, ok := layer.GetLevel(blockIndex)
+	assert.True(t, ok)
+	assert.Equal(t, 0, level)
+	assert.Equal(t, 1, layer.GetBlockCount())
+	assert.Nil(t, layer.Levels[1].getBlockIfExists(blockIndex))
+
+	// The coarse voxel covers 2x2x2 fine voxels.
+	block := layer.Levels[0].getBlockIfExists(blockIndex)
+	assert.Len(t, block.getVoxels(), 8)
+	voxel := getTestVoxel(t, layer.Levels[0], IndexType{19, 5, 7})
+	assert.Equal(t, 0.25, voxel.getDistance())
+	assert.Equal(t, 2.0, voxel.getWeight())
+	assert.Equal(t, ColorRed, voxel.getColor())
+}
+
+func TestMultiResolutionPruneBlocks(t *testing.T) {
+	layer := NewMultiResolutionTsdfLayer(0.1, 16, 2, 2.0)
+	layer.claimBlock(IndexType{0, 0, 0}, 1)
+	layer.Levels[0].getBlockByIndex(IndexType{0, 0, 0})
+	layer.Levels[1].getBlockByIndex(IndexType{0, 0, 0})
+	layer.Levels[0].getBlockByIndex(IndexType{1, 0, 0})
+	layer.Levels[1].getBlockByIndex(IndexType{1, 0, 0})
+
+	levelConfig := config
+	integrator := NewMultiResolutionTsdfIntegrator(&levelConfig, layer)
+	layer.pruneBlocks(integrator.levelConfigs())
+	assert.Equal(t, 2, layer.GetBlockCount())
+	level, _ := layer.GetLevel(IndexType{0, 0, 0})
+	assert.Equal(t, 1, level)
+	level, _ = layer.GetLevel(IndexType{1, 0, 0})
+	assert.Equal(t, 0, level)
+	assert.Equal(t, 1, layer.Levels[0].GetBlockCount())
+	assert.Equal(t, 1, layer.Levels[1].GetBlockCount())
+}
+
+func TestMultiResolutionPruneBlocksResample(t *testing.T) {
+	layer := NewMultiResolutionTsdfLayer(0.1, 16, 2, 2.0)
+	levelConfig := config
+	levelConfig.DynamicMapping = true
+	levelConfig.FreeSpaceDecay = 0.5
+	integrator := NewMultiResolutionTsdfIntegrator(&levelConfig, layer)
+	configs := integrator.levelConfigs()
+	layer.claimBlock(IndexType{0, 0, 0}, 0)
+	layer.claimBlock(IndexType{1, 0, 0}, 1)
+
+	// A coarse ray carving the fine block clears the occupied fine voxel.
+	setTestVoxel(layer.Levels[0], IndexType{2, 4, 6}, 0, 10, ColorRed)
+	setTestVoxel(layer.Levels[1], IndexType{1, 2, 3}, configs[1].truncationDistance, 1, ColorRed)
+	layer.Levels[0].getBlockByIndex(IndexType{0, 0, 0}).setNotUpdated()
+
+	// The fine voxels covered by a coarse voxel are averaged into it.
+	setTestVoxel(layer.Levels[1], IndexType{9, 2, 3}, 0.1, 2, ColorRed)
+	setTestVoxel(layer.Levels[0], IndexType{18, 4, 6}, 0.1, 2, ColorRed)
+	setTestVoxel(layer.Levels[0], IndexType{19, 5, 7}, 0.3, 2, ColorRed)
+
+	layer.pruneBlocks(configs)
+	assert.Equal(t, 2, layer.GetBlockCount())
+	assert.Equal(t, 1, layer.Levels[0].GetBlockCount())
+	assert.Equal(t, 1, layer.Levels[1].GetBlockCount())
+	assert.True(t, layer.Levels[0].getBlockIfExists(IndexType{0, 0, 0}).getUpdated())
+
+	voxel := getTestVoxel(t, layer.Levels[0], IndexType{2, 4, 6})
+	assert.InEpsilon(t, 6.0, voxel.getWeight(), kEpsilon)
+	assert.InEpsilon(t, configs[1].truncationDistance/6, voxel.getDistance(), 1e-6)
+	voxel = getTestVoxel(t, layer.Levels[0], IndexType{3, 5, 7})
+	assert.InEpsilon(t, configs[0].truncationDistance, voxel.getDistance(), 1e-6)
+
+	voxel = getTestVoxel(t, layer.Levels[1], IndexType{9, 2, 3})
+	assert.InEpsilon(t, 4.0, voxel.getWeight(), kEpsilon)
+	assert.InEpsilon(t, 0.15, voxel.getDistance(), 1e-6)
+}
+
+func TestMultiResolutionFlattenMerge(t *testing.T) {
+	layer := NewMultiResolutionTsdfLayer(0.1, 16, 2, 2.0)
+	layer.claimBlock(IndexType{0, 0, 0}, 0)
+	setTestVoxel(layer.Levels[0], IndexType{1, 2, 3}, 0.5, 1, ColorRed)
+	layer.claimBlock(IndexType{1, 0, 0}, 1)
+	setTestVoxel(layer.Levels[1], IndexType{9, 2, 3}, 0.25, 2, ColorRed)
+
+	// Coarse voxels cover 2x2x2 voxels of the flattened layer.
+	flattened := layer.Flatten()
+	assert.Equal(t, 0.1, flattened.VoxelSize)
+	assert.Equal(t, 2, flattened.GetBlockCount())
+	assert.Len(t, flattened.getBlockIfExists(IndexType{1, 0, 0}).getVoxels(), 8)
+	voxel := getTestVoxel(t, flattened, IndexType{19, 5, 7})
+	assert.Equal(t, 0.25, voxel.getDistance())
+	voxel = getTestVoxel(t, flattened, IndexType{1, 2, 3})
+	assert.Equal(t, 0.5, voxel.getDistance())
+
+	// Merged blocks are owned by level 0 and replace coarse blocks.
+	merged := NewMultiResolutionTsdfLayer(0.1, 16, 2, 2.0)
+	merged.claimBlock(IndexType{1, 0, 0}, 1)
+	merged.Levels[1].getBlockByIndex(IndexType{1, 0, 0})
+	assert.NoError(t, merged.MergeTsdfLayer(flattened))
+	assert.Equal(t, 2, merged.GetBlockCount())
+	assert.Equal(t, 0, merged.Levels[1].GetBlockCount())
+	assert.Len(t, merged.getUpdatedBlocks(), 2)
+	level, ok := merged.GetLevel(IndexType{1, 0, 0})
+	assert.True(t, ok)
+	assert.Equal(t, 0, level)
+
+	// Layers with other voxels are rejected.
+	assert.Error(t, merged.MergeTsdfLayer(NewTsdfLayer(0.2, 8)))
+}
+
+func TestMultiResolutionSampleVoxel(t *testing.T) {
+	layer := NewMultiResolutionTsdfLayer(0.1, 16, 2, 2.0)
+	plane := func(point Point) float64 { return point[0] - 0.8 }
+	layer.claimBlock(IndexType{0, 0, 0}, 1)
+	getTestSdfLayer(layer.Levels[1], plane)
+
+	// Interpolated between the coarse voxel centers.
+	voxel, ok := layer.sampleVoxel(IndexType{8, 8, 8})
+	assert.True(t, ok)
+	assert.InDelta(t, 0.05, voxel.getDistance(), kEpsilon)
+	assert.Equal(t, 1.0, voxel.getWeight())
+
+	// The first fine voxel has no coarse voxel below it, the containing voxel is used.
+	voxel, ok = layer.sampleVoxel(IndexType{0, 8, 8})
+	assert.True(t, ok)
+	assert.InDelta(t, -0.7, voxel.getDistance(), kEpsilon)
+
+	_, ok = layer.sampleVoxel(IndexType{16, 8, 8})
+	assert.False(t, ok)
+}
+
+func TestSurfaceComplexity(t *testing.T) {
+	layer := NewMultiResolutionTsdfLayer(0.1, 16, 1, 2.0)
+	layer.claimBlock(IndexType{0, 0, 0}, 0)
+	getTestSdfLayer(layer.Levels[0], func(center Point) float64 {
+		return center[2] - 0.8
+	})
+	layer.claimBlock(IndexType{1, 0, 0}, 0)
+	getTestSdfLayer(layer.Levels[0], sphereSdf(Point{2.4, 0.8, 0.8}, 0.3), IndexType{1, 0, 0})
+
+	assert.InDelta(t, 0.0, surfaceComplexity(layer.Levels[0].getBlockIfExists(IndexType{0, 0, 0})), kEpsilon)
+	assert.Greater(t, surfaceComplexity(layer.Levels[0].getBlockIfExists(IndexType{1, 0, 0})), 0.5)
+}
+
+func TestMultiResolutionMeshWatertight(t *testing.T) {
+	// A sphere straddling a fine and a coarse block must be meshed as a closed surface.
+	layer := NewMultiResolutionTsdfLayer(0.1, 16, 2, 2.0)
+	sphere := sphereSdf(Point{1.6, 0.8, 0.8}, 0.55)
+	layer.claimBlock(IndexType{0, 0, 0}, 0)
+	getTestSdfLayer(layer.Levels[0], sphere)
+	layer.claimBlock(IndexType{1, 0, 0}, 1)
+	getTestSdfLayer(layer.Levels[1], sphere, IndexType{1, 0, 0})
+
+	meshLayer := NewMeshLayer(layer.Levels[0])
+	meshIntegrator := NewMultiResolutionMeshIntegrator(config, layer, meshLayer)
+	meshIntegrator.Integrate()
+	assert.Empty(t, layer.getUpdatedBlocks())
+
+	mesh := GetGlobalMesh(meshLayer)
+	assert.Greater(t, len(mesh.Triangles), 100)
+	assertClosedMesh(t, mesh.Vertices, mesh.Triangles)
+}
+
+func TestMultiResolutionIntegrator(t *testing.T) {
+	layer := NewMultiResolutionTsdfLayer(config.VoxelSize, config.VoxelsPerSide, 2, 5.0)
+	levelConfig := config
+	integrator := NewMultiResolutionTsdfIntegrator(&levelConfig, layer)
+	integrator.IntegratePointCloud(poses[0], getTransformedPointCloud(poses[0]))
+
+	// Every allocated block belongs to its level.
+	levelBlocks := make([]int, len(layer.Levels))
+	for level, levelLayer := range layer.Levels {
+		for index := range levelLayer.getBlocks() {
+			owner, ok := layer.GetLevel(index)
+			assert.True(t, ok)
+			assert.Equal(t, level, owner)
+			levelBlocks[level]++
+		}
+	}
+	assert.Greater(t, levelBlocks[0], 0)
+	assert.Greater(t, levelBlocks[1], 0)
+	assert.Equal(t, layer.GetBlockCount(), levelBlocks[0]+levelBlocks[1])
+
+	meshLayer := NewMeshLayer(layer.Levels[0])
+	meshIntegrator := NewMultiResolutionMeshIntegrator(config, layer, meshLayer)
+	meshIntegrator.Integrate()
+	assert.Greater(t, meshLayer.getBlockCount(), 0)
+}
@@ -0,0 +1,271 @@
+package voxblox
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// observationVoxel is the observation history of a voxel: the time of the last observation
+// in Unix seconds, 0 if unknown, and the number of observations.
+type observationVoxel struct {
+	lastObserved uint32
+	count        uint32
+}
+
+// observe records an observation at the given time in Unix seconds.
+// Older stamps only increase the observation count.
+func (v *observationVoxel) observe(stamp uint32) {
+	if v.count < math.MaxUint32 {
+		v.count++
+	}
+	if stamp > v.lastObserved {
+		v.lastObserved = stamp
+	}
+}
+
+// isObserved returns true if the voxel has been observed at least once.
+func (v *observationVoxel) isObserved() bool {
+	return v.count > 0
+}
+
+// observationBlock contains a dense array of observation voxels.
+// The block lock protects the voxels.
+type observationBlock struct {
+	Index IndexType
+	sync.RWMutex
+	voxels voxelArray[observationVoxel]
+}
+
+// ObservationLayer holds the observation history of the voxels of a TSDF Layer,
+// the time of their last observation and how often they were observed.
+// The observations are recorded by the TSDF integrators alongside the TSDF updates.
+type ObservationLayer struct {
+	VoxelSize        float64
+	VoxelSizeInv     float64
+	VoxelsPerSide    int
+	VoxelsPerSideInv float64
+	blocks           *blockHash[*observationBlock]
+	// latestObserved is the newest point cloud stamp in Unix seconds.
+	latestObserved uint32
+}
+
+// NewObservationLayer creates a new ObservationLayer on the voxel grid of the TSDF Layer.
+func NewObservationLayer(tsdfLayer *TsdfLayer) *ObservationLayer {
+	return &ObservationLayer{
+		VoxelSize:        tsdfLayer.VoxelSize,
+		VoxelSizeInv:     tsdfLayer.VoxelSizeInv,
+		VoxelsPerSide:    tsdfLayer.VoxelsPerSide,
+		VoxelsPerSideInv: tsdfLayer.VoxelsPerSideInv,
+		blocks:           newBlockHash[*observationBlock](),
+	}
+}
+
+// Clear removes all blocks from the layer.
+// Thread-safe.
+func (l *ObservationLayer) Clear() {
+	l.blocks.clear()
+	atomic.StoreUint32(&l.latestObserved, 0)
+}
+
+// GetBlockCount returns the number of blocks allocated in the map
+// Thread-safe.
+func (l *ObservationLayer) GetBlockCount() int {
+	return l.blocks.len()
+}
+
+// observeCloud records the stamp of a point cloud integrated into the layer.
+// Thread-safe.
+func (l *ObservationLayer) observeCloud(stamp time.Time) {
+	seconds := stampToSeconds(stamp)
+	for {
+		latest := atomic.LoadUint32(&l.latestObserved)
+		if seconds <= latest || atomic.CompareAndSwapUint32(&l.latestObserved, latest, seconds) {
+			return
+		}
+	}
+}
+
+// GetLatestObserved returns the stamp of the newest point cloud integrated into the layer,
+// the zero time if no cloud had a stamp.
+// Thread-safe.
+func (l *ObservationLayer) GetLatestObserved() time.Time {
+	return secondsToStamp(atomic.LoadUint32(&l.latestObserved))
+}
+
+// getBlockByIndex returns the block at the given index, allocating it if needed.
+// Thread-safe.
+func (l *ObservationLayer) getBlockByIndex(blockIndex IndexType) *observationBlock {
+	return l.blocks.getOrCreate(blockIndex, func() *observationBlock {
+		return &observationBlock{Index: blockIndex, voxels: newVoxelArray[observationVoxel](l.VoxelsPerSide)}
+	})
+}
+
+// observeIndexedVoxelUpdates records an observation at the stamp for every update
+// of the block at the given index, locking the block once.
+// Thread-safe.
+func (l *ObservationLayer) observeIndexedVoxelUpdates(
+	blockIndex IndexType,
+	stamp uint32,
+	updates []indexedVoxelUpdate,
+) {
+	block := l.getBlockByIndex(blockIndex)
+	block.Lock()
+	defer block.Unlock()
+	for _, u := range updates {
+		block.voxels.at(u.voxelIndex).observe(stamp)
+	}
+}
+
+// observeBlockUpdates records an observation at the stamp for every update
+// of the block at the given index, locking the block once.
+// Thread-safe.
+func (l *ObservationLayer) observeBlockUpdates(blockIndex IndexType, stamp uint32, updates blockUpdates) {
+	block := l.getBlockByIndex(blockIndex)
+	block.Lock()
+	defer block.Unlock()
+	for voxelIndex, voxelUpdates := range updates {
+		voxel := block.voxels.at(voxelIndex)
+		for range voxelUpdates {
+			voxel.observe(stamp)
+		}
+	}
+}
+
+// getObservation returns the observation history of the voxel at the global voxel index.
+// Returns false if the voxel has not been observed.
+// Thread-safe.
+func (l *ObservationLayer) getObservation(globalVoxelIndex IndexType) (observationVoxel, bool) {
+	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, l.VoxelsPerSideInv)
+	block, ok := l.blocks.get(blockIndex)
+	if !ok {
+		return observationVoxel{}, false
+	}
+	voxelIndex := getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, l.VoxelsPerSide)
+
+	block.RLock()
+	defer block.RUnlock()
+	voxel := *block.voxels.at(voxelIndex)
+	return voxel, voxel.isObserved()
+}
+
+// VoxelObservation is the observation history of a voxel.
+type VoxelObservation struct {
+	// LastObserved is the stamp of the last point cloud observing the voxel, the zero time if unknown.
+	LastObserved time.Time
+	// Count is the number of rays which updated the voxel.
+	Count uint32
+}
+
+// GetObservation returns the observation history of the voxel containing the point.
+// Returns false if the voxel has not been observed.
+// Thread-safe.
+func (l *ObservationLayer) GetObservation(point Point) (VoxelObservation, bool) {
+	voxel, ok := l.getObservation(getGridIndexFromPoint(point, l.VoxelSizeInv))
+	if !ok {
+		return VoxelObservation{}, false
+	}
+	return VoxelObservation{
+		LastObserved: secondsToStamp(voxel.lastObserved),
+		Count:        voxel.count,
+	}, true
+}
+
+// GetVoxelAges returns the centers of the voxels of the TSDF Layer with a weight of at least minWeight
+// and the time between their last observation and now.
+// Voxels without a known observation time are skipped.
+// Thread-safe.
+func (l *ObservationLayer) GetVoxelAges(
+	tsdfLayer *TsdfLayer,
+	now time.Time,
+	minWeight float64,
+) ([]Point, []time.Duration) {
+	var points []Point
+	var ages []time.Duration
+	for blockIndex, block := range tsdfLayer.getBlocks() {
+		observations, ok := l.blocks.get(blockIndex)
+		if !ok {
+			continue
+		}
+		voxels := block.getVoxels()
+		observations.RLock()
+		for voxelIndex, voxel := range voxels {
+			lastObserved := observations.voxels.at(voxelIndex).lastObserved
+			if voxel.getWeight() < minWeight || lastObserved == 0 {
+				continue
+			}
+			points = append(points, block.computeCoordinatesFromVoxelIndex(voxelIndex))
+			ages = append(ages, now.Sub(secondsToStamp(lastObserved)))
+		}
+		observations.RUnlock()
+	}
+	return points, ages
+}
+
+// PruneVoxels resets the voxels of the TSDF Layer not observed for longer than maxAge before now,
+// so they are removed from the mesh on the next update.
+// Voxels without a known observation time are kept.
+// Returns the number of pruned voxels.
+// Thread-safe.
+func (l *ObservationLayer) PruneVoxels(tsdfLayer *TsdfLayer, now time.Time, maxAge time.Duration) int {
+	before := stampToSeconds(now.Add(-maxAge))
+	if before == 0 {
+		return 0
+	}
+	pruned := 0
+	for blockIndex, block := range tsdfLayer.getBlocks() {
+		observations, ok := l.blocks.get(blockIndex)
+		if !ok {
+			continue
+		}
+		if blockPruned := observations.prune(block, before); blockPruned > 0 {
+			pruned += blockPruned
+			block.setUpdated()
+		}
+	}
+	return pruned
+}
+
+// prune resets the observed voxels of the TSDF block and their observations
+// if they were last observed before the given Unix seconds.
+// Voxels without a known observation time are kept.
+// Returns the number of reset voxels.
+// Thread-safe.
+func (b *observationBlock) prune(block *TsdfBlock, before uint32) int {
+	b.Lock()
+	defer b.Unlock()
+	block.Lock()
+	defer block.Unlock()
+	pruned := 0
+	for j := range b.voxels.voxels {
+		observation := &b.voxels.voxels[j]
+		voxel := &block.voxels.voxels[j]
+		if voxel.isObserved() && observation.lastObserved != 0 && observation.lastObserved < before {
+			*voxel = TsdfVoxel{}
+			*observation = observationVoxel{}
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// stampToSeconds converts a point cloud stamp to the Unix seconds stored in observation voxels.
+// The zero time and times before the epoch map to 0, which means unknown.
+func stampToSeconds(stamp time.Time) uint32 {
+	if stamp.IsZero() || stamp.Unix() <= 0 {
+		return 0
+	}
+	if stamp.Unix() > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(stamp.Unix())
+}
+
+// secondsToStamp converts the Unix seconds stored in observation voxels to a time.
+func secondsToStamp(seconds uint32) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0)
+}
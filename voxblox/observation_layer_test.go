@@ -0,0 +1,50 @@
+package voxblox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObservationLayer(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	observationLayer := NewObservationLayer(tsdfLayer)
+	block := setTestVoxel(tsdfLayer, IndexType{1, 2, 3}, 0.01, 1.0, ColorRed)
+	setTestVoxel(tsdfLayer, IndexType{1, 2, 4}, 0.01, 1.0, ColorRed)
+	updates := []indexedVoxelUpdate{{voxelIndex: IndexType{1, 2, 3}}, {voxelIndex: IndexType{1, 2, 4}}}
+	observationLayer.observeIndexedVoxelUpdates(block.Index, 1000, updates[:1])
+	observationLayer.observeBlockUpdates(block.Index, 900, blockUpdates{IndexType{1, 2, 3}: {{}}})
+	// Voxels without a stamp have no age and are never pruned.
+	observationLayer.observeIndexedVoxelUpdates(block.Index, 0, updates[1:])
+
+	observation, ok := observationLayer.GetObservation(Point{0.15, 0.25, 0.35})
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1000, 0), observation.LastObserved)
+	assert.Equal(t, uint32(2), observation.Count)
+	observation, ok = observationLayer.GetObservation(Point{0.15, 0.25, 0.45})
+	assert.True(t, ok)
+	assert.True(t, observation.LastObserved.IsZero())
+	_, ok = observationLayer.GetObservation(Point{0.15, 0.25, 0.55})
+	assert.False(t, ok)
+
+	points, ages := observationLayer.GetVoxelAges(tsdfLayer, time.Unix(1010, 0), 0.5)
+	assert.Equal(t, []Point{block.computeCoordinatesFromVoxelIndex(IndexType{1, 2, 3})}, points)
+	assert.Equal(t, []time.Duration{10 * time.Second}, ages)
+
+	block.setNotUpdated()
+	assert.Equal(t, 0, observationLayer.PruneVoxels(tsdfLayer, time.Unix(1010, 0), 10*time.Second))
+	assert.False(t, block.getUpdated())
+	assert.Equal(t, 1, observationLayer.PruneVoxels(tsdfLayer, time.Unix(1011, 0), 10*time.Second))
+	assert.True(t, block.getUpdated())
+	assert.Len(t, block.getVoxels(), 1)
+	_, ok = observationLayer.GetObservation(Point{0.15, 0.25, 0.35})
+	assert.False(t, ok)
+
+	observationLayer.observeCloud(time.Unix(1000, 0))
+	observationLayer.observeCloud(time.Unix(900, 0))
+	assert.Equal(t, time.Unix(1000, 0), observationLayer.GetLatestObserved())
+	observationLayer.Clear()
+	assert.Equal(t, 0, observationLayer.GetBlockCount())
+	assert.True(t, observationLayer.GetLatestObserved().IsZero())
+}
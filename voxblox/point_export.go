@@ -0,0 +1,409 @@
+package voxblox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// kLasScale is the resolution in meters of the coordinates written by WriteLas.
+const kLasScale = 1e-4
+
+// ExportCloud is a point cloud extracted from a TSDF Layer for export.
+// Normals are unit vectors, zero where the TSDF gradient is unknown.
+// Distances and Weights are only set for voxel clouds.
+type ExportCloud struct {
+	Points    []Point
+	Colors    []Color
+	Normals   []Point
+	Distances []float64
+	Weights   []float64
+}
+
+// Len returns the number of points in the cloud.
+func (c *ExportCloud) Len() int {
+	return len(c.Points)
+}
+
+// hasVoxels returns whether the cloud has voxel distances and weights.
+func (c *ExportCloud) hasVoxels() bool {
+	return c.Distances != nil && c.Weights != nil
+}
+
+// getExportBlocks returns the blocks of the layer ordered by block index.
+func getExportBlocks(layer *TsdfLayer) []*TsdfBlock {
+	blocks := make([]*TsdfBlock, 0, layer.GetBlockCount())
+	for _, block := range layer.getBlocks() {
+		blocks = append(blocks, block)
+	}
+	slices.SortFunc(blocks, func(a, b *TsdfBlock) int {
+		return slices.Compare(a.Index[:], b.Index[:])
+	})
+	return blocks
+}
+
+// forEachExportVoxel calls fn with the global voxel index, center and voxel of every observed voxel of the
+// layer with a weight of at least minWeight, ordered by block and voxel index.
+func forEachExportVoxel(
+	layer *TsdfLayer,
+	minWeight float64,
+	fn func(globalVoxelIndex IndexType, center Point, voxel *TsdfVoxel),
+) {
+	vps := layer.VoxelsPerSide
+	for _, block := range getExportBlocks(layer) {
+		voxels := block.getVoxels()
+		blockOrigin := IndexType{block.Index[0] * vps, block.Index[1] * vps, block.Index[2] * vps}
+		voxelIndex := IndexType{}
+		for voxelIndex[2] = 0; voxelIndex[2] < vps; voxelIndex[2]++ {
+			for voxelIndex[1] = 0; voxelIndex[1] < vps; voxelIndex[1]++ {
+				for voxelIndex[0] = 0; voxelIndex[0] < vps; voxelIndex[0]++ {
+					voxel, ok := voxels[voxelIndex]
+					if !ok || voxel.getWeight() < minWeight {
+						continue
+					}
+					fn(
+						addIndex(blockOrigin, voxelIndex),
+						block.computeCoordinatesFromVoxelIndex(voxelIndex),
+						&voxel,
+					)
+				}
+			}
+		}
+	}
+}
+
+// getNormal returns the normalized TSDF gradient of the voxel, pointing away from the surface into free space.
+func getNormal(layer *TsdfLayer, globalVoxelIndex IndexType) (Point, bool) {
+	gradient, ok := getGradient(layer, globalVoxelIndex)
+	if !ok || gradient.Length() == 0 {
+		return Point{}, false
+	}
+	return *gradient.Normalize(), true
+}
+
+// GetSurfaceCloud returns the zero crossings of the TSDF between neighboring voxels with a weight of at least
+// minWeight, the points marching cubes puts the mesh vertices on.
+// Every point takes the color of the closer voxel and the interpolated TSDF gradient as normal.
+// Thread-safe.
+func GetSurfaceCloud(layer *TsdfLayer, minWeight float64) ExportCloud {
+	var cloud ExportCloud
+	forEachExportVoxel(layer, minWeight, func(globalVoxelIndex IndexType, center Point, voxel *TsdfVoxel) {
+		for k := 0; k < 3; k++ {
+			neighborIndex := globalVoxelIndex
+			neighborIndex[k]++
+			_, neighbor, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(layer, neighborIndex)
+			if !ok || neighbor.getWeight() < minWeight {
+				continue
+			}
+			distance, neighborDistance := voxel.getDistance(), neighbor.getDistance()
+			if (distance < 0) == (neighborDistance < 0) {
+				continue
+			}
+
+			neighborCenter := center
+			neighborCenter[k] += layer.VoxelSize
+			point := interpolateEdge(center, neighborCenter, distance, neighborDistance)
+			t := (point[k] - center[k]) * layer.VoxelSizeInv
+			color := voxel.getColor()
+			if t > 0.5 {
+				color = neighbor.getColor()
+			}
+
+			normal, ok := getNormal(layer, globalVoxelIndex)
+			neighborNormal, neighborOk := getNormal(layer, neighborIndex)
+			switch {
+			case ok && neighborOk:
+				normal = vec3Lerp(normal, neighborNormal, t)
+				if normal.Length() > 0 {
+					normal.Normalize()
+				}
+			case neighborOk:
+				normal = neighborNormal
+			}
+
+			cloud.Points = append(cloud.Points, *point)
+			cloud.Colors = append(cloud.Colors, color)
+			cloud.Normals = append(cloud.Normals, normal)
+		}
+	})
+	return cloud
+}
+
+// vec3Lerp returns the linear interpolation from a to b at t.
+func vec3Lerp(a, b Point, t float64) Point {
+	return Point{
+		a[0] + t*(b[0]-a[0]),
+		a[1] + t*(b[1]-a[1]),
+		a[2] + t*(b[2]-a[2]),
+	}
+}
+
+// GetVoxelCloud returns the centers of the voxels with an absolute distance below maxDistance and a weight
+// of at least minWeight, with their colors, TSDF gradient normals, distances and weights.
+// Thread-safe.
+func GetVoxelCloud(layer *TsdfLayer, maxDistance, minWeight float64) ExportCloud {
+	cloud := ExportCloud{Distances: []float64{}, Weights: []float64{}}
+	forEachExportVoxel(layer, minWeight, func(globalVoxelIndex IndexType, center Point, voxel *TsdfVoxel) {
+		if math.Abs(voxel.getDistance()) >= maxDistance {
+			return
+		}
+		normal, _ := getNormal(layer, globalVoxelIndex)
+		cloud.Points = append(cloud.Points, center)
+		cloud.Colors = append(cloud.Colors, voxel.getColor())
+		cloud.Normals = append(cloud.Normals, normal)
+		cloud.Distances = append(cloud.Distances, voxel.getDistance())
+		cloud.Weights = append(cloud.Weights, voxel.getWeight())
+	})
+	return cloud
+}
+
+// WriteExportCloud writes the cloud in the format of the file extension: .ply, .pcd (binary) or .las.
+func WriteExportCloud(cloud *ExportCloud, fileName string) error {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".ply":
+		return WritePly(cloud, fileName)
+	case ".pcd":
+		return WritePcd(cloud, fileName, false)
+	case ".las":
+		return WriteLas(cloud, fileName)
+	default:
+		return fmt.Errorf("unknown point cloud format of %s", fileName)
+	}
+}
+
+// writeExportFile creates the file and writes it through a buffered writer.
+func writeExportFile(fileName string, write func(w *bufio.Writer) error) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	if err := write(w); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// WritePly writes the cloud to a binary little endian PLY file.
+// Points have float coordinates and normals and uchar colors, voxel clouds also float distances and weights.
+func WritePly(cloud *ExportCloud, fileName string) error {
+	return writeExportFile(fileName, func(w *bufio.Writer) error {
+		fmt.Fprintf(w, "ply\nformat binary_little_endian 1.0\ncomment go-voxblox\n")
+		fmt.Fprintf(w, "element vertex %d\n", cloud.Len())
+		for _, property := range []string{"x", "y", "z", "nx", "ny", "nz"} {
+			fmt.Fprintf(w, "property float %s\n", property)
+		}
+		for _, property := range []string{"red", "green", "blue"} {
+			fmt.Fprintf(w, "property uchar %s\n", property)
+		}
+		if cloud.hasVoxels() {
+			fmt.Fprintf(w, "property float distance\nproperty float weight\n")
+		}
+		fmt.Fprintf(w, "end_header\n")
+
+		for j := range cloud.Points {
+			record := struct {
+				Point  [3]float32
+				Normal [3]float32
+				Color  Color
+			}{
+				Point:  toFloat32(cloud.Points[j]),
+				Normal: toFloat32(cloud.Normals[j]),
+				Color:  cloud.Colors[j],
+			}
+			if err := binary.Write(w, binary.LittleEndian, &record); err != nil {
+				return err
+			}
+			if cloud.hasVoxels() {
+				voxel := [2]float32{float32(cloud.Distances[j]), float32(cloud.Weights[j])}
+				if err := binary.Write(w, binary.LittleEndian, &voxel); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// toFloat32 returns the point with float32 coordinates.
+func toFloat32(point Point) [3]float32 {
+	return [3]float32{float32(point[0]), float32(point[1]), float32(point[2])}
+}
+
+// WritePcd writes the cloud to a PCD v0.7 file with ASCII or binary data.
+// Colors are packed into the float rgb field as by PCL, written as an unsigned integer in ASCII files.
+// Voxel clouds also have distance and weight fields.
+func WritePcd(cloud *ExportCloud, fileName string, ascii bool) error {
+	fields := []string{"x", "y", "z", "rgb", "normal_x", "normal_y", "normal_z"}
+	if cloud.hasVoxels() {
+		fields = append(fields, "distance", "weight")
+	}
+	repeat := func(value string) string {
+		return strings.TrimSpace(strings.Repeat(value+" ", len(fields)))
+	}
+	data := "binary"
+	if ascii {
+		data = "ascii"
+	}
+
+	return writeExportFile(fileName, func(w *bufio.Writer) error {
+		fmt.Fprintf(w, "# .PCD v0.7 - Point Cloud Data file format\n")
+		fmt.Fprintf(w, "VERSION 0.7\n")
+		fmt.Fprintf(w, "FIELDS %s\n", strings.Join(fields, " "))
+		fmt.Fprintf(w, "SIZE %s\n", repeat("4"))
+		fmt.Fprintf(w, "TYPE %s\n", repeat("F"))
+		fmt.Fprintf(w, "COUNT %s\n", repeat("1"))
+		fmt.Fprintf(w, "WIDTH %d\nHEIGHT 1\n", cloud.Len())
+		fmt.Fprintf(w, "VIEWPOINT 0 0 0 1 0 0 0\n")
+		fmt.Fprintf(w, "POINTS %d\n", cloud.Len())
+		fmt.Fprintf(w, "DATA %s\n", data)
+
+		for j := range cloud.Points {
+			color := cloud.Colors[j]
+			rgb := uint32(color[0])<<16 | uint32(color[1])<<8 | uint32(color[2])
+			point, normal := toFloat32(cloud.Points[j]), toFloat32(cloud.Normals[j])
+			values := []float32{
+				point[0], point[1], point[2],
+				math.Float32frombits(rgb),
+				normal[0], normal[1], normal[2],
+			}
+			if cloud.hasVoxels() {
+				values = append(values, float32(cloud.Distances[j]), float32(cloud.Weights[j]))
+			}
+
+			if !ascii {
+				if err := binary.Write(w, binary.LittleEndian, values); err != nil {
+					return err
+				}
+				continue
+			}
+			for i, value := range values {
+				if i > 0 {
+					w.WriteByte(' ')
+				}
+				if fields[i] == "rgb" {
+					fmt.Fprintf(w, "%d", rgb)
+				} else {
+					fmt.Fprintf(w, "%g", value)
+				}
+			}
+			if _, err := w.WriteString("\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lasHeader is the public header block of a LAS 1.2 file.
+type lasHeader struct {
+	FileSignature          [4]byte
+	FileSourceID           uint16
+	GlobalEncoding         uint16
+	ProjectID              [16]byte
+	VersionMajor           uint8
+	VersionMinor           uint8
+	SystemIdentifier       [32]byte
+	GeneratingSoftware     [32]byte
+	CreationDayOfYear      uint16
+	CreationYear           uint16
+	HeaderSize             uint16
+	OffsetToPointData      uint32
+	VariableLengthRecords  uint32
+	PointDataFormat        uint8
+	PointDataRecordLength  uint16
+	PointRecords           uint32
+	PointRecordsByReturn   [5]uint32
+	Scale                  [3]float64
+	Offset                 [3]float64
+	MaxX, MinX, MaxY, MinY float64
+	MaxZ, MinZ             float64
+}
+
+// lasPoint is a point data record of format 2, with RGB colors.
+type lasPoint struct {
+	X, Y, Z        int32
+	Intensity      uint16
+	ReturnFlags    uint8
+	Classification uint8
+	ScanAngleRank  int8
+	UserData       uint8
+	PointSourceID  uint16
+	Red            uint16
+	Green          uint16
+	Blue           uint16
+}
+
+// WriteLas writes the cloud to a LAS 1.2 file of point data format 2 with coordinates at 0.1 mm resolution.
+// LAS has no fields for normals, distances and weights, which are dropped.
+func WriteLas(cloud *ExportCloud, fileName string) error {
+	header := lasHeader{
+		FileSignature:        [4]byte{'L', 'A', 'S', 'F'},
+		VersionMajor:         1,
+		VersionMinor:         2,
+		CreationDayOfYear:    uint16(time.Now().YearDay()),
+		CreationYear:         uint16(time.Now().Year()),
+		PointDataFormat:      2,
+		PointRecords:         uint32(cloud.Len()),
+		PointRecordsByReturn: [5]uint32{uint32(cloud.Len())},
+		Scale:                [3]float64{kLasScale, kLasScale, kLasScale},
+	}
+	copy(header.SystemIdentifier[:], "go-voxblox")
+	copy(header.GeneratingSoftware[:], "go-voxblox")
+	header.HeaderSize = uint16(binary.Size(header))
+	header.OffsetToPointData = uint32(header.HeaderSize)
+	header.PointDataRecordLength = uint16(binary.Size(lasPoint{}))
+
+	if cloud.Len() > 0 {
+		minBound, maxBound := cloud.Points[0], cloud.Points[0]
+		for _, point := range cloud.Points {
+			for k := 0; k < 3; k++ {
+				minBound[k] = math.Min(minBound[k], point[k])
+				maxBound[k] = math.Max(maxBound[k], point[k])
+			}
+		}
+		header.Offset = minBound
+		header.MinX, header.MinY, header.MinZ = minBound[0], minBound[1], minBound[2]
+		header.MaxX, header.MaxY, header.MaxZ = maxBound[0], maxBound[1], maxBound[2]
+		for k := 0; k < 3; k++ {
+			if (maxBound[k]-minBound[k])/kLasScale > math.MaxInt32 {
+				return fmt.Errorf("point cloud too large for LAS coordinates")
+			}
+		}
+	}
+
+	return writeExportFile(fileName, func(w *bufio.Writer) error {
+		if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+			return err
+		}
+		for j, point := range cloud.Points {
+			color := cloud.Colors[j]
+			record := lasPoint{
+				X: int32(math.Round((point[0] - header.Offset[0]) / kLasScale)),
+				Y: int32(math.Round((point[1] - header.Offset[1]) / kLasScale)),
+				Z: int32(math.Round((point[2] - header.Offset[2]) / kLasScale)),
+				// Single return.
+				ReturnFlags: 1 | 1<<3,
+				Red:         uint16(color[0]) * 257,
+				Green:       uint16(color[1]) * 257,
+				Blue:        uint16(color[2]) * 257,
+			}
+			if err := binary.Write(w, binary.LittleEndian, &record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,141 @@
+package voxblox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ungerik/go3d/float64/vec3"
+)
+
+func TestGetSurfaceCloud(t *testing.T) {
+	layer := getTestSdfLayer(
+		NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide),
+		wallSdf,
+		IndexType{0, 0, 0},
+		IndexType{0, 1, 0},
+	)
+	cloud := GetSurfaceCloud(layer, config.MinWeight)
+	assert.NotEmpty(t, cloud.Points)
+	assert.Len(t, cloud.Colors, cloud.Len())
+	assert.Len(t, cloud.Normals, cloud.Len())
+	assert.Nil(t, cloud.Distances)
+
+	// The points lie on the wall, the normals point away from it.
+	normals := 0
+	for j, point := range cloud.Points {
+		assert.InDelta(t, 0.93, vec3.Dot(&wallNormal, &point), 1e-6)
+		assert.Equal(t, ColorRed, cloud.Colors[j])
+		if cloud.Normals[j].Length() > 0 {
+			assert.InDelta(t, 1, vec3.Dot(&wallNormal, &cloud.Normals[j]), 1e-6)
+			normals++
+		}
+	}
+	assert.Greater(t, normals, cloud.Len()/2)
+}
+
+func TestGetVoxelCloud(t *testing.T) {
+	layer := getTestSdfLayer(
+		NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide),
+		wallSdf,
+		IndexType{0, 0, 0},
+		IndexType{0, 1, 0},
+	)
+	cloud := GetVoxelCloud(layer, config.VoxelSize, config.MinWeight)
+	assert.Len(t, cloud.Distances, cloud.Len())
+	assert.Len(t, cloud.Weights, cloud.Len())
+
+	expected := 0
+	for _, block := range layer.getBlocks() {
+		for _, voxel := range block.getVoxels() {
+			if math.Abs(voxel.getDistance()) < config.VoxelSize {
+				expected++
+			}
+		}
+	}
+	assert.Equal(t, expected, cloud.Len())
+	for j := range cloud.Points {
+		assert.Less(t, math.Abs(cloud.Distances[j]), config.VoxelSize)
+		assert.Equal(t, 1.0, cloud.Weights[j])
+	}
+}
+
+func TestWriteExportCloud(t *testing.T) {
+	layer := getTestSdfLayer(
+		NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide),
+		wallSdf,
+		IndexType{0, 0, 0},
+		IndexType{0, 1, 0},
+	)
+	cloud := GetVoxelCloud(layer, config.VoxelSize, config.MinWeight)
+	folder := t.TempDir()
+
+	// PLY
+	fileName := filepath.Join(folder, "voxels.ply")
+	assert.NoError(t, WriteExportCloud(&cloud, fileName))
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+	header, body, ok := bytes.Cut(data, []byte("end_header\n"))
+	assert.True(t, ok)
+	assert.Contains(t, string(header), "element vertex "+strconv.Itoa(cloud.Len())+"\n")
+	assert.Contains(t, string(header), "property float distance\n")
+	assert.Len(t, body, cloud.Len()*(6*4+3+2*4))
+
+	// Binary PCD
+	fileName = filepath.Join(folder, "voxels.pcd")
+	assert.NoError(t, WriteExportCloud(&cloud, fileName))
+	data, err = os.ReadFile(fileName)
+	assert.NoError(t, err)
+	header, body, ok = bytes.Cut(data, []byte("DATA binary\n"))
+	assert.True(t, ok)
+	assert.Contains(t, string(header), "FIELDS x y z rgb normal_x normal_y normal_z distance weight\n")
+	assert.Len(t, body, cloud.Len()*9*4)
+	var values [9]float32
+	assert.NoError(t, binary.Read(bytes.NewReader(body), binary.LittleEndian, &values))
+	assert.InDelta(t, cloud.Points[0][0], values[0], 1e-6)
+	assert.Equal(t, uint32(0xff0000), math.Float32bits(values[3]))
+
+	// ASCII PCD
+	fileName = filepath.Join(folder, "voxels_ascii.pcd")
+	assert.NoError(t, WritePcd(&cloud, fileName, true))
+	data, err = os.ReadFile(fileName)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Equal(t, "DATA ascii", lines[10])
+	assert.Len(t, lines, 11+cloud.Len())
+	fields := strings.Fields(lines[11])
+	assert.Len(t, fields, 9)
+	x, err := strconv.ParseFloat(fields[0], 64)
+	assert.NoError(t, err)
+	assert.InDelta(t, cloud.Points[0][0], x, 1e-6)
+	assert.Equal(t, "16711680", fields[3])
+
+	// LAS
+	fileName = filepath.Join(folder, "voxels.las")
+	assert.NoError(t, WriteExportCloud(&cloud, fileName))
+	data, err = os.ReadFile(fileName)
+	assert.NoError(t, err)
+	reader := bytes.NewReader(data)
+	var lasFileHeader lasHeader
+	assert.NoError(t, binary.Read(reader, binary.LittleEndian, &lasFileHeader))
+	assert.Equal(t, [4]byte{'L', 'A', 'S', 'F'}, lasFileHeader.FileSignature)
+	assert.Equal(t, uint16(227), lasFileHeader.HeaderSize)
+	assert.Equal(t, uint16(26), lasFileHeader.PointDataRecordLength)
+	assert.Equal(t, uint32(cloud.Len()), lasFileHeader.PointRecords)
+	assert.Len(t, data, 227+26*cloud.Len())
+	var point lasPoint
+	assert.NoError(t, binary.Read(reader, binary.LittleEndian, &point))
+	for k, coordinate := range [3]int32{point.X, point.Y, point.Z} {
+		decoded := float64(coordinate)*lasFileHeader.Scale[k] + lasFileHeader.Offset[k]
+		assert.InDelta(t, cloud.Points[0][k], decoded, kLasScale)
+	}
+	assert.Equal(t, uint16(0xffff), point.Red)
+
+	assert.Error(t, WriteExportCloud(&cloud, filepath.Join(folder, "voxels.xyz")))
+}
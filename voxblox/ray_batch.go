@@ -0,0 +1,205 @@
+package voxblox
+
+import "math"
+
+// kRayBatchSize is the number of rays an integration worker casts per batch.
+const kRayBatchSize = 1024
+
+// RayBatch casts many rays through the voxel grid.
+// The traversal state of the rays is kept in struct-of-arrays buffers which are
+// reused across batches, so casting does not allocate per ray.
+// The voxels traversed by a ray are the same as the ones of a RayCaster.
+type RayBatch struct {
+	lengthInSteps []int
+	// Voxel index of the start of every ray.
+	indexX, indexY, indexZ []int
+	// Step direction of every ray along each axis.
+	stepX, stepY, stepZ []int
+	// Ray parameter at the next voxel boundary along each axis.
+	tNextX, tNextY, tNextZ []float64
+	// Ray parameter increment between voxel boundaries along each axis.
+	tStepX, tStepY, tStepZ []float64
+}
+
+// NewRayBatch creates a new RayBatch with room for capacity rays.
+func NewRayBatch(capacity int) *RayBatch {
+	return &RayBatch{
+		lengthInSteps: make([]int, 0, capacity),
+		indexX:        make([]int, 0, capacity),
+		indexY:        make([]int, 0, capacity),
+		indexZ:        make([]int, 0, capacity),
+		stepX:         make([]int, 0, capacity),
+		stepY:         make([]int, 0, capacity),
+		stepZ:         make([]int, 0, capacity),
+		tNextX:        make([]float64, 0, capacity),
+		tNextY:        make([]float64, 0, capacity),
+		tNextZ:        make([]float64, 0, capacity),
+		tStepX:        make([]float64, 0, capacity),
+		tStepY:        make([]float64, 0, capacity),
+		tStepZ:        make([]float64, 0, capacity),
+	}
+}
+
+// Len returns the number of rays in the batch.
+func (b *RayBatch) Len() int {
+	return len(b.lengthInSteps)
+}
+
+// Reset removes all rays from the batch and keeps the buffers.
+func (b *RayBatch) Reset() {
+	b.lengthInSteps = b.lengthInSteps[:0]
+	b.indexX, b.indexY, b.indexZ = b.indexX[:0], b.indexY[:0], b.indexZ[:0]
+	b.stepX, b.stepY, b.stepZ = b.stepX[:0], b.stepY[:0], b.stepZ[:0]
+	b.tNextX, b.tNextY, b.tNextZ = b.tNextX[:0], b.tNextY[:0], b.tNextZ[:0]
+	b.tStepX, b.tStepY, b.tStepZ = b.tStepX[:0], b.tStepY[:0], b.tStepZ[:0]
+}
+
+// Add appends a ray to the batch, taking the same arguments as NewRayCaster.
+// The length of clearing rays is updated.
+func (b *RayBatch) Add(
+	ray *Ray,
+	voxelSizeInv float64,
+	truncationDistance float64,
+	maxRange float64,
+	allowCarving bool,
+	castFromOrigin bool,
+) {
+	startScaled, endScaled := computeScaledRayEndpoints(
+		ray,
+		voxelSizeInv,
+		truncationDistance,
+		maxRange,
+		allowCarving,
+	)
+	if !castFromOrigin {
+		startScaled, endScaled = endScaled, startScaled
+	}
+
+	indexX, stepX, tNextX, tStepX, lengthX := setUpRayAxis(startScaled[0], endScaled[0])
+	indexY, stepY, tNextY, tStepY, lengthY := setUpRayAxis(startScaled[1], endScaled[1])
+	indexZ, stepZ, tNextZ, tStepZ, lengthZ := setUpRayAxis(startScaled[2], endScaled[2])
+
+	b.lengthInSteps = append(b.lengthInSteps, lengthX+lengthY+lengthZ)
+	b.indexX, b.indexY, b.indexZ = append(b.indexX, indexX), append(b.indexY, indexY), append(b.indexZ, indexZ)
+	b.stepX, b.stepY, b.stepZ = append(b.stepX, stepX), append(b.stepY, stepY), append(b.stepZ, stepZ)
+	b.tNextX, b.tNextY, b.tNextZ = append(b.tNextX, tNextX), append(b.tNextY, tNextY), append(b.tNextZ, tNextZ)
+	b.tStepX, b.tStepY, b.tStepZ = append(b.tStepX, tStepX), append(b.tStepY, tStepY), append(b.tStepZ, tStepZ)
+}
+
+// setUpRayAxis returns the traversal state of a scaled ray along one axis:
+// the start voxel index, the step direction, the ray parameter at the next voxel boundary,
+// the ray parameter between boundaries and the number of steps.
+// Matches RayCaster.SetUp.
+func setUpRayAxis(start float64, end float64) (int, int, float64, float64, int) {
+	index := int(math.Floor(start + kEpsilon))
+	endIndex := int(math.Floor(end + kEpsilon))
+	rayScaled := end - start
+	step := sgn(rayScaled)
+	distanceToBoundary := math.Max(0, float64(step)) - (start - float64(index))
+	return index,
+		step,
+		distanceToBoundary / rayScaled,
+		float64(step) / rayScaled,
+		int(math.Abs(float64(endIndex - index)))
+}
+
+// Cast traverses every ray of the batch in order and calls visit for each voxel,
+// starting at the start of the ray.
+// Returning false from visit stops the traversal of the current ray.
+func (b *RayBatch) Cast(visit func(ray int, globalVoxelIndex IndexType) bool) {
+	for r := range b.lengthInSteps {
+		// Keep the traversal state of the ray in registers.
+		x, y, z := b.indexX[r], b.indexY[r], b.indexZ[r]
+		stepX, stepY, stepZ := b.stepX[r], b.stepY[r], b.stepZ[r]
+		tNextX, tNextY, tNextZ := b.tNextX[r], b.tNextY[r], b.tNextZ[r]
+		tStepX, tStepY, tStepZ := b.tStepX[r], b.tStepY[r], b.tStepZ[r]
+
+		for step := 0; step < b.lengthInSteps[r]; step++ {
+			if !visit(r, IndexType{x, y, z}) {
+				break
+			}
+			// Advance along the axis with the closest boundary, the lowest axis on ties like PointMinCoeff.
+			tMin := math.Inf(1)
+			axis := 0
+			if tNextX < tMin {
+				tMin = tNextX
+			}
+			if tNextY < tMin {
+				tMin = tNextY
+				axis = 1
+			}
+			if tNextZ < tMin {
+				axis = 2
+			}
+			switch axis {
+			case 0:
+				x += stepX
+				tNextX += tStepX
+			case 1:
+				y += stepY
+				tNextY += tStepY
+			default:
+				z += stepZ
+				tNextZ += tStepZ
+			}
+		}
+	}
+}
+
+// rayBatchCaster casts the rays of an integration worker in batches of kRayBatchSize.
+// The rays are cast once the batch is full or on flush.
+type rayBatchCaster struct {
+	batch          *RayBatch
+	rays           []Ray
+	pointIndexes   []int
+	layer          *TsdfLayer
+	config         *Config
+	castFromOrigin bool
+	visit          func(ray *Ray, pointIndex int, globalVoxelIndex IndexType) bool
+}
+
+// newRayBatchCaster creates a rayBatchCaster calling visit for every voxel traversed by a ray.
+// Returning false from visit stops the traversal of the ray.
+func newRayBatchCaster(
+	layer *TsdfLayer,
+	config *Config,
+	castFromOrigin bool,
+	visit func(ray *Ray, pointIndex int, globalVoxelIndex IndexType) bool,
+) *rayBatchCaster {
+	return &rayBatchCaster{
+		batch:          NewRayBatch(kRayBatchSize),
+		rays:           make([]Ray, 0, kRayBatchSize),
+		pointIndexes:   make([]int, 0, kRayBatchSize),
+		layer:          layer,
+		config:         config,
+		castFromOrigin: castFromOrigin,
+		visit:          visit,
+	}
+}
+
+// add queues the ray of the point at the given index.
+func (c *rayBatchCaster) add(ray *Ray, pointIndex int) {
+	c.batch.Add(
+		ray,
+		c.layer.VoxelSizeInv,
+		c.config.truncationDistance,
+		c.config.MaxRange,
+		c.config.AllowCarving,
+		c.castFromOrigin,
+	)
+	c.rays = append(c.rays, *ray)
+	c.pointIndexes = append(c.pointIndexes, pointIndex)
+	if c.batch.Len() == kRayBatchSize {
+		c.flush()
+	}
+}
+
+// flush casts the queued rays.
+func (c *rayBatchCaster) flush() {
+	c.batch.Cast(func(ray int, globalVoxelIndex IndexType) bool {
+		return c.visit(&c.rays[ray], c.pointIndexes[ray], globalVoxelIndex)
+	})
+	c.batch.Reset()
+	c.rays = c.rays[:0]
+	c.pointIndexes = c.pointIndexes[:0]
+}
@@ -0,0 +1,134 @@
+package voxblox
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestRays returns the valid rays of the simulated point cloud at the first pose.
+func getTestRays() []Ray {
+	pointCloud := getTransformedPointCloud(poses[0])
+	rays := make([]Ray, 0, len(pointCloud.Points))
+	for _, point := range pointCloud.Points {
+		var ray Ray
+		if validateRay(&ray, point, config.MinRange, config.MaxRange, config.AllowClearing) {
+			ray.Origin = poses[0].Translation
+			ray.Point = poses[0].transformPoint(point)
+			rays = append(rays, ray)
+		}
+	}
+	return rays
+}
+
+func TestRayBatchMatchesRayCaster(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	randomPoint := func() Point {
+		return Point{random.Float64()*10 - 5, random.Float64()*10 - 5, random.Float64()*4 - 2}
+	}
+
+	batch := NewRayBatch(16)
+	for _, castFromOrigin := range []bool{true, false} {
+		for _, allowCarving := range []bool{true, false} {
+			batch.Reset()
+			var expected [][]IndexType
+			for r := 0; r < 100; r++ {
+				ray := Ray{Origin: randomPoint(), Point: randomPoint(), Clearing: r%4 == 0}
+				batchRay := ray
+
+				rayCaster := NewRayCaster(&ray, 10.0, 0.4, 5.0, allowCarving, castFromOrigin)
+				var indexes []IndexType
+				var globalVoxelIndex IndexType
+				for rayCaster.nextRayIndex(&globalVoxelIndex) {
+					indexes = append(indexes, globalVoxelIndex)
+				}
+				expected = append(expected, indexes)
+
+				batch.Add(&batchRay, 10.0, 0.4, 5.0, allowCarving, castFromOrigin)
+				assert.Equal(t, ray.Length, batchRay.Length)
+			}
+			assert.Equal(t, 100, batch.Len())
+
+			actual := make([][]IndexType, batch.Len())
+			batch.Cast(func(ray int, globalVoxelIndex IndexType) bool {
+				actual[ray] = append(actual[ray], globalVoxelIndex)
+				return true
+			})
+			assert.Equal(t, expected, actual)
+		}
+	}
+}
+
+func TestRayBatchStopRay(t *testing.T) {
+	batch := NewRayBatch(2)
+	for r := 0; r < 2; r++ {
+		ray := Ray{Origin: Point{0, 0, 0}, Point: Point{1, 0, 0}}
+		batch.Add(&ray, 10.0, 0.4, 5.0, true, true)
+	}
+
+	visits := make([]int, batch.Len())
+	batch.Cast(func(ray int, globalVoxelIndex IndexType) bool {
+		visits[ray]++
+		return ray == 1 || visits[ray] < 3
+	})
+	assert.Equal(t, []int{3, 14}, visits)
+}
+
+func TestRayBatchAllocations(t *testing.T) {
+	rays := getTestRays()[:kRayBatchSize]
+	batch := NewRayBatch(kRayBatchSize)
+	visit := func(ray int, globalVoxelIndex IndexType) bool { return true }
+
+	allocations := testing.AllocsPerRun(10, func() {
+		batch.Reset()
+		for r := range rays {
+			batch.Add(&rays[r], 10.0, 0.4, 5.0, true, true)
+		}
+		batch.Cast(visit)
+	})
+	assert.Equal(t, 0.0, allocations)
+}
+
+func BenchmarkRayCasting(b *testing.B) {
+	rays := getTestRays()
+
+	b.Run("RayCaster", func(b *testing.B) {
+		b.ReportAllocs()
+		voxels := 0
+		for n := 0; n < b.N; n++ {
+			for r := range rays {
+				rayCaster := NewRayCaster(&rays[r], 10.0, 0.4, 5.0, true, true)
+				var globalVoxelIndex IndexType
+				for rayCaster.nextRayIndex(&globalVoxelIndex) {
+					voxels++
+				}
+			}
+		}
+		b.ReportMetric(float64(len(rays)*b.N)/b.Elapsed().Seconds(), "rays/s")
+		b.ReportMetric(float64(voxels)/b.Elapsed().Seconds(), "voxels/s")
+	})
+
+	b.Run("RayBatch", func(b *testing.B) {
+		b.ReportAllocs()
+		voxels := 0
+		batch := NewRayBatch(kRayBatchSize)
+		visit := func(ray int, globalVoxelIndex IndexType) bool {
+			voxels++
+			return true
+		}
+		for n := 0; n < b.N; n++ {
+			for r := range rays {
+				batch.Add(&rays[r], 10.0, 0.4, 5.0, true, true)
+				if batch.Len() == kRayBatchSize {
+					batch.Cast(visit)
+					batch.Reset()
+				}
+			}
+			batch.Cast(visit)
+			batch.Reset()
+		}
+		b.ReportMetric(float64(len(rays)*b.N)/b.Elapsed().Seconds(), "rays/s")
+		b.ReportMetric(float64(voxels)/b.Elapsed().Seconds(), "voxels/s")
+	})
+}
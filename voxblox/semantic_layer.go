@@ -0,0 +1,56 @@
+package voxblox
+
+import "math"
+
+// Label is a semantic class label.
+type Label = uint16
+
+// LabelUnknown marks unlabeled points, which are not fused into the semantic layer.
+const LabelUnknown Label = 0
+
+// SemanticVoxel is the label vote histogram of a voxel.
+type SemanticVoxel = voteVoxel[Label]
+
+// SemanticLayer holds the label votes of the voxels of a TSDF Layer.
+// Labels are fused by the TSDF integrators alongside the TSDF updates.
+type SemanticLayer struct {
+	voteLayer[Label]
+}
+
+// NewSemanticLayer creates a new SemanticLayer on the voxel grid of the TSDF Layer.
+func NewSemanticLayer(tsdfLayer *TsdfLayer) *SemanticLayer {
+	return &SemanticLayer{newVoteLayer[Label](tsdfLayer)}
+}
+
+// GetLabel returns the majority label of the voxel containing the point.
+// Returns false if the voxel has no votes.
+// Thread-safe.
+func (l *SemanticLayer) GetLabel(point Point) (Label, bool) {
+	return l.getLabel(getGridIndexFromPoint(point, l.VoxelSizeInv))
+}
+
+// labelColor returns a distinct color for every label or instance, white for the unknown zero label.
+// Hues are spread by the golden angle so neighboring labels get different colors.
+func labelColor[L voteLabel](label L) Color {
+	if label == 0 {
+		return ColorWhite
+	}
+	hue := math.Mod(float64(label)*137.508, 360) / 60
+	x := 1 - math.Abs(math.Mod(hue, 2)-1)
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	case 4:
+		r, b = x, 1
+	default:
+		r, b = 1, x
+	}
+	return Color{uint8(math.Round(255 * r)), uint8(math.Round(255 * g)), uint8(math.Round(255 * b))}
+}
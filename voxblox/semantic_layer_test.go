@@ -0,0 +1,140 @@
+package voxblox
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/qmuntal/gltf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemanticVoxelVote(t *testing.T) {
+	var voxel SemanticVoxel
+	_, ok := voxel.getLabel()
+	assert.False(t, ok)
+
+	voxel.vote(3)
+	voxel.vote(5)
+	voxel.vote(5)
+	label, ok := voxel.getLabel()
+	assert.True(t, ok)
+	assert.Equal(t, Label(5), label)
+	assert.Equal(t, 1, voxel.getVotes(3))
+
+	// Ties go to the lowest label.
+	voxel.vote(3)
+	label, _ = voxel.getLabel()
+	assert.Equal(t, Label(3), label)
+
+	// Every label keeps its votes, however many labels are voted for.
+	for label := Label(7); label < 107; label++ {
+		voxel.vote(label)
+	}
+	assert.Equal(t, 2, voxel.getVotes(3))
+	assert.Equal(t, 2, voxel.getVotes(5))
+	assert.Equal(t, 1, voxel.getVotes(106))
+	label, _ = voxel.getLabel()
+	assert.Equal(t, Label(3), label)
+
+	// A majority among many competing labels is kept exactly.
+	voxel = SemanticVoxel{}
+	for label := Label(2); label < 10; label++ {
+		voxel.vote(1)
+		voxel.vote(label)
+		voxel.vote(label)
+	}
+	voxel.vote(1)
+	voxel.vote(1)
+	voxel.vote(1)
+	label, _ = voxel.getLabel()
+	assert.Equal(t, Label(1), label)
+	assert.Equal(t, 11, voxel.getVotes(1))
+
+	// Saturated bins are halved.
+	voxel = SemanticVoxel{}
+	voxel.vote(1)
+	voxel.vote(2)
+	voxel.bins[0].votes = math.MaxUint32
+	voxel.vote(1)
+	assert.Equal(t, math.MaxUint32/2+1, voxel.getVotes(1))
+	assert.Equal(t, 0, voxel.getVotes(2))
+}
+
+func TestLabelColor(t *testing.T) {
+	assert.Equal(t, ColorWhite, labelColor(LabelUnknown))
+	colors := make(map[Color]struct{})
+	for label := Label(1); label <= 20; label++ {
+		colors[labelColor(label)] = struct{}{}
+	}
+	assert.Len(t, colors, 20)
+}
+
+func TestSemanticMapping(t *testing.T) {
+	semanticConfig := config
+	semanticConfig.MeshColorMode = MeshColorModeLabel
+	tsdfLayer := NewTsdfLayer(semanticConfig.VoxelSize, semanticConfig.VoxelsPerSide)
+	semanticLayer := NewSemanticLayer(tsdfLayer)
+	integrator := SimpleTsdfIntegrator{Config: &semanticConfig, Layer: tsdfLayer, SemanticLayer: semanticLayer}
+
+	// The cylinder is labeled 1 and the ground plane 2.
+	pointCloud := getTransformedPointCloud(poses[0])
+	pointCloud.Labels = make([]Label, len(pointCloud.Points))
+	for j, color := range pointCloud.Colors {
+		if color == ColorRed {
+			pointCloud.Labels[j] = 1
+		} else {
+			pointCloud.Labels[j] = 2
+		}
+	}
+	integrator.IntegratePointCloud(poses[0], pointCloud)
+	assert.Greater(t, semanticLayer.GetBlockCount(), 0)
+
+	label, ok := semanticLayer.GetLabel(Point{0.05, 2.05, 2.05})
+	assert.True(t, ok)
+	assert.Equal(t, Label(1), label)
+	label, ok = semanticLayer.GetLabel(Point{0.05, 4.05, 0.05})
+	assert.True(t, ok)
+	assert.Equal(t, Label(2), label)
+	_, ok = semanticLayer.GetLabel(Point{0.05, 4.05, 2.05})
+	assert.False(t, ok)
+
+	meshLayer := NewMeshLayer(tsdfLayer)
+	meshIntegrator := NewMeshIntegrator(semanticConfig, tsdfLayer, meshLayer)
+	meshIntegrator.SemanticLayer = semanticLayer
+	meshIntegrator.Integrate()
+
+	labelCounts := make(map[Label]int)
+	var labeledBlock *MeshBlock
+	for _, meshBlock := range meshLayer.GetBlocks() {
+		vertices, _, colors := meshBlock.GetMesh()
+		labels := meshBlock.GetLabels()
+		assert.Len(t, labels, len(vertices))
+		for j, label := range labels {
+			labelCounts[label]++
+			assert.Equal(t, labelColor(label), colors[j])
+		}
+		if len(labels) > 0 {
+			labeledBlock = meshBlock
+		}
+	}
+	assert.Greater(t, labelCounts[1], 0)
+	assert.Greater(t, labelCounts[2], 0)
+
+	// The labels are streamed as a custom vertex attribute.
+	buf, err := labeledBlock.Gltf()
+	assert.NoError(t, err)
+	doc := new(gltf.Document)
+	assert.NoError(t, gltf.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(doc))
+	accessor, ok := doc.Meshes[0].Primitives[0].Attributes[kGltfLabelAttribute]
+	assert.True(t, ok)
+	assert.Equal(t, gltf.ComponentUint, doc.Accessors[accessor].ComponentType)
+	assert.Equal(t, uint32(len(labeledBlock.GetLabels())), doc.Accessors[accessor].Count)
+	// Every vertex attribute is aligned to 4 bytes.
+	for _, accessor := range doc.Meshes[0].Primitives[0].Attributes {
+		bufferView := doc.BufferViews[*doc.Accessors[accessor].BufferView]
+		elementSize := gltf.SizeOfElement(doc.Accessors[accessor].ComponentType, doc.Accessors[accessor].Type)
+		assert.Zero(t, (bufferView.ByteOffset+doc.Accessors[accessor].ByteOffset)%4)
+		assert.Zero(t, max(bufferView.ByteStride, elementSize)%4)
+	}
+}
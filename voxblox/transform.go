@@ -1,6 +1,8 @@
 package voxblox
 
 import (
+	"math"
+
 	"github.com/ungerik/go3d/float64/quaternion"
 	"github.com/ungerik/go3d/float64/vec3"
 )
@@ -33,10 +35,14 @@ func transformPointCloud(transformation Transform, pointCloud PointCloud) PointC
 		transformedPoints[i] = transformation.transformPoint(point)
 	}
 	return PointCloud{
-		Width:  pointCloud.Width,
-		Height: pointCloud.Height,
-		Points: transformedPoints,
-		Colors: pointCloud.Colors,
+		Width:     pointCloud.Width,
+		Height:    pointCloud.Height,
+		Stamp:     pointCloud.Stamp,
+		Points:    transformedPoints,
+		Colors:    pointCloud.Colors,
+		Labels:    pointCloud.Labels,
+		Instances: pointCloud.Instances,
+		Channels:  pointCloud.Channels,
 	}
 }
 
@@ -58,10 +64,31 @@ func interpolatePoints(p1, p2 Point, f float64) Point {
 	}
 }
 
+// slerpQuaternions spherically interpolates between two rotations along the shortest path.
+// Falls back to linear interpolation for nearly identical rotations.
+// An alpha outside [0, 1] extrapolates the rotation.
+func slerpQuaternions(q1, q2 quaternion.T, alpha float64) quaternion.T {
+	if quaternion.Dot(&q1, &q2) < 0 {
+		q2 = q2.Negated()
+	}
+	d := math.Acos(math.Min(quaternion.Dot(&q1, &q2), 1))
+	if math.Sin(d) < kEpsilon {
+		q := quaternion.T{
+			q1[0] + (q2[0]-q1[0])*alpha,
+			q1[1] + (q2[1]-q1[1])*alpha,
+			q1[2] + (q2[2]-q1[2])*alpha,
+			q1[3] + (q2[3]-q1[3])*alpha,
+		}
+		return q.Normalized()
+	}
+	return quaternion.Slerp(&q1, &q2, alpha)
+}
+
 // InterpolateTransform interpolates between two Transformations
+// An alpha greater than 1 extrapolates beyond t2 at the same velocity.
 func InterpolateTransform(t1, t2 Transform, alpha float64) Transform {
 	return Transform{
 		Translation: interpolatePoints(t1.Translation, t2.Translation, alpha),
-		Rotation:    quaternion.Slerp(&t1.Rotation, &t2.Rotation, alpha),
+		Rotation:    slerpQuaternions(t1.Rotation, t2.Rotation, alpha),
 	}
 }
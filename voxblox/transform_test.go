@@ -39,3 +39,22 @@ func TestInverseTransform(t *testing.T) {
 	assert.InEpsilon(t, -0.2, inverse.Translation[1], 0.002)
 	assert.InEpsilon(t, -2, inverse.Translation[2], 0.005)
 }
+
+func TestInterpolateTransform(t *testing.T) {
+	t1 := Transform{Translation: Point{0, 0, 0}, Rotation: quaternion.Ident}
+	t2 := Transform{Translation: Point{1, 0, 0}, Rotation: quaternion.Ident}
+
+	// Identical rotations must not produce NaN.
+	transform := InterpolateTransform(t1, t2, 0.5)
+	assert.InDelta(t, 0.5, transform.Translation[0], kEpsilon)
+	assert.InDelta(t, 1.0, transform.Rotation[3], kEpsilon)
+
+	// Extrapolation continues at the same velocity.
+	t2.Rotation = quaternion.FromZAxisAngle(0.2)
+	transform = InterpolateTransform(t1, t2, 1.5)
+	assert.InDelta(t, 1.5, transform.Translation[0], kEpsilon)
+	expected := quaternion.FromZAxisAngle(0.3)
+	for j := 0; j < 4; j++ {
+		assert.InDelta(t, expected[j], transform.Rotation[j], kEpsilon)
+	}
+}
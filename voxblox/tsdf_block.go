@@ -7,7 +7,8 @@ import (
 	"github.com/ungerik/go3d/float64/vec3"
 )
 
-// TsdfBlock contains a map of voxels.
+// TsdfBlock contains a dense array of voxels.
+// The block lock protects the updated flag and the voxels.
 type TsdfBlock struct {
 	Index         IndexType
 	VoxelsPerSide int
@@ -18,7 +19,7 @@ type TsdfBlock struct {
 	BlockSizeInv  float64
 	sync.RWMutex
 	updated bool
-	voxels  map[IndexType]*TsdfVoxel
+	voxels  voxelArray[TsdfVoxel]
 }
 
 // NewTsdfBlock creates a new TsdfBlock.
@@ -32,7 +33,7 @@ func NewTsdfBlock(layer *TsdfLayer, index IndexType, origin Point) *TsdfBlock {
 	b.BlockSize = layer.BlockSize
 	b.BlockSizeInv = layer.BlockSizeInv
 	b.updated = true
-	b.voxels = make(map[IndexType]*TsdfVoxel)
+	b.voxels = newVoxelArray[TsdfVoxel](layer.VoxelsPerSide)
 	return b
 }
 
@@ -41,20 +42,18 @@ func (b *TsdfBlock) String() string {
 	return fmt.Sprintf("%d_%d_%d", b.Index[0], b.Index[1], b.Index[2])
 }
 
-// getVoxels returns a copy of the map of voxels.
+// getVoxels returns a copy of the observed voxels keyed by voxel Index.
 // Thread-safe.
-func (b *TsdfBlock) getVoxels() map[IndexType]*TsdfVoxel {
+func (b *TsdfBlock) getVoxels() map[IndexType]TsdfVoxel {
 	b.RLock()
 	defer b.RUnlock()
-	return b.voxels
-}
-
-// addVoxel adds a voxel to the block.
-// Thread-safe.
-func (b *TsdfBlock) addVoxel(voxel *TsdfVoxel) {
-	b.Lock()
-	defer b.Unlock()
-	b.voxels[voxel.Index] = voxel
+	voxels := make(map[IndexType]TsdfVoxel)
+	for j, voxel := range b.voxels.voxels {
+		if voxel.isObserved() {
+			voxels[b.voxels.voxelIndex(j)] = voxel
+		}
+	}
+	return voxels
 }
 
 // getUpdated gets the updated flag.
@@ -84,40 +83,82 @@ func (b *TsdfBlock) setNotUpdated() {
 	b.updated = false
 }
 
-// getVoxel returns a reference to a voxel at the given Index .
-// Creates a new voxel if it doesn't exist.
+// getVoxelIfExists returns a copy of the voxel at the given Index if it has been observed.
 // Thread-safe.
-func (b *TsdfBlock) getVoxel(voxelIndex IndexType) *TsdfVoxel {
-	// Test if voxel already exists
+func (b *TsdfBlock) getVoxelIfExists(voxelIndex IndexType) (TsdfVoxel, bool) {
+	if !b.isValidVoxelIndex(voxelIndex) {
+		return TsdfVoxel{}, false
+	}
 	b.RLock()
-	voxel, ok := b.voxels[voxelIndex]
+	voxel := *b.voxels.at(voxelIndex)
 	b.RUnlock()
-	if ok {
-		return voxel
+	return voxel, voxel.isObserved()
+}
+
+// setVoxel replaces the voxel at the given Index.
+// Thread-safe.
+func (b *TsdfBlock) setVoxel(voxelIndex IndexType, voxel TsdfVoxel) {
+	b.Lock()
+	defer b.Unlock()
+	*b.voxels.at(voxelIndex) = voxel
+}
+
+// applyVoxelUpdates merges the observations into the voxel at the given Index in order.
+// Thread-safe.
+func (b *TsdfBlock) applyVoxelUpdates(config *Config, voxelIndex IndexType, updates ...voxelUpdate) {
+	b.Lock()
+	defer b.Unlock()
+	voxel := b.voxels.at(voxelIndex)
+	for _, update := range updates {
+		applyVoxelUpdate(config, voxel, update)
 	}
-	// Create a new voxel
-	newVoxel := NewVoxel(voxelIndex)
-	b.addVoxel(newVoxel)
-	return newVoxel
 }
 
-// getVoxelIfExists returns a reference to a voxel at the given Index if exists.
-// Does not create a new voxel.
+// applyIndexedVoxelUpdates merges the observations into their voxels in order, locking the block once.
 // Thread-safe.
-func (b *TsdfBlock) getVoxelIfExists(voxelIndex IndexType) *TsdfVoxel {
-	b.RLock()
-	voxel, ok := b.voxels[voxelIndex]
-	b.RUnlock()
-	if ok {
-		return voxel
+func (b *TsdfBlock) applyIndexedVoxelUpdates(config *Config, updates []indexedVoxelUpdate) {
+	b.Lock()
+	defer b.Unlock()
+	for _, u := range updates {
+		applyVoxelUpdate(config, b.voxels.at(u.voxelIndex), u.update)
 	}
-	return nil
 }
 
-// getVoxelPtrByCoordinates returns a reference to a voxel at the given coordinates.
-// Creates a new voxel if it does not exist.
-func (b *TsdfBlock) getVoxelPtrByCoordinates(point Point) *TsdfVoxel {
-	return b.getVoxel(getGridIndexFromPoint(point, b.VoxelSize))
+// applyBlockUpdates merges the observations of every voxel into it in order, locking the block once.
+// Thread-safe.
+func (b *TsdfBlock) applyBlockUpdates(config *Config, updates blockUpdates) {
+	b.Lock()
+	defer b.Unlock()
+	for voxelIndex, voxelUpdates := range updates {
+		voxel := b.voxels.at(voxelIndex)
+		for _, update := range voxelUpdates {
+			applyVoxelUpdate(config, voxel, update)
+		}
+	}
+}
+
+// decayWeights multiplies the weight of every observed voxel by factor.
+// Voxels whose weight drops below minWeight are reset to unobserved.
+// Returns true if a voxel was reset.
+// Thread-safe.
+func (b *TsdfBlock) decayWeights(factor float64, minWeight float64) bool {
+	b.Lock()
+	defer b.Unlock()
+	reset := false
+	for j := range b.voxels.voxels {
+		voxel := &b.voxels.voxels[j]
+		if !voxel.isObserved() {
+			continue
+		}
+		weight := voxel.getWeight() * factor
+		if weight < minWeight {
+			*voxel = TsdfVoxel{}
+			reset = true
+			continue
+		}
+		voxel.setWeight(weight)
+	}
+	return reset
 }
 
 // computeTruncatedVoxelIndexFromCoordinates
@@ -125,12 +166,11 @@ func (b *TsdfBlock) getVoxelPtrByCoordinates(point Point) *TsdfVoxel {
 func (b *TsdfBlock) computeTruncatedVoxelIndexFromCoordinates(point Point) IndexType {
 	maxValue := b.VoxelsPerSide - 1
 	voxelIndex := getGridIndexFromPoint(vec3.Sub(&point, &b.Origin), b.VoxelSizeInv)
-	index := IndexType{
+	return IndexType{
 		maxInt(minInt(voxelIndex[0], maxValue), 0.0),
 		maxInt(minInt(voxelIndex[1], maxValue), 0.0),
 		maxInt(minInt(voxelIndex[2], maxValue), 0.0),
 	}
-	return b.getVoxel(index).Index
 }
 
 // computeCoordinatesFromVoxelIndex
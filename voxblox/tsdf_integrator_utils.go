@@ -2,6 +2,7 @@ package voxblox
 
 import (
 	"math"
+	"time"
 
 	"github.com/ungerik/go3d/float64/vec3"
 )
@@ -136,6 +137,33 @@ func NewRayCaster(
 		AllowCarving:       allowCarving,
 	}
 
+	startScaled, endScaled := computeScaledRayEndpoints(
+		ray,
+		voxelSizeInv,
+		truncationDistance,
+		maxRange,
+		allowCarving,
+	)
+
+	// Set up the ray caster.
+	if castFromOrigin {
+		rayCaster.SetUp(startScaled, endScaled)
+	} else {
+		rayCaster.SetUp(endScaled, startScaled)
+	}
+
+	return rayCaster
+}
+
+// computeScaledRayEndpoints returns the start and end of the traversal of a ray in voxel units.
+// Clearing rays stop the truncation distance before the point and their length is updated.
+func computeScaledRayEndpoints(
+	ray *Ray,
+	voxelSizeInv float64,
+	truncationDistance float64,
+	maxRange float64,
+	allowCarving bool,
+) (Point, Point) {
 	unitRay := vec3.Sub(&ray.Point, &ray.Origin)
 	unitRay.Normalize()
 
@@ -159,15 +187,7 @@ func NewRayCaster(
 	// Scale the ray to the voxel size.
 	startScaled := rayStart.Scaled(voxelSizeInv)
 	endScaled := rayEnd.Scaled(voxelSizeInv)
-
-	// Set up the ray caster.
-	if castFromOrigin {
-		rayCaster.SetUp(startScaled, endScaled)
-	} else {
-		rayCaster.SetUp(endScaled, startScaled)
-	}
-
-	return rayCaster
+	return startScaled, endScaled
 }
 
 // validateRay checks if the ray is valid.
@@ -199,6 +219,7 @@ func validateRay(
 }
 
 // updateTsdfVoxel updates the voxel SDF and weight.
+// Allocates the block if needed and returns it.
 func updateTsdfVoxel(
 	layer *TsdfLayer,
 	config *Config,
@@ -207,8 +228,23 @@ func updateTsdfVoxel(
 	globalVoxelIndex IndexType,
 	color Color,
 	weight float64,
-	voxel *TsdfVoxel,
-) {
+) *TsdfBlock {
+	update := computeVoxelUpdate(layer, config, origin, pointG, globalVoxelIndex, color, weight)
+	block, voxelIndex := getBlockAndVoxelIndexFromGlobalVoxelIndex(layer, globalVoxelIndex)
+	block.applyVoxelUpdates(config, voxelIndex, update)
+	return block
+}
+
+// computeVoxelUpdate computes the observation of a voxel by a ray.
+func computeVoxelUpdate(
+	layer *TsdfLayer,
+	config *Config,
+	origin Point,
+	pointG Point,
+	globalVoxelIndex IndexType,
+	color Color,
+	weight float64,
+) voxelUpdate {
 	voxelCenter := getCenterPointFromGridIndex(globalVoxelIndex, layer.VoxelSize)
 	sdf := computeDistance(origin, pointG, voxelCenter)
 
@@ -224,33 +260,69 @@ func updateTsdfVoxel(
 
 	// TODO: Sparsity compensation
 
-	// Lock the mutex
-	voxel.Lock()
-	defer voxel.Unlock()
+	return voxelUpdate{
+		sdf:    sdf,
+		weight: updatedWeight,
+		color:  color,
+		free:   sdf >= config.truncationDistance,
+	}
+}
+
+// decayLayerWeights decays the weight of every voxel of the layer by the elapsed time,
+// halving it every WeightHalfLife in dynamic mapping mode.
+// Voxels decayed below the min weight are reset so they disappear from the mesh.
+func decayLayerWeights(layer *TsdfLayer, config *Config, elapsed time.Duration) {
+	if !config.DynamicMapping || config.WeightHalfLife <= 0 || elapsed <= 0 {
+		return
+	}
+	blocks := layer.getBlocks()
+	defer TimeTrack(config.GetLogger(), time.Now(), "Decay Weights", "blocks", len(blocks))
+
+	factor := math.Exp2(-elapsed.Seconds() / config.WeightHalfLife.Seconds())
+	minWeight := math.Max(config.MinWeight, kEpsilon)
+	for _, block := range blocks {
+		if block.decayWeights(factor, minWeight) {
+			block.setUpdated()
+		}
+	}
+}
+
+// applyVoxelUpdate merges an observation into the voxel SDF, weight and color.
+// The caller must hold the block write lock.
+func applyVoxelUpdate(config *Config, voxel *TsdfVoxel, update voxelUpdate) {
+	weight := voxel.getWeight()
+	distance := voxel.getDistance()
+
+	// An occupied voxel observed as free belongs to an object that moved away.
+	// The distance is stored as float32, so a voxel clamped to the truncation distance is compared
+	// with the truncation distance rounded the same way.
+	if config.DynamicMapping && update.free && distance < float64(float32(config.truncationDistance)) {
+		weight *= config.FreeSpaceDecay
+	}
 
 	// Calculate the new weight
-	newWeight := voxel.weight + updatedWeight
+	newWeight := weight + update.weight
 	if newWeight < kEpsilon {
 		return
 	}
 	newWeight = math.Min(newWeight, config.MaxWeight)
 
 	// Calculate the new distance
-	newSdf := (sdf*updatedWeight + voxel.distance*voxel.weight) / newWeight
+	newSdf := (update.sdf*update.weight + distance*weight) / newWeight
 
 	// Blend colors
-	if math.Abs(sdf) < config.truncationDistance {
-		newColor := blendTwoColors(voxel.color, voxel.weight, color, updatedWeight)
+	if math.Abs(update.sdf) < config.truncationDistance {
+		newColor := blendTwoColors(voxel.color, weight, update.color, update.weight)
 		voxel.color = newColor
 	}
 
 	var newDistance float64
-	if sdf > 0 {
+	if update.sdf > 0 {
 		newDistance = math.Min(config.truncationDistance, newSdf)
 	} else {
 		newDistance = math.Max(-config.truncationDistance, newSdf)
 	}
 
-	voxel.weight = newWeight
-	voxel.distance = newDistance
+	voxel.setWeight(newWeight)
+	voxel.setDistance(newDistance)
 }
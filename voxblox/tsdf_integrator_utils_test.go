@@ -2,6 +2,7 @@ package voxblox
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -125,8 +126,7 @@ func TestUpdateTsdfVoxel(t *testing.T) {
 	pointG := Point{1.31130219e-06, 5.2854619, 1.1920929e-07}
 	weight := 0.252516776
 	globalVoxelIndex := IndexType{0, 60, 20}
-	_, voxel := getBlockAndVoxelFromGlobalVoxelIndex(layer, globalVoxelIndex)
-
+	var voxel TsdfVoxel
 	updateTsdfVoxel(
 		layer,
 		&config,
@@ -135,15 +135,13 @@ func TestUpdateTsdfVoxel(t *testing.T) {
 		globalVoxelIndex,
 		Color{},
 		weight,
-		voxel,
 	)
+	voxel = getTestVoxel(t, layer, globalVoxelIndex)
 	assert.InEpsilon(t, 0.4, voxel.getDistance(), kEpsilon)
 	assert.InEpsilon(t, 0.252516776, voxel.getWeight(), kEpsilon)
-	assert.Len(t, layer.blocks, 1)
+	assert.Equal(t, 1, layer.GetBlockCount())
 
 	globalVoxelIndex = IndexType{0, 59, 20}
-	_, voxel = getBlockAndVoxelFromGlobalVoxelIndex(layer, globalVoxelIndex)
-
 	updateTsdfVoxel(
 		layer,
 		&config,
@@ -152,15 +150,13 @@ func TestUpdateTsdfVoxel(t *testing.T) {
 		globalVoxelIndex,
 		Color{},
 		weight,
-		voxel,
 	)
+	voxel = getTestVoxel(t, layer, globalVoxelIndex)
 	assert.InEpsilon(t, 0.4, voxel.getDistance(), kEpsilon)
 	assert.InEpsilon(t, 0.252516776, voxel.getWeight(), kEpsilon)
-	assert.Len(t, layer.blocks, 1)
+	assert.Equal(t, 1, layer.GetBlockCount())
 
 	globalVoxelIndex = IndexType{0, 54, 3}
-	_, voxel = getBlockAndVoxelFromGlobalVoxelIndex(layer, globalVoxelIndex)
-
 	updateTsdfVoxel(
 		layer,
 		&config,
@@ -169,14 +165,12 @@ func TestUpdateTsdfVoxel(t *testing.T) {
 		globalVoxelIndex,
 		Color{},
 		weight,
-		voxel,
 	)
+	voxel = getTestVoxel(t, layer, globalVoxelIndex)
 	assert.InEpsilon(t, 0.384953856, voxel.getDistance(), kEpsilon)
 	assert.InEpsilon(t, 0.252516776, voxel.getWeight(), kEpsilon)
 
 	globalVoxelIndex = IndexType{0, 52, -1}
-	_, voxel = getBlockAndVoxelFromGlobalVoxelIndex(layer, globalVoxelIndex)
-
 	updateTsdfVoxel(
 		layer,
 		&config,
@@ -185,16 +179,14 @@ func TestUpdateTsdfVoxel(t *testing.T) {
 		globalVoxelIndex,
 		Color{},
 		weight,
-		voxel,
 	)
+	voxel = getTestVoxel(t, layer, globalVoxelIndex)
 	assert.InEpsilon(t, -0.05901622175430088, voxel.getDistance(), kEpsilon)
 	assert.InEpsilon(t, 0.252516776, voxel.getWeight(), kEpsilon)
 
 	pointG = Point{-0.0166654587, 5.2854619, 1.1920929e-07}
 	weight = 0.252939552
 	globalVoxelIndex = IndexType{0, 60, 20}
-	_, voxel = getBlockAndVoxelFromGlobalVoxelIndex(layer, globalVoxelIndex)
-
 	updateTsdfVoxel(
 		layer,
 		&config,
@@ -203,15 +195,13 @@ func TestUpdateTsdfVoxel(t *testing.T) {
 		globalVoxelIndex,
 		Color{},
 		weight,
-		voxel,
 	)
+	voxel = getTestVoxel(t, layer, globalVoxelIndex)
 	assert.InEpsilon(t, 0.4, voxel.getDistance(), kEpsilon)
 	assert.InEpsilon(t, 0.505456328, voxel.getWeight(), kEpsilon)
 
 	weight = 0.252939552
 	globalVoxelIndex = IndexType{-1, 52, -3}
-	_, voxel = getBlockAndVoxelFromGlobalVoxelIndex(layer, globalVoxelIndex)
-
 	updateTsdfVoxel(
 		layer,
 		&config,
@@ -220,8 +210,78 @@ func TestUpdateTsdfVoxel(t *testing.T) {
 		globalVoxelIndex,
 		Color{},
 		weight,
-		voxel,
 	)
+	voxel = getTestVoxel(t, layer, globalVoxelIndex)
 	assert.InEpsilon(t, -0.247611046, voxel.getDistance(), kEpsilon)
 	assert.InEpsilon(t, 0.128483981, voxel.getWeight(), kEpsilon)
 }
+
+func TestFreeSpaceDecay(t *testing.T) {
+	dynamicConfig := config
+	dynamicConfig.DynamicMapping = true
+	dynamicConfig.FreeSpaceDecay = 0.5
+
+	var voxel TsdfVoxel
+	voxel.setDistance(0)
+	voxel.setWeight(10)
+	free := voxelUpdate{sdf: config.truncationDistance, weight: 1, free: true}
+
+	staticVoxel := voxel
+	applyVoxelUpdate(&config, &staticVoxel, free)
+	assert.InEpsilon(t, 11.0, staticVoxel.getWeight(), kEpsilon)
+
+	// The occupied weight is halved before merging the free observation.
+	dynamicVoxel := voxel
+	applyVoxelUpdate(&dynamicConfig, &dynamicVoxel, free)
+	assert.InEpsilon(t, 6.0, dynamicVoxel.getWeight(), kEpsilon)
+	assert.InEpsilon(t, config.truncationDistance/6, dynamicVoxel.getDistance(), kEpsilon)
+
+	// Free voxels and occupied observations are merged as usual.
+	applyVoxelUpdate(&dynamicConfig, &dynamicVoxel, voxelUpdate{sdf: 0, weight: 1})
+	assert.InEpsilon(t, 7.0, dynamicVoxel.getWeight(), kEpsilon)
+	dynamicVoxel.setDistance(config.truncationDistance)
+	applyVoxelUpdate(&dynamicConfig, &dynamicVoxel, free)
+	assert.InEpsilon(t, 8.0, dynamicVoxel.getWeight(), kEpsilon)
+
+	// Voxels clamped to a truncation distance that rounds down as float32 are still free.
+	for _, voxelSize := range []float64{0.02, 0.03, 0.04} {
+		dynamicConfig.VoxelSize = voxelSize
+		dynamicConfig.truncationDistance = voxelSize * 4
+		assert.Less(t, float64(float32(dynamicConfig.truncationDistance)), dynamicConfig.truncationDistance)
+		free := voxelUpdate{sdf: dynamicConfig.truncationDistance, weight: 1, free: true}
+		voxel.setDistance(dynamicConfig.truncationDistance)
+		voxel.setWeight(10)
+		applyVoxelUpdate(&dynamicConfig, &voxel, free)
+		assert.InEpsilon(t, 11.0, voxel.getWeight(), kEpsilon, "voxel size %v", voxelSize)
+	}
+}
+
+func TestDecayLayerWeights(t *testing.T) {
+	dynamicConfig := config
+	dynamicConfig.DynamicMapping = true
+	dynamicConfig.WeightHalfLife = time.Second
+
+	layer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	block := setTestVoxel(layer, IndexType{1, 2, 3}, 0.1, 1, Color{})
+	setTestVoxel(layer, IndexType{4, 5, 6}, 0.1, 0.15, Color{})
+	block.setNotUpdated()
+
+	// Nothing decays without dynamic mapping.
+	decayLayerWeights(layer, &config, time.Second)
+	voxel := getTestVoxel(t, layer, IndexType{1, 2, 3})
+	assert.Equal(t, 1.0, voxel.getWeight())
+	assert.False(t, block.getUpdated())
+
+	decayLayerWeights(layer, &dynamicConfig, time.Second)
+	voxel = getTestVoxel(t, layer, IndexType{1, 2, 3})
+	assert.InEpsilon(t, 0.5, voxel.getWeight(), kEpsilon)
+	_, _, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(layer, IndexType{4, 5, 6})
+	assert.False(t, ok, "voxels below the min weight should be reset")
+	assert.True(t, block.getUpdated())
+
+	block.setNotUpdated()
+	decayLayerWeights(layer, &dynamicConfig, 2*time.Second)
+	voxel = getTestVoxel(t, layer, IndexType{1, 2, 3})
+	assert.InEpsilon(t, 0.125, voxel.getWeight(), kEpsilon)
+	assert.False(t, block.getUpdated(), "blocks are only flagged when voxels are reset")
+}
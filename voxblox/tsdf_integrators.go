@@ -1,19 +1,29 @@
 package voxblox
 
-import (
-	"sync"
-	"time"
-)
+import "time"
 
 // TsdfIntegrator is an interface for the TSDF integrator types.
 type TsdfIntegrator interface {
 	IntegratePointCloud(pose Transform, cloud PointCloud)
 }
 
+// WeightDecayer is implemented by the TSDF integrators supporting the time-based weight decay of dynamic mapping.
+type WeightDecayer interface {
+	DecayWeights(elapsed time.Duration)
+}
+
 // SimpleTsdfIntegrator is a slow but accurate TSDF integrator.
 type SimpleTsdfIntegrator struct {
 	Config *Config
 	Layer  *TsdfLayer
+	// Labels of the point clouds are fused into SemanticLayer if set.
+	SemanticLayer *SemanticLayer
+	// The point clouds are segmented into instances fused into InstanceLayer if set.
+	InstanceLayer *InstanceLayer
+	// Channels of the point clouds are fused into ChannelLayer if set.
+	ChannelLayer *ChannelLayer
+	// Voxel observations are recorded into ObservationLayer if set.
+	ObservationLayer *ObservationLayer
 }
 
 // NewSimpleTsdfIntegrator creates a new SimpleTsdfIntegrator.
@@ -30,64 +40,72 @@ func (i *SimpleTsdfIntegrator) IntegratePointCloud(
 	pose Transform,
 	pointCloud PointCloud,
 ) {
-	defer TimeTrack(time.Now(), "Integrate Simple")
+	defer TimeTrack(i.Config.GetLogger(), time.Now(), "Integrate Simple", "points", len(pointCloud.Points))
 
-	wg := sync.WaitGroup{}
-	for _, pC := range splitPointCloud(&pointCloud, i.Config.Threads) {
-		wg.Add(1)
-		go i.integratePoints(pose, pC, &wg)
+	if i.InstanceLayer != nil {
+		i.InstanceLayer.segmentPointCloud(i.Config, pose, &pointCloud)
 	}
-	wg.Wait()
+	integrateChunks(
+		i.Layer,
+		i.SemanticLayer,
+		i.InstanceLayer,
+		i.ChannelLayer,
+		i.ObservationLayer,
+		i.Config,
+		pointCloud,
+		func(pC PointCloud, updater *voxelUpdater) {
+			i.integratePoints(pose, pC, updater)
+		},
+	)
+}
+
+// DecayWeights decays the voxel weights by the elapsed time.
+func (i *SimpleTsdfIntegrator) DecayWeights(elapsed time.Duration) {
+	decayLayerWeights(i.Layer, i.Config, elapsed)
 }
 
 func (i *SimpleTsdfIntegrator) integratePoints(
 	pose Transform,
 	pointCloud PointCloud,
-	wg *sync.WaitGroup,
+	updater *voxelUpdater,
 ) {
+	rayCaster := newRayBatchCaster(
+		i.Layer,
+		i.Config,
+		true,
+		func(ray *Ray, j int, globalVoxelIdx IndexType) bool {
+			weight := 1.0
+			if !i.Config.WeightConstant {
+				weight = calculateWeight(pointCloud.Points[j])
+			}
+			updater.update(ray.Origin, ray.Point, globalVoxelIdx, &pointCloud, j, weight, ray.Clearing)
+			return true
+		},
+	)
+
 	for j, point := range pointCloud.Points {
 		var ray Ray
 		if validateRay(&ray, point, i.Config.MinRange, i.Config.MaxRange, i.Config.AllowClearing) {
 			// Transform the point into the global frame.
 			ray.Origin = pose.Translation
 			ray.Point = pose.transformPoint(point)
-
-			// Create a new Ray-caster.
-			rayCaster := NewRayCaster(
-				&ray,
-				i.Layer.VoxelSizeInv,
-				i.Config.truncationDistance,
-				i.Config.MaxRange,
-				i.Config.AllowCarving,
-				true,
-			)
-			var globalVoxelIdx IndexType
-			for rayCaster.nextRayIndex(&globalVoxelIdx) {
-				block, voxel := getBlockAndVoxelFromGlobalVoxelIndex(i.Layer, globalVoxelIdx)
-				weight := 1.0
-				if !i.Config.WeightConstant {
-					weight = calculateWeight(point)
-				}
-				updateTsdfVoxel(
-					i.Layer,
-					i.Config,
-					ray.Origin,
-					ray.Point,
-					globalVoxelIdx,
-					pointCloud.Colors[j],
-					weight,
-					voxel,
-				)
-				block.setUpdated()
-			}
+			rayCaster.add(&ray, j)
 		}
 	}
-	wg.Done()
+	rayCaster.flush()
 }
 
 type MergedTsdfIntegrator struct {
 	Config *Config
 	Layer  *TsdfLayer
+	// Labels of the point clouds are fused into SemanticLayer if set.
+	SemanticLayer *SemanticLayer
+	// The point clouds are segmented into instances fused into InstanceLayer if set.
+	InstanceLayer *InstanceLayer
+	// Channels of the point clouds are fused into ChannelLayer if set.
+	ChannelLayer *ChannelLayer
+	// Voxel observations are recorded into ObservationLayer if set.
+	ObservationLayer *ObservationLayer
 }
 
 // NewMergedTsdfIntegrator creates a new MergedTsdfIntegrator.
@@ -114,13 +132,34 @@ func (i *MergedTsdfIntegrator) IntegratePointCloud(
 	pose Transform,
 	pointCloud PointCloud,
 ) {
-	defer TimeTrack(time.Now(), "Integrate Merged")
+	defer TimeTrack(i.Config.GetLogger(), time.Now(), "Integrate Merged", "points", len(pointCloud.Points))
 
+	// Segment the full point cloud before it is decimated.
+	if i.InstanceLayer != nil {
+		i.InstanceLayer.segmentPointCloud(i.Config, pose, &pointCloud)
+	}
 	voxelMap := bundleRays(i.Layer.VoxelSizeInv, pointCloud)
 
 	// Filter the point cloud by the voxel map
 	filteredPoints := make([]Point, 0, len(pointCloud.Points))
 	filteredColors := make([]Color, 0, len(pointCloud.Colors))
+	var filteredLabels []Label
+	if pointCloud.Labels != nil {
+		filteredLabels = make([]Label, 0, len(pointCloud.Labels))
+	}
+	var filteredInstances []InstanceID
+	if pointCloud.Instances != nil {
+		filteredInstances = make([]InstanceID, 0, len(pointCloud.Instances))
+	}
+	var filteredChannels [][]float32
+	if pointCloud.Channels != nil {
+		filteredChannels = make([][]float32, len(pointCloud.Channels))
+		for c, values := range pointCloud.Channels {
+			if values != nil {
+				filteredChannels[c] = make([]float32, 0, len(values))
+			}
+		}
+	}
 	for _, pointIndex := range voxelMap {
 		filteredPoints = append(
 			filteredPoints,
@@ -130,23 +169,62 @@ func (i *MergedTsdfIntegrator) IntegratePointCloud(
 			filteredColors,
 			pointCloud.Colors[pointIndex],
 		)
+		if filteredLabels != nil {
+			filteredLabels = append(filteredLabels, pointCloud.Labels[pointIndex])
+		}
+		if filteredInstances != nil {
+			filteredInstances = append(filteredInstances, pointCloud.Instances[pointIndex])
+		}
+		for c, values := range filteredChannels {
+			if values != nil {
+				filteredChannels[c] = append(values, pointCloud.Channels[c][pointIndex])
+			}
+		}
 	}
 	pointCloud.Points = filteredPoints
 	pointCloud.Colors = filteredColors
+	pointCloud.Labels = filteredLabels
+	pointCloud.Instances = filteredInstances
+	pointCloud.Channels = filteredChannels
 
-	wg := sync.WaitGroup{}
-	for _, pC := range splitPointCloud(&pointCloud, i.Config.Threads) {
-		wg.Add(1)
-		go i.integratePoints(pose, pC, &wg)
-	}
-	wg.Wait()
+	integrateChunks(
+		i.Layer,
+		i.SemanticLayer,
+		i.InstanceLayer,
+		i.ChannelLayer,
+		i.ObservationLayer,
+		i.Config,
+		pointCloud,
+		func(pC PointCloud, updater *voxelUpdater) {
+			i.integratePoints(pose, pC, updater)
+		},
+	)
+}
+
+// DecayWeights decays the voxel weights by the elapsed time.
+func (i *MergedTsdfIntegrator) DecayWeights(elapsed time.Duration) {
+	decayLayerWeights(i.Layer, i.Config, elapsed)
 }
 
 func (i *MergedTsdfIntegrator) integratePoints(
 	pose Transform,
 	pointCloud PointCloud,
-	wg *sync.WaitGroup,
+	updater *voxelUpdater,
 ) {
+	rayCaster := newRayBatchCaster(
+		i.Layer,
+		i.Config,
+		true,
+		func(ray *Ray, j int, globalVoxelIdx IndexType) bool {
+			weight := 1.0
+			if !i.Config.WeightConstant {
+				weight = calculateWeight(pointCloud.Points[j])
+			}
+			updater.update(ray.Origin, ray.Point, globalVoxelIdx, &pointCloud, j, weight, ray.Clearing)
+			return true
+		},
+	)
+
 	for j, point := range pointCloud.Points {
 		var ray Ray
 		if validateRay(&ray, point, i.Config.MinRange, i.Config.MaxRange, i.Config.AllowClearing) {
@@ -155,43 +233,23 @@ func (i *MergedTsdfIntegrator) integratePoints(
 			ray.Point = pose.transformPoint(point)
 
 			// TODO: Merge weights
-
-			// Create a new Ray-caster.
-			rayCaster := NewRayCaster(
-				&ray,
-				i.Layer.VoxelSizeInv,
-				i.Config.truncationDistance,
-				i.Config.MaxRange,
-				i.Config.AllowCarving,
-				true,
-			)
-			var globalVoxelIdx IndexType
-			for rayCaster.nextRayIndex(&globalVoxelIdx) {
-				block, voxel := getBlockAndVoxelFromGlobalVoxelIndex(i.Layer, globalVoxelIdx)
-				weight := 1.0
-				if !i.Config.WeightConstant {
-					weight = calculateWeight(point)
-				}
-				updateTsdfVoxel(
-					i.Layer,
-					i.Config,
-					ray.Origin,
-					ray.Point,
-					globalVoxelIdx,
-					pointCloud.Colors[j],
-					weight,
-					voxel,
-				)
-				block.setUpdated()
-			}
+			rayCaster.add(&ray, j)
 		}
 	}
-	wg.Done()
+	rayCaster.flush()
 }
 
 type FastTsdfIntegrator struct {
 	Config *Config
 	Layer  *TsdfLayer
+	// Labels of the point clouds are fused into SemanticLayer if set.
+	SemanticLayer *SemanticLayer
+	// The point clouds are segmented into instances fused into InstanceLayer if set.
+	InstanceLayer *InstanceLayer
+	// Channels of the point clouds are fused into ChannelLayer if set.
+	ChannelLayer *ChannelLayer
+	// Voxel observations are recorded into ObservationLayer if set.
+	ObservationLayer *ObservationLayer
 }
 
 func NewFastTsdfIntegrator(config *Config, layer *TsdfLayer) *FastTsdfIntegrator {
@@ -207,24 +265,72 @@ func (i *FastTsdfIntegrator) IntegratePointCloud(
 	pose Transform,
 	pointCloud PointCloud,
 ) {
-	defer TimeTrack(time.Now(), "Integrate Fast")
+	defer TimeTrack(i.Config.GetLogger(), time.Now(), "Integrate Fast", "points", len(pointCloud.Points))
 
-	wg := sync.WaitGroup{}
-	for _, pC := range splitPointCloud(&pointCloud, i.Config.Threads) {
-		wg.Add(1)
-		go i.integratePoints(pose, pC, &wg)
+	if i.InstanceLayer != nil {
+		i.InstanceLayer.segmentPointCloud(i.Config, pose, &pointCloud)
 	}
-	wg.Wait()
+	integrateChunks(
+		i.Layer,
+		i.SemanticLayer,
+		i.InstanceLayer,
+		i.ChannelLayer,
+		i.ObservationLayer,
+		i.Config,
+		pointCloud,
+		func(pC PointCloud, updater *voxelUpdater) {
+			i.integratePoints(pose, pC, updater)
+		},
+	)
+}
+
+// DecayWeights decays the voxel weights by the elapsed time.
+func (i *FastTsdfIntegrator) DecayWeights(elapsed time.Duration) {
+	decayLayerWeights(i.Layer, i.Config, elapsed)
 }
 
 func (i *FastTsdfIntegrator) integratePoints(
 	pose Transform,
 	pointCloud PointCloud,
-	wg *sync.WaitGroup,
+	updater *voxelUpdater,
 ) {
 	startVoxelApproxSet := map[IndexType]struct{}{}
 	observedVoxelApproxSet := map[IndexType]struct{}{}
 
+	currentPoint := -1
+	consecutiveRayCollisions := 0
+
+	rayCaster := newRayBatchCaster(
+		i.Layer,
+		i.Config,
+		false,
+		func(ray *Ray, j int, globalVoxelIndex IndexType) bool {
+			if j != currentPoint {
+				currentPoint = j
+				consecutiveRayCollisions = 0
+			}
+
+			// Check if the current voxel has been seen by any ray cast this scan.
+			// If it has increment the consecutive_ray_collisions counter, otherwise
+			// reset it. If the counter reaches a threshold we stop casting as the
+			// ray is deemed to be contributing too little new information.
+			if _, ok := observedVoxelApproxSet[globalVoxelIndex]; ok {
+				consecutiveRayCollisions++
+			}
+			observedVoxelApproxSet[globalVoxelIndex] = struct{}{}
+			if consecutiveRayCollisions >= i.Config.MaxConsecutiveRayCollisions {
+				return false
+			}
+
+			weight := 1.0
+			if !i.Config.WeightConstant {
+				weight = calculateWeight(pointCloud.Points[j])
+			}
+			updater.update(ray.Origin, ray.Point, globalVoxelIndex, &pointCloud, j, weight, ray.Clearing)
+			return true
+		},
+	)
+
 	for j, point := range pointCloud.Points {
 		var ray Ray
 		if !validateRay(&ray, point, i.Config.MinRange, i.Config.MaxRange, i.Config.AllowClearing) {
@@ -251,48 +357,7 @@ func (i *FastTsdfIntegrator) integratePoints(
 		}
 		startVoxelApproxSet[globalVoxelIndex] = struct{}{}
 
-		// Create a new Ray-caster.
-		rayCaster := NewRayCaster(
-			&ray,
-			i.Layer.VoxelSizeInv,
-			i.Config.truncationDistance,
-			i.Config.MaxRange,
-			i.Config.AllowCarving,
-			false,
-		)
-
-		consecutiveRayCollisions := 0
-
-		for rayCaster.nextRayIndex(&globalVoxelIndex) {
-			// Check if the current voxel has been seen by any ray cast this scan.
-			// If it has increment the consecutive_ray_collisions counter, otherwise
-			// reset it. If the counter reaches a threshold we stop casting as the
-			// ray is deemed to be contributing too little new information.
-			if _, ok := observedVoxelApproxSet[globalVoxelIndex]; ok {
-				consecutiveRayCollisions++
-			}
-			observedVoxelApproxSet[globalVoxelIndex] = struct{}{}
-			if consecutiveRayCollisions >= i.Config.MaxConsecutiveRayCollisions {
-				break
-			}
-
-			block, voxel := getBlockAndVoxelFromGlobalVoxelIndex(i.Layer, globalVoxelIndex)
-			weight := 1.0
-			if !i.Config.WeightConstant {
-				weight = calculateWeight(point)
-			}
-			updateTsdfVoxel(
-				i.Layer,
-				i.Config,
-				ray.Origin,
-				ray.Point,
-				globalVoxelIndex,
-				pointCloud.Colors[j],
-				weight,
-				voxel,
-			)
-			block.setUpdated()
-		}
+		rayCaster.add(&ray, j)
 	}
-	wg.Done()
+	rayCaster.flush()
 }
@@ -1,9 +1,12 @@
 package voxblox
 
 import (
+	"fmt"
 	"math"
+	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -96,7 +99,7 @@ func init() {
 func TestSimpleIntegratorSingleCloud(t *testing.T) {
 	// Simple integrator
 	tsdfLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
-	simpleTsdfIntegrator := SimpleTsdfIntegrator{&config, tsdfLayer}
+	simpleTsdfIntegrator := SimpleTsdfIntegrator{Config: &config, Layer: tsdfLayer}
 
 	pointCloud := world.getPointCloudFromTransform(
 		&poses[0],
@@ -115,14 +118,12 @@ func TestSimpleIntegratorSingleCloud(t *testing.T) {
 	simpleTsdfIntegrator.IntegratePointCloud(poses[0], transformedPointCloud)
 	assert.Equal(t, 62, tsdfLayer.GetBlockCount())
 
-	_, voxel := getBlockAndVoxelFromGlobalVoxelIndex(
-		simpleTsdfIntegrator.Layer,
-		IndexType{0, 60, 20},
-	)
+	voxel := getTestVoxel(t, simpleTsdfIntegrator.Layer, IndexType{0, 60, 20})
 	assert.InEpsilon(t, 0.4, voxel.getDistance(), kEpsilon)
 	assert.InEpsilon(t, 10000.0, voxel.getWeight(), kEpsilon)
 
-	voxel = tsdfLayer.getBlockByIndex(IndexType{-1, 0, 2}).getVoxel(IndexType{4, 15, 0})
+	voxel, ok := tsdfLayer.getBlockByIndex(IndexType{-1, 0, 2}).getVoxelIfExists(IndexType{4, 15, 0})
+	assert.True(t, ok)
 	assert.InEpsilon(t, -0.122520447, voxel.getDistance(), 0.001)
 	assert.InEpsilon(t, 0.531333983, voxel.getWeight(), 0.001)
 
@@ -159,11 +160,11 @@ func TestFastIntegratorSingleCloud(t *testing.T) {
 func TestTsdfIntegrators(t *testing.T) {
 	// Simple integrator
 	simpleLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
-	simpleTsdfIntegrator := SimpleTsdfIntegrator{&config, simpleLayer}
+	simpleTsdfIntegrator := SimpleTsdfIntegrator{Config: &config, Layer: simpleLayer}
 
 	// Merged integrator
 	mergedLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
-	mergedTsdfIntegrator := MergedTsdfIntegrator{&config, mergedLayer}
+	mergedTsdfIntegrator := MergedTsdfIntegrator{Config: &config, Layer: mergedLayer}
 
 	// Fast integrator
 	fastLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
@@ -179,7 +180,7 @@ func TestTsdfIntegrators(t *testing.T) {
 		)
 		poseInverse := pose.inverse()
 		transformedPointCloud := transformPointCloud(poseInverse, pointCloud)
-		deltaTransform := GetIcpTransform(simpleLayer, pose, transformedPointCloud)
+		deltaTransform := GetIcpTransform(&config, simpleLayer, pose, transformedPointCloud)
 		// Apply the delta transform to the pose
 		pose = Transform{
 			Translation: vec3.Add(&pose.Translation, &deltaTransform.Translation),
@@ -201,19 +202,215 @@ func TestTsdfIntegrators(t *testing.T) {
 	meshIntegrator := NewMeshIntegrator(config, simpleLayer, simpleMeshLayer)
 	meshIntegrator.Integrate()
 	assert.Equal(t, simpleLayer.GetBlockCount(), simpleMeshLayer.getBlockCount())
-	WriteMeshLayerToObjFiles(simpleMeshLayer, "../output/simple_mesh")
+	assert.NoError(t, WriteMeshLayerToObjFiles(simpleMeshLayer, filepath.Join(t.TempDir(), "simple_mesh")))
 
 	// Generate merged layer mesh.
 	mergedMeshLayer := NewMeshLayer(mergedLayer)
 	meshIntegrator = NewMeshIntegrator(config, mergedLayer, mergedMeshLayer)
 	meshIntegrator.Integrate()
 	assert.Equal(t, mergedLayer.GetBlockCount(), mergedMeshLayer.getBlockCount())
-	WriteMeshLayerToObjFiles(mergedMeshLayer, "../output/merged_mesh")
+	assert.NoError(t, WriteMeshLayerToObjFiles(mergedMeshLayer, filepath.Join(t.TempDir(), "merged_mesh")))
 
 	// Generate fast layer mesh.
 	fastMeshLayer := NewMeshLayer(fastLayer)
 	meshIntegrator = NewMeshIntegrator(config, fastLayer, fastMeshLayer)
 	meshIntegrator.Integrate()
 	assert.Equal(t, fastLayer.GetBlockCount(), fastMeshLayer.getBlockCount())
-	WriteMeshLayerToObjFiles(fastMeshLayer, "../output/fast_mesh")
+	assert.NoError(t, WriteMeshLayerToObjFiles(fastMeshLayer, filepath.Join(t.TempDir(), "fast_mesh")))
+}
+
+// getTransformedPointCloud returns the simulated point cloud of a pose in the sensor frame.
+func getTransformedPointCloud(pose Transform) PointCloud {
+	pointCloud := world.getPointCloudFromTransform(
+		&pose,
+		cameraResolution,
+		fovHorizontal,
+		maxDistance,
+	)
+	return transformPointCloud(pose.inverse(), pointCloud)
+}
+
+func TestBatchedVoxelUpdates(t *testing.T) {
+	pointCloud := getTransformedPointCloud(poses[0])
+
+	directConfig := config
+	directConfig.Threads = 1
+	directLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	NewFastTsdfIntegrator(&directConfig, directLayer).IntegratePointCloud(poses[0], pointCloud)
+
+	// A single batched worker applies the same updates in the same order.
+	batchedConfig := directConfig
+	batchedConfig.BatchVoxelUpdates = true
+	batchedLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	NewFastTsdfIntegrator(&batchedConfig, batchedLayer).IntegratePointCloud(poses[0], pointCloud)
+
+	assert.Equal(t, directLayer.GetBlockCount(), batchedLayer.GetBlockCount())
+	for blockIndex, directBlock := range directLayer.getBlocks() {
+		batchedBlock := batchedLayer.getBlockIfExists(blockIndex)
+		assert.NotNil(t, batchedBlock)
+		assert.True(t, directBlock.getUpdated())
+		assert.True(t, batchedBlock.getUpdated())
+		assert.Equal(t, len(directBlock.getVoxels()), len(batchedBlock.getVoxels()))
+		for voxelIndex, directVoxel := range directBlock.getVoxels() {
+			batchedVoxel, ok := batchedBlock.getVoxelIfExists(voxelIndex)
+			assert.True(t, ok)
+			assert.Equal(t, directVoxel.getDistance(), batchedVoxel.getDistance())
+			assert.Equal(t, directVoxel.getWeight(), batchedVoxel.getWeight())
+			assert.Equal(t, directVoxel.getColor(), batchedVoxel.getColor())
+		}
+	}
+
+	// Many batched workers allocate the same blocks.
+	batchedConfig.Threads = 16
+	batchedLayer = NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+	NewFastTsdfIntegrator(&batchedConfig, batchedLayer).IntegratePointCloud(poses[0], pointCloud)
+	assert.Equal(t, directLayer.GetBlockCount(), batchedLayer.GetBlockCount())
+}
+
+func BenchmarkFastIntegrator(b *testing.B) {
+	pointCloud := getTransformedPointCloud(poses[0])
+	for _, batched := range []bool{false, true} {
+		for _, threads := range []int{1, 4, 16} {
+			mode := "direct"
+			if batched {
+				mode = "batched"
+			}
+			b.Run(fmt.Sprintf("%s/threads=%d", mode, threads), func(b *testing.B) {
+				benchConfig := config
+				benchConfig.Threads = threads
+				benchConfig.BatchVoxelUpdates = batched
+				for n := 0; n < b.N; n++ {
+					tsdfLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+					integrator := NewFastTsdfIntegrator(&benchConfig, tsdfLayer)
+					integrator.IntegratePointCloud(poses[0], pointCloud)
+				}
+			})
+		}
+	}
+}
+
+// countSurfaceVoxels returns the number of meshable surface voxels inside the given box.
+func countSurfaceVoxels(layer *TsdfLayer, minBound Point, maxBound Point) int {
+	count := 0
+	for _, block := range layer.getBlocks() {
+		for voxelIndex, voxel := range block.getVoxels() {
+			center := block.computeCoordinatesFromVoxelIndex(voxelIndex)
+			inside := true
+			for k := 0; k < 3; k++ {
+				inside = inside && center[k] >= minBound[k] && center[k] <= maxBound[k]
+			}
+			if inside && voxel.getWeight() >= config.MinWeight && math.Abs(voxel.getDistance()) < config.VoxelSize {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func TestDynamicMapping(t *testing.T) {
+	// A person standing in front of the camera who then walks away.
+	person := Cylinder{
+		Center: Point{0.0, 4.5, 1.0},
+		Radius: 0.3,
+		Height: 1.8,
+		Color:  ColorRed,
+	}
+	emptyWorld := NewSimulationWorld(config.VoxelSize, world.MinBound, world.MaxBound)
+	emptyWorld.Objects = world.Objects
+	personWorld := NewSimulationWorld(config.VoxelSize, world.MinBound, world.MaxBound)
+	personWorld.Objects = append(append([]Object{}, world.Objects...), &person)
+
+	getPointCloud := func(world *SimulationWorld) PointCloud {
+		pointCloud := world.getPointCloudFromTransform(&poses[0], cameraResolution, fovHorizontal, maxDistance)
+		return transformPointCloud(poses[0].inverse(), pointCloud)
+	}
+	personCloud := getPointCloud(personWorld)
+	emptyCloud := getPointCloud(emptyWorld)
+
+	// Returns the surface voxels left where the person stood and elsewhere.
+	integrate := func(dynamic bool) (int, int) {
+		dynamicConfig := config
+		dynamicConfig.DynamicMapping = dynamic
+		dynamicConfig.FreeSpaceDecay = 0.5
+		dynamicConfig.WeightHalfLife = time.Second
+		tsdfLayer := NewTsdfLayer(config.VoxelSize, config.VoxelsPerSide)
+		integrator := NewFastTsdfIntegrator(&dynamicConfig, tsdfLayer)
+		for j := 0; j < 5; j++ {
+			integrator.IntegratePointCloud(poses[0], personCloud)
+			integrator.DecayWeights(time.Second)
+		}
+		for j := 0; j < 10; j++ {
+			integrator.IntegratePointCloud(poses[0], emptyCloud)
+			integrator.DecayWeights(time.Second)
+		}
+		ghosts := countSurfaceVoxels(tsdfLayer, Point{-0.4, 4.1, 0.5}, Point{0.4, 4.9, 1.8})
+		background := countSurfaceVoxels(tsdfLayer, Point{-5, -5, -1}, Point{5, 5, 6})
+		return ghosts, background - ghosts
+	}
+
+	staticGhosts, staticBackground := integrate(false)
+	dynamicGhosts, dynamicBackground := integrate(true)
+	assert.Greater(t, staticGhosts, 0)
+	assert.Equal(t, 0, dynamicGhosts)
+	assert.Greater(t, dynamicBackground, staticBackground/2)
+}
+
+func TestVoxelObservationAges(t *testing.T) {
+	ageConfig := config
+	ageConfig.MeshColorMode = MeshColorModeAge
+	ageConfig.MeshAgeRange = time.Minute
+	tsdfLayer := NewTsdfLayer(ageConfig.VoxelSize, ageConfig.VoxelsPerSide)
+	observationLayer := NewObservationLayer(tsdfLayer)
+	integrator := FastTsdfIntegrator{Config: &ageConfig, Layer: tsdfLayer, ObservationLayer: observationLayer}
+	meshLayer := NewMeshLayer(tsdfLayer)
+	meshIntegrator := NewMeshIntegrator(ageConfig, tsdfLayer, meshLayer)
+	meshIntegrator.ObservationLayer = observationLayer
+
+	stamp := time.Unix(1700000000, 0)
+	pointCloud := getTransformedPointCloud(poses[0])
+	pointCloud.Stamp = stamp
+	integrator.IntegratePointCloud(poses[0], pointCloud)
+	assert.Equal(t, stamp, observationLayer.GetLatestObserved())
+
+	// A freshly observed map is green.
+	meshIntegrator.Integrate()
+	countColors := func() (int, int) {
+		fresh, old := 0, 0
+		for _, meshBlock := range meshLayer.GetBlocks() {
+			_, _, colors := meshBlock.GetMesh()
+			for _, color := range colors {
+				switch color {
+				case ageColor(0, time.Minute):
+					fresh++
+				case ageColor(time.Minute, time.Minute):
+					old++
+				}
+			}
+		}
+		return fresh, old
+	}
+	fresh, old := countColors()
+	assert.Greater(t, fresh, 0)
+	assert.Equal(t, 0, old)
+
+	// Surfaces only seen by the first cloud turn red a minute later.
+	lastPose := poses[len(poses)-1]
+	pointCloud = getTransformedPointCloud(lastPose)
+	pointCloud.Stamp = stamp.Add(time.Minute)
+	integrator.IntegratePointCloud(lastPose, pointCloud)
+	tsdfLayer.setAllUpdated()
+	meshIntegrator.Integrate()
+	fresh, old = countColors()
+	assert.Greater(t, fresh, 0)
+	assert.Greater(t, old, 0)
+
+	points, ages := observationLayer.GetVoxelAges(tsdfLayer, stamp.Add(time.Minute), ageConfig.MinWeight)
+	assert.Len(t, ages, len(points))
+	assert.Contains(t, ages, time.Duration(0))
+	assert.Contains(t, ages, time.Minute)
+
+	// Pruning removes the voxels not observed by the second cloud.
+	assert.Greater(t, observationLayer.PruneVoxels(tsdfLayer, stamp.Add(time.Minute), 30*time.Second), 0)
+	_, ages = observationLayer.GetVoxelAges(tsdfLayer, stamp.Add(time.Minute), 0)
+	assert.NotContains(t, ages, time.Minute)
 }
@@ -1,9 +1,5 @@
 package voxblox
 
-import (
-	"sync"
-)
-
 type TsdfLayer struct {
 	VoxelSize        float64
 	VoxelSizeInv     float64
@@ -11,8 +7,7 @@ type TsdfLayer struct {
 	VoxelsPerSideInv float64
 	BlockSize        float64
 	BlockSizeInv     float64
-	sync.RWMutex
-	blocks map[IndexType]*TsdfBlock
+	blocks           *blockHash[*TsdfBlock]
 }
 
 // NewTsdfLayer creates a new TsdfLayer.
@@ -25,25 +20,21 @@ func NewTsdfLayer(voxelSize float64, voxelsPerSide int) *TsdfLayer {
 	l.VoxelsPerSideInv = 1.0 / float64(voxelsPerSide)
 	l.BlockSize = voxelSize * float64(voxelsPerSide)
 	l.BlockSizeInv = 1.0 / l.BlockSize
-	l.blocks = make(map[IndexType]*TsdfBlock)
+	l.blocks = newBlockHash[*TsdfBlock]()
 	return l
 }
 
 // getBlocks returns a copy of the map of blocks
 // Thread-safe.
 func (l *TsdfLayer) getBlocks() map[IndexType]*TsdfBlock {
-	l.RLock()
-	defer l.RUnlock()
-	return l.blocks
+	return l.blocks.snapshot()
 }
 
 // getUpdatedBlocks returns a map of references to TsdfBlocks that have been updated
 // Thread-safe.
 func (l *TsdfLayer) getUpdatedBlocks() map[IndexType]*TsdfBlock {
-	l.RLock()
-	defer l.RUnlock()
 	updatedBlocks := make(map[IndexType]*TsdfBlock)
-	for index, block := range l.blocks {
+	for index, block := range l.blocks.snapshot() {
 		if block.getUpdated() {
 			updatedBlocks[index] = block
 		}
@@ -51,33 +42,37 @@ func (l *TsdfLayer) getUpdatedBlocks() map[IndexType]*TsdfBlock {
 	return updatedBlocks
 }
 
+// setAllUpdated flags every block as updated.
+// Thread-safe.
+func (l *TsdfLayer) setAllUpdated() {
+	for _, block := range l.blocks.snapshot() {
+		block.setUpdated()
+	}
+}
+
+// Clear removes all blocks from the layer.
+// Thread-safe.
+func (l *TsdfLayer) Clear() {
+	l.blocks.clear()
+}
+
 // GetBlockCount returns the number of blocks allocated in the map
 // Thread-safe.
 func (l *TsdfLayer) GetBlockCount() int {
-	l.RLock()
-	defer l.RUnlock()
-	return len(l.blocks)
+	return l.blocks.len()
 }
 
 // getBlockByIndex allocates a new block in the map or returns an existing one
+// Concurrent calls for the same index return the same block.
 // Thread-safe.
 func (l *TsdfLayer) getBlockByIndex(blockIndex IndexType) *TsdfBlock {
-	// Test if block already exists
-	l.RLock()
-	block, ok := l.blocks[blockIndex]
-	l.RUnlock()
-	if ok {
-		return block
-	}
-	newBlock := NewTsdfBlock(
-		l,
-		blockIndex,
-		getOriginPointFromGridIndex(blockIndex, l.BlockSize),
-	)
-	l.Lock()
-	l.blocks[blockIndex] = newBlock
-	l.Unlock()
-	return newBlock
+	return l.blocks.getOrCreate(blockIndex, func() *TsdfBlock {
+		return NewTsdfBlock(
+			l,
+			blockIndex,
+			getOriginPointFromGridIndex(blockIndex, l.BlockSize),
+		)
+	})
 }
 
 // getBlockByCoordinates returns a pointer to the block by coordinates
@@ -88,39 +83,41 @@ func (l *TsdfLayer) getBlockByCoordinates(point Point) *TsdfBlock {
 // getBlockIfExists returns a pointer to the block if it exists
 // Thread-safe.
 func (l *TsdfLayer) getBlockIfExists(index IndexType) *TsdfBlock {
-	l.RLock()
-	defer l.RUnlock()
-	block, ok := l.blocks[index]
-	if ok {
-		return block
-	}
-	return nil
+	block, _ := l.blocks.get(index)
+	return block
+}
+
+// removeBlock removes the block at the given index if it exists.
+// Thread-safe.
+func (l *TsdfLayer) removeBlock(index IndexType) {
+	l.blocks.delete(index)
 }
 
-// getBlockAndVoxelFromGlobalVoxelIndex allocates a new block in the map and returns the block and voxel
-func getBlockAndVoxelFromGlobalVoxelIndex(
+// getBlockAndVoxelIndexFromGlobalVoxelIndex allocates a new block in the map and returns the block
+// and the local voxel Index
+func getBlockAndVoxelIndexFromGlobalVoxelIndex(
 	layer *TsdfLayer,
 	globalVoxelIndex IndexType,
-) (*TsdfBlock, *TsdfVoxel) {
+) (*TsdfBlock, IndexType) {
 	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, layer.VoxelsPerSideInv)
 	block := layer.getBlockByIndex(blockIndex)
 	voxelIndex := getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, layer.VoxelsPerSide)
-	voxel := block.getVoxel(voxelIndex)
-	return block, voxel
+	return block, voxelIndex
 }
 
-// getBlockAndVoxelFromGlobalVoxelIndexIfExists returns a pointer to the block and voxel if they exist
+// getBlockAndVoxelFromGlobalVoxelIndexIfExists returns a pointer to the block and a copy of the voxel
+// if they exist and the voxel has been observed
 // Thread-safe.
 func getBlockAndVoxelFromGlobalVoxelIndexIfExists(
 	layer *TsdfLayer,
 	globalVoxelIndex IndexType,
-) (*TsdfBlock, *TsdfVoxel) {
+) (*TsdfBlock, TsdfVoxel, bool) {
 	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, layer.VoxelsPerSideInv)
 	block := layer.getBlockIfExists(blockIndex)
 	if block == nil {
-		return nil, nil
+		return nil, TsdfVoxel{}, false
 	}
 	voxelIndex := getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, layer.VoxelsPerSide)
-	voxel := block.getVoxelIfExists(voxelIndex)
-	return block, voxel
+	voxel, ok := block.getVoxelIfExists(voxelIndex)
+	return block, voxel, ok
 }
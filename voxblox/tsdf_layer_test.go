@@ -1,7 +1,9 @@
 package voxblox
 
 import (
+	"runtime"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -91,7 +93,6 @@ func TestTsdfMapIndexLookups(t *testing.T) {
 
 	// Voxel 0 1 0
 	pointIn000 = Point{0.0, 1.0 * tsdfLayer.VoxelSize, 0.0}
-	assert.NotNil(t, block000.getVoxelPtrByCoordinates(pointIn000))
 	voxelIndex := block000.computeTruncatedVoxelIndexFromCoordinates(pointIn000)
 	assert.Equal(t, IndexType{0, 1, 0}, voxelIndex)
 
@@ -102,7 +103,6 @@ func TestTsdfMapIndexLookups(t *testing.T) {
 
 	// Voxel 0 0 0
 	pointIn000 = Point{0.0, 0.0, 0.0}
-	assert.NotNil(t, block000.getVoxelPtrByCoordinates(pointIn000))
 	voxelIndex = block000.computeTruncatedVoxelIndexFromCoordinates(pointIn000)
 	assert.Equal(t, IndexType{0, 0, 0}, voxelIndex)
 	pointIn000center = block000.computeCoordinatesFromVoxelIndex(voxelIndex)
@@ -116,7 +116,6 @@ func TestTsdfMapIndexLookups(t *testing.T) {
 		7.0 * tsdfLayer.VoxelSize,
 		7.0 * tsdfLayer.VoxelSize,
 	}
-	assert.NotNil(t, block000.getVoxelPtrByCoordinates(pointIn000))
 	voxelIndex = block000.computeTruncatedVoxelIndexFromCoordinates(pointIn000)
 	assert.Equal(t, IndexType{7, 7, 7}, voxelIndex)
 	pointIn000center = block000.computeCoordinatesFromVoxelIndex(voxelIndex)
@@ -132,7 +131,6 @@ func TestTsdfMapIndexLookups(t *testing.T) {
 		-1.0 * blockNeg111.BlockSize,
 		-1.0 * blockNeg111.BlockSize,
 	}
-	assert.NotNil(t, blockNeg111.getVoxelPtrByCoordinates(pointInNeg111))
 	voxelIndex = blockNeg111.computeTruncatedVoxelIndexFromCoordinates(pointInNeg111)
 	assert.Equal(t, IndexType{0, 0, 0}, voxelIndex)
 	pointIn000center = blockNeg111.computeCoordinatesFromVoxelIndex(voxelIndex)
@@ -142,7 +140,6 @@ func TestTsdfMapIndexLookups(t *testing.T) {
 
 	// Voxel 7 7 7
 	pointInNeg111 = Point{-kEpsilon, -kEpsilon, -kEpsilon}
-	assert.NotNil(t, blockNeg111.getVoxelPtrByCoordinates(pointInNeg111))
 	voxelIndex = blockNeg111.computeTruncatedVoxelIndexFromCoordinates(pointInNeg111)
 	assert.Equal(t, IndexType{7, 7, 7}, voxelIndex)
 	pointIn777center := blockNeg111.computeCoordinatesFromVoxelIndex(voxelIndex)
@@ -165,7 +162,6 @@ func TestTsdfMapIndexLookups(t *testing.T) {
 		IndexType{-1, -1, 0},
 		getBlockIndexFromCoordinates(pointInNeg110, tsdfLayer.BlockSizeInv),
 	)
-	assert.NotNil(t, blockNeg1Neg1Pos0.getVoxelPtrByCoordinates(pointInNeg110))
 	voxelIndex = blockNeg1Neg1Pos0.computeTruncatedVoxelIndexFromCoordinates(pointInNeg110)
 	assert.Equal(t, IndexType{3, 6, 5}, voxelIndex)
 	pointInNeg110center := blockNeg1Neg1Pos0.computeCoordinatesFromVoxelIndex(voxelIndex)
@@ -191,3 +187,118 @@ func TestComputeBlockIndexFromOriginFromBlockIndex(t *testing.T) {
 		}
 	}
 }
+
+func TestGetSurfacePoints(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	block := setTestVoxel(tsdfLayer, IndexType{1, 2, 3}, 0.01, 1.0, ColorRed)
+	setTestVoxel(tsdfLayer, IndexType{1, 2, 4}, 0.3, 1.0, Color{})
+	setTestVoxel(tsdfLayer, IndexType{1, 2, 5}, 0.0, 0.01, Color{})
+
+	pointCloud := GetSurfacePoints(tsdfLayer, 0.05, 0.1)
+	assert.Len(t, pointCloud.Points, 1)
+	assert.Equal(t, block.computeCoordinatesFromVoxelIndex(IndexType{1, 2, 3}), pointCloud.Points[0])
+	assert.Equal(t, ColorRed, pointCloud.Colors[0])
+}
+
+func TestGetTsdfSlice(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	for z := -10; z < 10; z++ {
+		setTestVoxel(tsdfLayer, IndexType{0, 0, z}, float64(z), 1.0, Color{})
+	}
+
+	points, distances := GetTsdfSlice(tsdfLayer, 2, -0.25, 0.1)
+	assert.Len(t, points, 1)
+	assert.Equal(t, -3.0, distances[0])
+	assert.InDelta(t, -0.25, points[0][2], kEpsilon)
+}
+
+// setTestVoxel sets the voxel at the global voxel index and returns its block.
+func setTestVoxel(
+	layer *TsdfLayer,
+	globalVoxelIndex IndexType,
+	distance float64,
+	weight float64,
+	color Color,
+) *TsdfBlock {
+	var voxel TsdfVoxel
+	voxel.setDistance(distance)
+	voxel.setWeight(weight)
+	voxel.setColor(color)
+	block, voxelIndex := getBlockAndVoxelIndexFromGlobalVoxelIndex(layer, globalVoxelIndex)
+	block.setVoxel(voxelIndex, voxel)
+	return block
+}
+
+// getTestVoxel returns a copy of the observed voxel at the global voxel index.
+func getTestVoxel(t *testing.T, layer *TsdfLayer, globalVoxelIndex IndexType) TsdfVoxel {
+	_, voxel, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(layer, globalVoxelIndex)
+	assert.True(t, ok, "voxel %v should be observed", globalVoxelIndex)
+	return voxel
+}
+
+func TestVoxelArrayIndex(t *testing.T) {
+	voxels := newVoxelArray[TsdfVoxel](8)
+	assert.Equal(t, 512, voxels.len())
+	for j := 0; j < voxels.len(); j++ {
+		assert.Equal(t, j, voxels.linearIndex(voxels.voxelIndex(j)))
+	}
+	assert.Equal(t, IndexType{1, 2, 3}, voxels.voxelIndex(voxels.linearIndex(IndexType{1, 2, 3})))
+}
+
+func TestTsdfBlockObservedVoxels(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	block := setTestVoxel(tsdfLayer, IndexType{1, 2, 3}, 0.05, 2.0, ColorRed)
+	assert.Len(t, block.getVoxels(), 1)
+
+	voxel, ok := block.getVoxelIfExists(IndexType{1, 2, 3})
+	assert.True(t, ok)
+	assert.InEpsilon(t, 0.05, voxel.getDistance(), kEpsilon)
+	assert.Equal(t, 2.0, voxel.getWeight())
+	assert.Equal(t, ColorRed, voxel.getColor())
+
+	// Unobserved and out of range voxels do not exist.
+	_, ok = block.getVoxelIfExists(IndexType{1, 2, 4})
+	assert.False(t, ok)
+	_, ok = block.getVoxelIfExists(IndexType{8, 0, 0})
+	assert.False(t, ok)
+}
+
+func TestTsdfVoxelSize(t *testing.T) {
+	assert.Equal(t, uintptr(12), unsafe.Sizeof(TsdfVoxel{}))
+}
+
+// BenchmarkTsdfLayerMemory reports the heap used by a 100 m³ map at 5 cm resolution.
+func BenchmarkTsdfLayerMemory(b *testing.B) {
+	const voxelSize = 0.05
+	// 5 m x 5 m x 4 m
+	voxelCounts := IndexType{100, 100, 80}
+	var voxel TsdfVoxel
+	voxel.setDistance(0.1)
+	voxel.setWeight(1.0)
+
+	var layer *TsdfLayer
+	var before, after runtime.MemStats
+	for n := 0; n < b.N; n++ {
+		layer = nil
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		layer = NewTsdfLayer(voxelSize, 16)
+		for x := 0; x < voxelCounts[0]; x++ {
+			for y := 0; y < voxelCounts[1]; y++ {
+				for z := 0; z < voxelCounts[2]; z++ {
+					block, voxelIndex := getBlockAndVoxelIndexFromGlobalVoxelIndex(layer, IndexType{x, y, z})
+					block.setVoxel(voxelIndex, voxel)
+				}
+			}
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+	}
+	voxels := float64(voxelCounts[0] * voxelCounts[1] * voxelCounts[2])
+	bytes := float64(after.HeapAlloc) - float64(before.HeapAlloc)
+	b.ReportMetric(bytes/(1<<20), "MiB/map")
+	b.ReportMetric(bytes/voxels, "B/voxel")
+	b.ReportMetric(float64(layer.GetBlockCount()), "blocks")
+}
@@ -0,0 +1,56 @@
+package voxblox
+
+import "math"
+
+// GetSurfacePoints returns the centers and colors of voxels close to the surface.
+// A voxel is on the surface if its absolute distance is below surfaceDistance
+// and its weight is at least minWeight.
+// Thread-safe.
+func GetSurfacePoints(layer *TsdfLayer, surfaceDistance, minWeight float64) PointCloud {
+	pointCloud := PointCloud{}
+	for _, block := range layer.getBlocks() {
+		for voxelIndex, voxel := range block.getVoxels() {
+			if voxel.getWeight() < minWeight || math.Abs(voxel.getDistance()) > surfaceDistance {
+				continue
+			}
+			pointCloud.Points = append(
+				pointCloud.Points,
+				block.computeCoordinatesFromVoxelIndex(voxelIndex),
+			)
+			pointCloud.Colors = append(pointCloud.Colors, voxel.getColor())
+		}
+	}
+	pointCloud.Width = len(pointCloud.Points)
+	pointCloud.Height = 1
+	return pointCloud
+}
+
+// GetTsdfSlice returns the centers and distances of the voxels intersecting
+// the axis-aligned plane at the given level.
+// Axis 0, 1 and 2 slice along X, Y and Z respectively.
+// Thread-safe.
+func GetTsdfSlice(
+	layer *TsdfLayer,
+	axis int,
+	level float64,
+	minWeight float64,
+) ([]Point, []float64) {
+	var points []Point
+	var distances []float64
+	globalLevel := int(math.Floor(level*layer.VoxelSizeInv + kEpsilon))
+	for _, block := range layer.getBlocks() {
+		// Skip blocks that do not intersect the slice.
+		localLevel := globalLevel - block.Index[axis]*layer.VoxelsPerSide
+		if localLevel < 0 || localLevel >= layer.VoxelsPerSide {
+			continue
+		}
+		for voxelIndex, voxel := range block.getVoxels() {
+			if voxelIndex[axis] != localLevel || voxel.getWeight() < minWeight {
+				continue
+			}
+			points = append(points, block.computeCoordinatesFromVoxelIndex(voxelIndex))
+			distances = append(distances, voxel.getDistance())
+		}
+	}
+	return points, distances
+}
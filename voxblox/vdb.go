@@ -0,0 +1,725 @@
+package voxblox
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+)
+
+// An OpenVDB file (.vdb) holds FloatGrid trees of a root node, internal nodes of 32³ and 16³ children and leaf nodes
+// of 8³ voxels. The TSDF is written as a level set grid whose voxel (i, j, k) is the voxel of global index (i, j, k),
+// uncompressed except for the inactive values of the nodes (active mask compression).
+const (
+	kVdbMagic int64 = 0x56444220
+	// kVdbFileVersion is the file format version written by current OpenVDB releases.
+	kVdbFileVersion uint32 = 224
+	// kVdbMinFileVersion is the first file format version with node mask compression.
+	kVdbMinFileVersion      uint32 = 222
+	kVdbLibraryMajorVersion uint32 = 10
+	kVdbLibraryMinorVersion uint32 = 0
+	kVdbFloatGridType              = "Tree_float_5_4_3"
+	kVdbHalfFloatSuffix            = "_HalfFloat"
+	kVdbLevelSetClass              = "level set"
+	kVdbGridName                   = "tsdf"
+)
+
+// Log2 dimensions of the nodes of a FloatGrid tree and log2 sizes in voxels of their children.
+const (
+	kVdbInternal2Log2Dim = 5
+	kVdbInternal1Log2Dim = 4
+	kVdbLeafLog2Dim      = 3
+	kVdbInternal2Total   = 12
+	kVdbInternal1Total   = 7
+	kVdbLeafTotal        = 3
+)
+
+// kVdbCompressActiveMask is the grid compression flag of node values stored without their inactive values.
+// Zip and Blosc compressed grids are not supported.
+const kVdbCompressActiveMask uint32 = 0x2
+
+// Node value metadata, telling which inactive values are stored with the active values of a node.
+const (
+	kVdbNoMaskOrInactiveVals uint8 = iota
+	kVdbNoMaskAndMinusBg
+	kVdbNoMaskAndOneInactiveVal
+	kVdbMaskAndNoInactiveVals
+	kVdbMaskAndOneInactiveVal
+	kVdbMaskAndTwoInactiveVals
+	kVdbNoMaskAndAllVals
+)
+
+// kVdbVoxelWeight is the weight of the voxels read from an OpenVDB file, which has no weights.
+const kVdbVoxelWeight = 1.0
+
+// vdbNodeMask is a bit per child or voxel of a node, in x-major order.
+type vdbNodeMask []uint64
+
+func newVdbNodeMask(log2Dim int) vdbNodeMask {
+	return make(vdbNodeMask, 1<<(3*log2Dim)/64)
+}
+
+func (m vdbNodeMask) isOn(n int) bool {
+	return m[n>>6]&(1<<(n&63)) != 0
+}
+
+func (m vdbNodeMask) setOn(n int) {
+	m[n>>6] |= 1 << (n & 63)
+}
+
+func (m vdbNodeMask) setOff(n int) {
+	m[n>>6] &^= 1 << (n & 63)
+}
+
+func (m vdbNodeMask) countOn() int {
+	count := 0
+	for _, word := range m {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// vdbOffset returns the offset in a node of the given log2 dimension of the child containing a voxel,
+// the children covering 2^childTotal voxels per side.
+func vdbOffset(index IndexType, log2Dim, childTotal int) int {
+	mask := 1<<(log2Dim+childTotal) - 1
+	return (index[0]&mask)>>childTotal<<(2*log2Dim) |
+		(index[1]&mask)>>childTotal<<log2Dim |
+		(index[2]&mask)>>childTotal
+}
+
+// vdbChildOrigin returns the origin of the child at an offset of a node.
+func vdbChildOrigin(origin IndexType, offset, log2Dim, childTotal int) IndexType {
+	mask := 1<<log2Dim - 1
+	return IndexType{
+		origin[0] + (offset>>(2*log2Dim)&mask)<<childTotal,
+		origin[1] + (offset>>log2Dim&mask)<<childTotal,
+		origin[2] + (offset&mask)<<childTotal,
+	}
+}
+
+// vdbNodeOrigin returns the origin of the node covering 2^total voxels per side containing a voxel.
+func vdbNodeOrigin(index IndexType, total int) IndexType {
+	mask := ^(1<<total - 1)
+	return IndexType{index[0] & mask, index[1] & mask, index[2] & mask}
+}
+
+// vdbLeaf is a leaf node of a FloatGrid.
+// Voxels in the narrow band are active, the others hold the background value with the sign of their side.
+type vdbLeaf struct {
+	origin  IndexType
+	values  []float32
+	active  vdbNodeMask
+	outside vdbNodeMask
+}
+
+func newVdbLeaf(origin IndexType, background float32) *vdbLeaf {
+	leaf := &vdbLeaf{
+		origin:  origin,
+		values:  make([]float32, 1<<(3*kVdbLeafLog2Dim)),
+		active:  newVdbNodeMask(kVdbLeafLog2Dim),
+		outside: newVdbNodeMask(kVdbLeafLog2Dim),
+	}
+	for n := range leaf.values {
+		leaf.values[n] = background
+		leaf.outside.setOn(n)
+	}
+	return leaf
+}
+
+// compareVdbLeaves orders leaves as they are visited in the tree.
+func compareVdbLeaves(a, b *vdbLeaf) int {
+	rootA := vdbNodeOrigin(a.origin, kVdbInternal2Total)
+	rootB := vdbNodeOrigin(b.origin, kVdbInternal2Total)
+	if c := slices.Compare(rootA[:], rootB[:]); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(
+		vdbOffset(a.origin, kVdbInternal2Log2Dim, kVdbInternal1Total),
+		vdbOffset(b.origin, kVdbInternal2Log2Dim, kVdbInternal1Total),
+	); c != 0 {
+		return c
+	}
+	return cmp.Compare(
+		vdbOffset(a.origin, kVdbInternal1Log2Dim, kVdbLeafTotal),
+		vdbOffset(b.origin, kVdbInternal1Log2Dim, kVdbLeafTotal),
+	)
+}
+
+// getVdbLeaves returns the leaves of the observed voxels of a TSDF Layer in tree order.
+// Distances are clamped to the background value, voxels at the background distance being inactive.
+// Thread-safe.
+func getVdbLeaves(layer *TsdfLayer, background float64) []*vdbLeaf {
+	leafMap := make(map[IndexType]*vdbLeaf)
+	for _, block := range layer.getBlocks() {
+		blockOrigin := IndexType{
+			block.Index[0] * layer.VoxelsPerSide,
+			block.Index[1] * layer.VoxelsPerSide,
+			block.Index[2] * layer.VoxelsPerSide,
+		}
+		block.RLock()
+		for j, voxel := range block.voxels.voxels {
+			if !voxel.isObserved() {
+				continue
+			}
+			index := addIndex(blockOrigin, block.voxels.voxelIndex(j))
+			leafOrigin := vdbNodeOrigin(index, kVdbLeafTotal)
+			leaf, ok := leafMap[leafOrigin]
+			if !ok {
+				leaf = newVdbLeaf(leafOrigin, float32(background))
+				leafMap[leafOrigin] = leaf
+			}
+			n := vdbOffset(index, kVdbLeafLog2Dim, 0)
+			distance := voxel.getDistance()
+			switch {
+			case distance >= background:
+			case distance <= -background:
+				leaf.values[n] = -float32(background)
+				leaf.outside.setOff(n)
+			default:
+				leaf.values[n] = float32(distance)
+				leaf.active.setOn(n)
+				leaf.outside.setOff(n)
+			}
+		}
+		block.RUnlock()
+	}
+
+	leaves := make([]*vdbLeaf, 0, len(leafMap))
+	for _, leaf := range leafMap {
+		leaves = append(leaves, leaf)
+	}
+	slices.SortFunc(leaves, compareVdbLeaves)
+	return leaves
+}
+
+// splitVdbLeaves splits leaves in tree order into the runs of leaves of the same node covering 2^total voxels per side.
+func splitVdbLeaves(leaves []*vdbLeaf, total int) [][]*vdbLeaf {
+	var runs [][]*vdbLeaf
+	for start := 0; start < len(leaves); {
+		origin := vdbNodeOrigin(leaves[start].origin, total)
+		end := start + 1
+		for end < len(leaves) && vdbNodeOrigin(leaves[end].origin, total) == origin {
+			end++
+		}
+		runs = append(runs, leaves[start:end])
+		start = end
+	}
+	return runs
+}
+
+// vdbWriter writes little endian values and counts the written bytes.
+// The first error is kept and stops all further writes.
+type vdbWriter struct {
+	w   *bufio.Writer
+	pos int64
+	err error
+}
+
+func (w *vdbWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.pos += int64(n)
+	return n, err
+}
+
+func (w *vdbWriter) write(data any) {
+	if w.err == nil {
+		w.err = binary.Write(w, binary.LittleEndian, data)
+	}
+}
+
+func (w *vdbWriter) writeString(s string) {
+	w.write(uint32(len(s)))
+	w.write([]byte(s))
+}
+
+// writeMetadata writes a metadata entry of a string or fixed-size value.
+func (w *vdbWriter) writeMetadata(name, typeName string, value any) {
+	w.writeString(name)
+	w.writeString(typeName)
+	if s, ok := value.(string); ok {
+		w.writeString(s)
+		return
+	}
+	w.write(uint32(binary.Size(value)))
+	w.write(value)
+}
+
+// writeInternalTopology writes the masks and tile values of an internal node and the topology of its children.
+// All tiles are inactive background tiles.
+func (w *vdbWriter) writeInternalTopology(leaves []*vdbLeaf, log2Dim, childTotal int) {
+	children := splitVdbLeaves(leaves, childTotal)
+	childMask := newVdbNodeMask(log2Dim)
+	for _, child := range children {
+		childMask.setOn(vdbOffset(child[0].origin, log2Dim, childTotal))
+	}
+	w.write([]uint64(childMask))
+	w.write([]uint64(newVdbNodeMask(log2Dim)))
+	w.write(kVdbNoMaskOrInactiveVals)
+	for _, child := range children {
+		if childTotal == kVdbLeafTotal {
+			w.write([]uint64(child[0].active))
+			continue
+		}
+		w.writeInternalTopology(child, kVdbInternal1Log2Dim, kVdbLeafTotal)
+	}
+}
+
+// writeLeafBuffer writes the values of a leaf, the inactive values being selected between -background and
+// background by the outside mask.
+func (w *vdbWriter) writeLeafBuffer(leaf *vdbLeaf) {
+	w.write([]uint64(leaf.active))
+	w.write(kVdbMaskAndNoInactiveVals)
+	w.write([]uint64(leaf.outside))
+	values := make([]float32, 0, leaf.active.countOn())
+	for n, value := range leaf.values {
+		if leaf.active.isOn(n) {
+			values = append(values, value)
+		}
+	}
+	w.write(values)
+}
+
+// newVdbUuid returns a random UUID string.
+func newVdbUuid() (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return "", err
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
+}
+
+// WriteTsdfLayerVdb writes the observed voxels of a TSDF Layer to an OpenVDB file as a float level set grid,
+// readable by Houdini, Blender and the OpenVDB tools.
+// Distances are clamped to the background value, typically the truncation distance, and only voxels closer to the
+// surface are active. Weights and colors are not written.
+// Thread-safe.
+func WriteTsdfLayerVdb(layer *TsdfLayer, fileName string, background float64) error {
+	if background <= 0 {
+		return fmt.Errorf("background %f must be positive", background)
+	}
+	uuid, err := newVdbUuid()
+	if err != nil {
+		return err
+	}
+	leaves := getVdbLeaves(layer, background)
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := &vdbWriter{w: bufio.NewWriter(file)}
+
+	// Header with grid offsets, file metadata and the grid descriptor.
+	w.write(kVdbMagic)
+	w.write(kVdbFileVersion)
+	w.write(kVdbLibraryMajorVersion)
+	w.write(kVdbLibraryMinorVersion)
+	w.write(uint8(1))
+	w.write([]byte(uuid))
+	w.write(uint32(1))
+	w.writeMetadata("creator", "string", "go-voxblox")
+	w.write(int32(1))
+	w.writeString(kVdbGridName)
+	w.writeString(kVdbFloatGridType)
+	w.writeString("")
+	offsetPos := w.pos
+	w.write([3]int64{})
+	gridPos := w.pos
+
+	// Grid compression, metadata and transform.
+	w.write(kVdbCompressActiveMask)
+	activeCount := int64(0)
+	bboxMin := [3]int32{math.MaxInt32, math.MaxInt32, math.MaxInt32}
+	bboxMax := [3]int32{math.MinInt32, math.MinInt32, math.MinInt32}
+	for _, leaf := range leaves {
+		for n := range leaf.values {
+			if !leaf.active.isOn(n) {
+				continue
+			}
+			activeCount++
+			index := vdbChildOrigin(leaf.origin, n, kVdbLeafLog2Dim, 0)
+			for k := range index {
+				bboxMin[k] = min(bboxMin[k], int32(index[k]))
+				bboxMax[k] = max(bboxMax[k], int32(index[k]))
+			}
+		}
+	}
+	if activeCount > 0 {
+		w.write(uint32(5))
+		w.writeMetadata("file_bbox_min", "vec3i", bboxMin)
+		w.writeMetadata("file_bbox_max", "vec3i", bboxMax)
+	} else {
+		w.write(uint32(3))
+	}
+	w.writeMetadata("class", "string", kVdbLevelSetClass)
+	w.writeMetadata("file_voxel_count", "int64", activeCount)
+	w.writeMetadata("name", "string", kVdbGridName)
+
+	// Voxel centers lie half a voxel from the origin of their index.
+	voxelSize := layer.VoxelSize
+	w.writeString("UniformScaleTranslateMap")
+	for _, value := range []float64{
+		0.5 * voxelSize,
+		voxelSize,
+		voxelSize,
+		1 / voxelSize,
+		1 / (voxelSize * voxelSize),
+		0.5 / voxelSize,
+	} {
+		w.write([3]float64{value, value, value})
+	}
+
+	// Topology, a root node without tiles.
+	roots := splitVdbLeaves(leaves, kVdbInternal2Total)
+	w.write(int32(1))
+	w.write(float32(background))
+	w.write(uint32(0))
+	w.write(uint32(len(roots)))
+	for _, root := range roots {
+		origin := vdbNodeOrigin(root[0].origin, kVdbInternal2Total)
+		w.write([3]int32{int32(origin[0]), int32(origin[1]), int32(origin[2])})
+		w.writeInternalTopology(root, kVdbInternal2Log2Dim, kVdbInternal1Total)
+	}
+
+	// Leaf buffers.
+	blockPos := w.pos
+	for _, leaf := range leaves {
+		w.writeLeafBuffer(leaf)
+	}
+	endPos := w.pos
+
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	offsets := make([]byte, 0, 3*8)
+	for _, pos := range []int64{gridPos, blockPos, endPos} {
+		offsets = binary.LittleEndian.AppendUint64(offsets, uint64(pos))
+	}
+	if _, err := file.WriteAt(offsets, offsetPos); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// vdbReader reads little endian values.
+// The first error is kept and stops all further reads.
+type vdbReader struct {
+	file *os.File
+	r    *bufio.Reader
+	err  error
+}
+
+func (r *vdbReader) read(data any) {
+	if r.err == nil {
+		r.err = binary.Read(r.r, binary.LittleEndian, data)
+	}
+}
+
+func (r *vdbReader) readString() string {
+	var size uint32
+	r.read(&size)
+	if r.err != nil {
+		return ""
+	}
+	s := make([]byte, size)
+	_, r.err = io.ReadFull(r.r, s)
+	return string(s)
+}
+
+func (r *vdbReader) skip(size int64) {
+	if r.err == nil {
+		_, r.err = r.r.Discard(int(size))
+	}
+}
+
+func (r *vdbReader) seek(pos int64) {
+	if r.err == nil {
+		_, r.err = r.file.Seek(pos, io.SeekStart)
+		r.r.Reset(r.file)
+	}
+}
+
+// readStringMetadata reads a metadata map, returning its string entries.
+func (r *vdbReader) readStringMetadata() map[string]string {
+	var count uint32
+	r.read(&count)
+	metadata := make(map[string]string)
+	for j := uint32(0); j < count && r.err == nil; j++ {
+		name := r.readString()
+		if r.readString() == "string" {
+			metadata[name] = r.readString()
+			continue
+		}
+		var size uint32
+		r.read(&size)
+		r.skip(int64(size))
+	}
+	return metadata
+}
+
+// readValues reads the compressed values of a node, restoring the inactive values.
+func (r *vdbReader) readValues(valueMask vdbNodeMask, compression uint32, background float32) []float32 {
+	var metadata uint8
+	r.read(&metadata)
+	inactive := [2]float32{-background, background}
+	if metadata == kVdbNoMaskOrInactiveVals {
+		inactive[0] = background
+	}
+	switch metadata {
+	case kVdbNoMaskAndOneInactiveVal, kVdbMaskAndOneInactiveVal:
+		r.read(&inactive[0])
+	case kVdbMaskAndTwoInactiveVals:
+		r.read(&inactive)
+	}
+	selectionMask := make(vdbNodeMask, len(valueMask))
+	switch metadata {
+	case kVdbMaskAndNoInactiveVals, kVdbMaskAndOneInactiveVal, kVdbMaskAndTwoInactiveVals:
+		r.read([]uint64(selectionMask))
+	}
+
+	values := make([]float32, len(valueMask)*64)
+	if compression&kVdbCompressActiveMask == 0 || metadata == kVdbNoMaskAndAllVals {
+		r.read(values)
+		return values
+	}
+	activeValues := make([]float32, valueMask.countOn())
+	r.read(activeValues)
+	for n, k := 0, 0; n < len(values); n++ {
+		switch {
+		case valueMask.isOn(n):
+			values[n] = activeValues[k]
+			k++
+		case selectionMask.isOn(n):
+			values[n] = inactive[1]
+		default:
+			values[n] = inactive[0]
+		}
+	}
+	return values
+}
+
+// readInternalTopology reads an internal node, appending the leaves with their active masks in tree order.
+// Tiles are skipped.
+func (r *vdbReader) readInternalTopology(
+	origin IndexType,
+	log2Dim, childTotal int,
+	compression uint32,
+	background float32,
+	leaves *[]*vdbLeaf,
+) {
+	childMask := newVdbNodeMask(log2Dim)
+	valueMask := newVdbNodeMask(log2Dim)
+	r.read([]uint64(childMask))
+	r.read([]uint64(valueMask))
+	r.readValues(valueMask, compression, background)
+	for n := 0; n < len(childMask)*64 && r.err == nil; n++ {
+		if !childMask.isOn(n) {
+			continue
+		}
+		childOrigin := vdbChildOrigin(origin, n, log2Dim, childTotal)
+		if childTotal == kVdbLeafTotal {
+			leaf := &vdbLeaf{origin: childOrigin, active: newVdbNodeMask(kVdbLeafLog2Dim)}
+			r.read([]uint64(leaf.active))
+			*leaves = append(*leaves, leaf)
+			continue
+		}
+		r.readInternalTopology(childOrigin, kVdbInternal1Log2Dim, kVdbLeafTotal, compression, background, leaves)
+	}
+}
+
+// vdbGridDescriptor locates a grid in an OpenVDB file.
+type vdbGridDescriptor struct {
+	name     string
+	gridType string
+	gridPos  int64
+	blockPos int64
+}
+
+// ReadTsdfLayerVdb reads the first float level set grid of an OpenVDB file into a TSDF Layer.
+// The active voxels become observed voxels of weight 1 without color, inactive voxels and tiles are skipped.
+// The voxel size of the grid must match the layer. Grids whose voxel centers are not on the voxel centers of the
+// layer are shifted by up to half a voxel.
+// Blocks in the file replace existing blocks with the same index.
+// Thread-safe.
+func ReadTsdfLayerVdb(layer *TsdfLayer, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := &vdbReader{file: file, r: bufio.NewReader(file)}
+
+	var magic int64
+	var version uint32
+	var libraryVersion [2]uint32
+	var hasGridOffsets uint8
+	var uuid [36]byte
+	r.read(&magic)
+	if r.err == nil && magic != kVdbMagic {
+		return fmt.Errorf("%s is not an OpenVDB file", fileName)
+	}
+	r.read(&version)
+	if r.err == nil && version < kVdbMinFileVersion {
+		return fmt.Errorf("unsupported OpenVDB file version %d", version)
+	}
+	r.read(&libraryVersion)
+	r.read(&hasGridOffsets)
+	r.read(&uuid)
+	if r.err == nil && hasGridOffsets == 0 {
+		return fmt.Errorf("%s has no grid offsets", fileName)
+	}
+	r.readStringMetadata()
+
+	var gridCount int32
+	r.read(&gridCount)
+	var descriptors []vdbGridDescriptor
+	for j := int32(0); j < gridCount && r.err == nil; j++ {
+		descriptor := vdbGridDescriptor{name: r.readString(), gridType: r.readString()}
+		instanceParent := r.readString()
+		var endPos int64
+		r.read(&descriptor.gridPos)
+		r.read(&descriptor.blockPos)
+		r.read(&endPos)
+		if instanceParent == "" {
+			descriptors = append(descriptors, descriptor)
+		}
+		r.seek(endPos)
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	for _, descriptor := range descriptors {
+		if descriptor.gridType == kVdbFloatGridType+kVdbHalfFloatSuffix {
+			return fmt.Errorf("grid %s is saved as half float, which is not supported", descriptor.name)
+		}
+		if descriptor.gridType != kVdbFloatGridType {
+			continue
+		}
+		r.seek(descriptor.gridPos)
+		var compression uint32
+		r.read(&compression)
+		metadata := r.readStringMetadata()
+		if r.err != nil {
+			return r.err
+		}
+		if metadata["class"] != kVdbLevelSetClass {
+			continue
+		}
+		if compression&^kVdbCompressActiveMask != 0 {
+			return fmt.Errorf("grid %s has unsupported compression %#x", descriptor.name, compression)
+		}
+		blocks, err := r.readGrid(layer, descriptor, compression)
+		if err != nil {
+			return err
+		}
+
+		// Only modify the layer once the whole grid has been read.
+		for _, block := range blocks {
+			layer.blocks.set(block.Index, block)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s has no float level set grid", fileName)
+}
+
+// readGrid reads the transform, topology and leaf buffers of a level set grid into new TSDF blocks.
+func (r *vdbReader) readGrid(
+	layer *TsdfLayer,
+	descriptor vdbGridDescriptor,
+	compression uint32,
+) (map[IndexType]*TsdfBlock, error) {
+	// Transform, the inverse scales are skipped.
+	mapType := r.readString()
+	var translation, scale Point
+	switch mapType {
+	case "UniformScaleTranslateMap", "ScaleTranslateMap":
+		r.read(&translation)
+	case "UniformScaleMap", "ScaleMap":
+	default:
+		if r.err == nil {
+			return nil, fmt.Errorf("grid %s has unsupported transform %s", descriptor.name, mapType)
+		}
+	}
+	r.read(&scale)
+	r.skip(4 * 3 * 8)
+	if r.err != nil {
+		return nil, r.err
+	}
+	var offset IndexType
+	for k := range offset {
+		if !isSameVoxelSize(scale[k], layer.VoxelSize) {
+			return nil, fmt.Errorf("layer mismatch: grid %s has voxel size %v", descriptor.name, scale)
+		}
+		offset[k] = int(math.Round(translation[k]/layer.VoxelSize - 0.5))
+	}
+
+	// Topology.
+	var bufferCount int32
+	var background float32
+	var tileCount, childCount uint32
+	r.read(&bufferCount)
+	r.read(&background)
+	r.read(&tileCount)
+	r.read(&childCount)
+	r.skip(int64(tileCount) * (3*4 + 4 + 1))
+	var leaves []*vdbLeaf
+	for j := uint32(0); j < childCount && r.err == nil; j++ {
+		var origin [3]int32
+		r.read(&origin)
+		r.readInternalTopology(
+			IndexType{int(origin[0]), int(origin[1]), int(origin[2])},
+			kVdbInternal2Log2Dim,
+			kVdbInternal1Total,
+			compression,
+			background,
+			&leaves,
+		)
+	}
+
+	// Leaf buffers.
+	r.seek(descriptor.blockPos)
+	blocks := make(map[IndexType]*TsdfBlock)
+	for _, leaf := range leaves {
+		// The value mask repeats the topology.
+		r.skip(int64(len(leaf.active)) * 8)
+		values := r.readValues(leaf.active, compression, background)
+		if r.err != nil {
+			return nil, r.err
+		}
+		for n, value := range values {
+			if !leaf.active.isOn(n) {
+				continue
+			}
+			index := addIndex(vdbChildOrigin(leaf.origin, n, kVdbLeafLog2Dim, 0), offset)
+			blockIndex := getBlockIndexFromGlobalVoxelIndex(index, layer.VoxelsPerSideInv)
+			block, ok := blocks[blockIndex]
+			if !ok {
+				block = NewTsdfBlock(
+					layer,
+					blockIndex,
+					getOriginPointFromGridIndex(blockIndex, layer.BlockSize),
+				)
+				blocks[blockIndex] = block
+			}
+			var voxel TsdfVoxel
+			voxel.setDistance(float64(value))
+			voxel.setWeight(kVdbVoxelWeight)
+			block.setVoxel(getLocalFromGlobalVoxelIndex(index, blockIndex, layer.VoxelsPerSide), voxel)
+		}
+	}
+	return blocks, r.err
+}
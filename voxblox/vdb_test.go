@@ -0,0 +1,199 @@
+package voxblox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestVdbLayer returns a layer with a ramp of distances across negative and positive voxel indexes and a
+// voxel in another root node.
+func getTestVdbLayer() (*TsdfLayer, map[IndexType]float64) {
+	layer := NewTsdfLayer(0.1, 8)
+	distances := make(map[IndexType]float64)
+	for x := -12; x < 12; x++ {
+		for y := -3; y < 3; y++ {
+			for z := 0; z < 3; z++ {
+				distances[IndexType{x, y, z}] = float64(x) * 0.05
+			}
+		}
+	}
+	distances[IndexType{5000, -1, 2}] = 0.1
+	for globalVoxelIndex, distance := range distances {
+		setTestVoxel(layer, globalVoxelIndex, distance, 2, ColorRed)
+	}
+	return layer, distances
+}
+
+func TestWriteReadTsdfLayerVdb(t *testing.T) {
+	layer, distances := getTestVdbLayer()
+	fileName := filepath.Join(t.TempDir(), "map.vdb")
+	assert.NoError(t, WriteTsdfLayerVdb(layer, fileName, 0.4))
+
+	// Layers with other voxels per side read the same voxels.
+	for _, vps := range []int{8, 16} {
+		loadedLayer := NewTsdfLayer(0.1, vps)
+		assert.NoError(t, ReadTsdfLayerVdb(loadedLayer, fileName))
+		for globalVoxelIndex, distance := range distances {
+			_, voxel, ok := getBlockAndVoxelFromGlobalVoxelIndexIfExists(loadedLayer, globalVoxelIndex)
+			if math.Abs(distance) >= 0.4-kEpsilon {
+				assert.False(t, ok, "voxel %v at the background distance should be inactive", globalVoxelIndex)
+				continue
+			}
+			assert.True(t, ok, "voxel %v should be active", globalVoxelIndex)
+			assert.Equal(t, float64(float32(distance)), voxel.getDistance())
+			assert.Equal(t, kVdbVoxelWeight, voxel.getWeight())
+		}
+	}
+
+	// Layers with a different voxel size are rejected.
+	assert.Error(t, ReadTsdfLayerVdb(NewTsdfLayer(0.05, 8), fileName))
+	assert.Error(t, WriteTsdfLayerVdb(layer, fileName, 0))
+}
+
+func TestWriteTsdfLayerVdbLayout(t *testing.T) {
+	layer, _ := getTestVdbLayer()
+	fileName := filepath.Join(t.TempDir(), "map.vdb")
+	assert.NoError(t, WriteTsdfLayerVdb(layer, fileName, 0.4))
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+
+	file, err := os.Open(fileName)
+	assert.NoError(t, err)
+	defer file.Close()
+	r := &vdbReader{file: file, r: bufio.NewReader(file)}
+	var magic int64
+	var version uint32
+	var libraryVersion [2]uint32
+	var hasGridOffsets uint8
+	var uuid [36]byte
+	r.read(&magic)
+	r.read(&version)
+	r.read(&libraryVersion)
+	r.read(&hasGridOffsets)
+	r.read(&uuid)
+	assert.Equal(t, []byte{0x20, 0x42, 0x44, 0x56, 0, 0, 0, 0}, data[:8])
+	assert.Equal(t, uint32(224), version)
+	assert.Equal(t, uint8(1), hasGridOffsets)
+	assert.Equal(t, byte('-'), uuid[8])
+	assert.Equal(t, map[string]string{"creator": "go-voxblox"}, r.readStringMetadata())
+
+	var gridCount int32
+	r.read(&gridCount)
+	assert.Equal(t, int32(1), gridCount)
+	assert.Equal(t, "tsdf", r.readString())
+	assert.Equal(t, "Tree_float_5_4_3", r.readString())
+	assert.Equal(t, "", r.readString())
+	var offsets [3]int64
+	r.read(&offsets)
+	assert.NoError(t, r.err)
+	assert.Equal(t, int64(len(data)), offsets[2])
+
+	r.seek(offsets[0])
+	var compression uint32
+	r.read(&compression)
+	assert.Equal(t, kVdbCompressActiveMask, compression)
+	metadata := r.readStringMetadata()
+	assert.Equal(t, "level set", metadata["class"])
+	assert.Equal(t, "tsdf", metadata["name"])
+	assert.Equal(t, "UniformScaleTranslateMap", r.readString())
+	var translation, scale Point
+	r.read(&translation)
+	r.read(&scale)
+	assert.Equal(t, Point{0.05, 0.05, 0.05}, translation)
+	assert.Equal(t, Point{0.1, 0.1, 0.1}, scale)
+
+	// Root children for the four quadrants of the ramp and the distant voxel, in index order.
+	r.skip(4 * 3 * 8)
+	var bufferCount int32
+	var background float32
+	var tileCount, childCount uint32
+	var origin [3]int32
+	r.read(&bufferCount)
+	r.read(&background)
+	r.read(&tileCount)
+	r.read(&childCount)
+	r.read(&origin)
+	assert.NoError(t, r.err)
+	assert.Equal(t, float32(0.4), background)
+	assert.Equal(t, uint32(0), tileCount)
+	assert.Equal(t, uint32(5), childCount)
+	assert.Equal(t, [3]int32{-4096, -4096, 0}, origin)
+}
+
+func TestVdbReadValues(t *testing.T) {
+	valueMask := newVdbNodeMask(kVdbLeafLog2Dim)
+	valueMask.setOn(1)
+	valueMask.setOn(100)
+	selectionMask := newVdbNodeMask(kVdbLeafLog2Dim)
+	selectionMask.setOn(2)
+	background := float32(0.3)
+
+	readValues := func(compression uint32, data ...any) []float32 {
+		var buffer bytes.Buffer
+		for _, value := range data {
+			assert.NoError(t, binary.Write(&buffer, binary.LittleEndian, value))
+		}
+		r := &vdbReader{r: bufio.NewReader(&buffer)}
+		values := r.readValues(valueMask, compression, background)
+		assert.NoError(t, r.err)
+		_, err := r.r.ReadByte()
+		assert.Error(t, err, "all values should be read")
+		return values
+	}
+	activeValues := []float32{0.1, -0.2}
+
+	values := readValues(kVdbCompressActiveMask, kVdbNoMaskOrInactiveVals, activeValues)
+	assert.Equal(t, []float32{0.3, 0.1, 0.3}, values[:3])
+	assert.Equal(t, float32(-0.2), values[100])
+
+	values = readValues(kVdbCompressActiveMask, kVdbNoMaskAndMinusBg, activeValues)
+	assert.Equal(t, []float32{-0.3, 0.1, -0.3}, values[:3])
+
+	values = readValues(kVdbCompressActiveMask, kVdbMaskAndNoInactiveVals, []uint64(selectionMask), activeValues)
+	assert.Equal(t, []float32{-0.3, 0.1, 0.3}, values[:3])
+
+	values = readValues(
+		kVdbCompressActiveMask,
+		kVdbMaskAndTwoInactiveVals,
+		[2]float32{1, 2},
+		[]uint64(selectionMask),
+		activeValues,
+	)
+	assert.Equal(t, []float32{1, 0.1, 2}, values[:3])
+
+	allValues := make([]float32, 512)
+	allValues[100] = 5
+	values = readValues(0, kVdbNoMaskAndAllVals, allValues)
+	assert.Equal(t, allValues, values)
+}
+
+func TestVdbOffset(t *testing.T) {
+	for _, index := range []IndexType{{0, 0, 0}, {-1, 7, 130}, {-4097, 4095, 5000}} {
+		root := vdbNodeOrigin(index, kVdbInternal2Total)
+		internal2 := vdbChildOrigin(
+			root,
+			vdbOffset(index, kVdbInternal2Log2Dim, kVdbInternal1Total),
+			kVdbInternal2Log2Dim,
+			kVdbInternal1Total,
+		)
+		assert.Equal(t, vdbNodeOrigin(index, kVdbInternal1Total), internal2)
+		leaf := vdbChildOrigin(
+			internal2,
+			vdbOffset(index, kVdbInternal1Log2Dim, kVdbLeafTotal),
+			kVdbInternal1Log2Dim,
+			kVdbLeafTotal,
+		)
+		assert.Equal(t, vdbNodeOrigin(index, kVdbLeafTotal), leaf)
+		voxel := vdbChildOrigin(leaf, vdbOffset(index, kVdbLeafLog2Dim, 0), kVdbLeafLog2Dim, 0)
+		assert.Equal(t, index, voxel)
+	}
+	// Leaf voxels are in x-major order.
+	assert.Equal(t, 1<<6|2<<3|3, vdbOffset(IndexType{1, 2, 3}, kVdbLeafLog2Dim, 0))
+}
@@ -0,0 +1,176 @@
+package voxblox
+
+import (
+	"math"
+	"slices"
+	"sync"
+)
+
+// voteLabel is the type of the labels voted for in a voteLayer.
+type voteLabel interface {
+	~uint16 | ~uint32
+}
+
+// voteBin holds the votes for a label.
+type voteBin[L voteLabel] struct {
+	label L
+	votes uint32
+}
+
+// voteVoxel is the vote histogram of the labels observed in a voxel.
+// It keeps a bin for every label voted for, so its majority label is exact.
+// The zero label is never voted for.
+type voteVoxel[L voteLabel] struct {
+	bins []voteBin[L]
+}
+
+// vote adds a vote for the label.
+func (v *voteVoxel[L]) vote(label L) {
+	for k := range v.bins {
+		if v.bins[k].label != label {
+			continue
+		}
+		if v.bins[k].votes == math.MaxUint32 {
+			// Keep the ratios between the bins on saturation.
+			for j := range v.bins {
+				v.bins[j].votes /= 2
+			}
+		}
+		v.bins[k].votes++
+		return
+	}
+	v.bins = append(v.bins, voteBin[L]{label: label, votes: 1})
+}
+
+// getVotes returns the number of votes for the label.
+func (v *voteVoxel[L]) getVotes(label L) int {
+	for _, bin := range v.bins {
+		if bin.label == label {
+			return int(bin.votes)
+		}
+	}
+	return 0
+}
+
+// getLabel returns the label with the most votes, the lowest label on ties.
+// Returns false if the voxel has no votes.
+func (v *voteVoxel[L]) getLabel() (L, bool) {
+	best := -1
+	for k, bin := range v.bins {
+		if bin.votes == 0 {
+			continue
+		}
+		if best < 0 || bin.votes > v.bins[best].votes ||
+			bin.votes == v.bins[best].votes && bin.label < v.bins[best].label {
+			best = k
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	return v.bins[best].label, true
+}
+
+// voteBlock contains a dense array of vote voxels.
+// The block lock protects the voxels.
+type voteBlock[L voteLabel] struct {
+	Index IndexType
+	sync.RWMutex
+	voxels voxelArray[voteVoxel[L]]
+}
+
+// vote adds a vote for the label to the voxel at the given Index.
+// Thread-safe.
+func (b *voteBlock[L]) vote(voxelIndex IndexType, label L) {
+	b.Lock()
+	defer b.Unlock()
+	b.voxels.at(voxelIndex).vote(label)
+}
+
+// getVoxel returns a copy of the voxel at the given Index.
+// Thread-safe.
+func (b *voteBlock[L]) getVoxel(voxelIndex IndexType) voteVoxel[L] {
+	b.RLock()
+	defer b.RUnlock()
+	voxel := b.voxels.at(voxelIndex)
+	return voteVoxel[L]{bins: slices.Clone(voxel.bins)}
+}
+
+// getLabel returns the majority label of the voxel at the given Index.
+// Returns false if the voxel has no votes.
+// Thread-safe.
+func (b *voteBlock[L]) getLabel(voxelIndex IndexType) (L, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	return b.voxels.at(voxelIndex).getLabel()
+}
+
+// voteLayer holds the label votes of the voxels of a TSDF Layer.
+type voteLayer[L voteLabel] struct {
+	VoxelSize        float64
+	VoxelSizeInv     float64
+	VoxelsPerSide    int
+	VoxelsPerSideInv float64
+	blocks           *blockHash[*voteBlock[L]]
+}
+
+// newVoteLayer creates a new voteLayer on the voxel grid of the TSDF Layer.
+func newVoteLayer[L voteLabel](tsdfLayer *TsdfLayer) voteLayer[L] {
+	return voteLayer[L]{
+		VoxelSize:        tsdfLayer.VoxelSize,
+		VoxelSizeInv:     tsdfLayer.VoxelSizeInv,
+		VoxelsPerSide:    tsdfLayer.VoxelsPerSide,
+		VoxelsPerSideInv: tsdfLayer.VoxelsPerSideInv,
+		blocks:           newBlockHash[*voteBlock[L]](),
+	}
+}
+
+// Clear removes all blocks from the layer.
+// Thread-safe.
+func (l *voteLayer[L]) Clear() {
+	l.blocks.clear()
+}
+
+// GetBlockCount returns the number of blocks allocated in the map
+// Thread-safe.
+func (l *voteLayer[L]) GetBlockCount() int {
+	return l.blocks.len()
+}
+
+// vote adds a vote for the label to the voxel at the global voxel index.
+// Allocates the block if needed.
+// Thread-safe.
+func (l *voteLayer[L]) vote(globalVoxelIndex IndexType, label L) {
+	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, l.VoxelsPerSideInv)
+	block := l.blocks.getOrCreate(blockIndex, func() *voteBlock[L] {
+		return &voteBlock[L]{
+			Index:  blockIndex,
+			voxels: newVoxelArray[voteVoxel[L]](l.VoxelsPerSide),
+		}
+	})
+	block.vote(getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, l.VoxelsPerSide), label)
+}
+
+// getVoxel returns a copy of the voxel at the global voxel index.
+// Returns false if its block does not exist.
+// Thread-safe.
+func (l *voteLayer[L]) getVoxel(globalVoxelIndex IndexType) (voteVoxel[L], bool) {
+	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, l.VoxelsPerSideInv)
+	block, ok := l.blocks.get(blockIndex)
+	if !ok {
+		return voteVoxel[L]{}, false
+	}
+	return block.getVoxel(getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, l.VoxelsPerSide)), true
+}
+
+// getLabel returns the majority label of the voxel at the global voxel index.
+// Returns false if the voxel has no votes.
+// Thread-safe.
+func (l *voteLayer[L]) getLabel(globalVoxelIndex IndexType) (L, bool) {
+	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, l.VoxelsPerSideInv)
+	block, ok := l.blocks.get(blockIndex)
+	if !ok {
+		return 0, false
+	}
+	return block.getLabel(getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, l.VoxelsPerSide))
+}
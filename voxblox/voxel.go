@@ -1,54 +1,38 @@
 package voxblox
 
-import "sync"
-
+// TsdfVoxel is a compact voxel of 12 bytes: float32 distance and weight and a packed RGB color.
+// Voxels are stored by value in their block, which synchronizes access to them.
 type TsdfVoxel struct {
-	Index IndexType
-	sync.RWMutex
-	distance float64
-	weight   float64
+	distance float32
+	weight   float32
 	color    Color
 }
 
 func (v *TsdfVoxel) getWeight() float64 {
-	v.RLock()
-	defer v.RUnlock()
-	return v.weight
+	return float64(v.weight)
 }
 
 func (v *TsdfVoxel) setWeight(weight float64) {
-	v.Lock()
-	defer v.Unlock()
-	v.weight = weight
+	v.weight = float32(weight)
 }
 
 func (v *TsdfVoxel) getDistance() float64 {
-	v.RLock()
-	defer v.RUnlock()
-	return v.distance
+	return float64(v.distance)
 }
 
 func (v *TsdfVoxel) setDistance(distance float64) {
-	v.Lock()
-	defer v.Unlock()
-	v.distance = distance
+	v.distance = float32(distance)
 }
 
 func (v *TsdfVoxel) getColor() Color {
-	v.RLock()
-	defer v.RUnlock()
 	return v.color
 }
 
 func (v *TsdfVoxel) setColor(color Color) {
-	v.Lock()
-	defer v.Unlock()
 	v.color = color
 }
 
-func NewVoxel(index IndexType) *TsdfVoxel {
-	return &TsdfVoxel{
-		Index: index,
-		color: Color{127, 127, 127},
-	}
+// isObserved returns true if the voxel has been updated by at least one ray.
+func (v *TsdfVoxel) isObserved() bool {
+	return v.weight > 0
 }
@@ -0,0 +1,40 @@
+package voxblox
+
+// voxelArray is a dense cube of voxels stored by value.
+// Voxels are indexed by their local voxel index within a block.
+type voxelArray[V any] struct {
+	voxelsPerSide int
+	voxels        []V
+}
+
+// newVoxelArray returns a voxelArray of voxelsPerSide^3 zero voxels.
+func newVoxelArray[V any](voxelsPerSide int) voxelArray[V] {
+	return voxelArray[V]{
+		voxelsPerSide: voxelsPerSide,
+		voxels:        make([]V, voxelsPerSide*voxelsPerSide*voxelsPerSide),
+	}
+}
+
+// len returns the number of voxels.
+func (a *voxelArray[V]) len() int {
+	return len(a.voxels)
+}
+
+// linearIndex returns the position of a voxel in the array.
+func (a *voxelArray[V]) linearIndex(voxelIndex IndexType) int {
+	return voxelIndex[0] + a.voxelsPerSide*(voxelIndex[1]+a.voxelsPerSide*voxelIndex[2])
+}
+
+// voxelIndex returns the local voxel index of a position in the array.
+func (a *voxelArray[V]) voxelIndex(linearIndex int) IndexType {
+	return IndexType{
+		linearIndex % a.voxelsPerSide,
+		linearIndex / a.voxelsPerSide % a.voxelsPerSide,
+		linearIndex / (a.voxelsPerSide * a.voxelsPerSide),
+	}
+}
+
+// at returns a pointer to the voxel at the given valid local voxel index.
+func (a *voxelArray[V]) at(voxelIndex IndexType) *V {
+	return &a.voxels[a.linearIndex(voxelIndex)]
+}
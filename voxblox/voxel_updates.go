@@ -0,0 +1,230 @@
+package voxblox
+
+import (
+	"sync"
+	"time"
+)
+
+// voxelUpdate is a single observation of a voxel by a ray.
+type voxelUpdate struct {
+	sdf    float64
+	weight float64
+	color  Color
+	// free is set if the ray observed the voxel as free space.
+	free bool
+}
+
+// blockUpdates holds the voxel updates of a block keyed by local voxel index.
+// Updates of a voxel are kept in the order they were observed.
+type blockUpdates map[IndexType][]voxelUpdate
+
+// updateBuffer holds the voxel updates of an integration worker keyed by block index.
+type updateBuffer map[IndexType]blockUpdates
+
+// indexedVoxelUpdate is a voxel update with the local index of its voxel.
+type indexedVoxelUpdate struct {
+	voxelIndex IndexType
+	update     voxelUpdate
+}
+
+// voxelUpdater applies the voxel updates of an integration worker.
+// With batching enabled the updates are buffered by block and merged into
+// the TSDF Layer after all workers are done, otherwise the consecutive updates
+// of a block are applied directly under a single lock.
+type voxelUpdater struct {
+	layer        *TsdfLayer
+	semantic     *SemanticLayer
+	instance     *InstanceLayer
+	channels     *ChannelLayer
+	observations *ObservationLayer
+	config       *Config
+	buffer       updateBuffer
+	// stamp is the time of the point cloud in Unix seconds, 0 if unknown.
+	stamp uint32
+	// pendingBlock receives the pending direct updates once the worker moves to another block.
+	pendingBlock *TsdfBlock
+	pending      []indexedVoxelUpdate
+}
+
+// newVoxelUpdater creates a voxelUpdater for one integration worker
+// observing voxels at the given point cloud stamp.
+// Labels, instances and channels are fused into the semantic, instance and channel layers
+// and the observations are recorded into the observation layer if they are not nil.
+func newVoxelUpdater(
+	layer *TsdfLayer,
+	semantic *SemanticLayer,
+	instance *InstanceLayer,
+	channels *ChannelLayer,
+	observations *ObservationLayer,
+	config *Config,
+	stamp time.Time,
+) *voxelUpdater {
+	u := &voxelUpdater{
+		layer:        layer,
+		semantic:     semantic,
+		instance:     instance,
+		channels:     channels,
+		observations: observations,
+		config:       config,
+		stamp:        stampToSeconds(stamp),
+	}
+	if config.BatchVoxelUpdates {
+		u.buffer = make(updateBuffer)
+	}
+	return u
+}
+
+// update integrates the observation of a voxel by the ray of the point at index j of the point cloud.
+// Every voxel traversed by a clearing ray is observed as free space.
+// Voxels in the truncation band around the point vote for its label and instance and fuse its channels.
+func (u *voxelUpdater) update(
+	origin Point,
+	pointG Point,
+	globalVoxelIndex IndexType,
+	pointCloud *PointCloud,
+	j int,
+	weight float64,
+	clearing bool,
+) {
+	update := computeVoxelUpdate(u.layer, u.config, origin, pointG, globalVoxelIndex, pointCloud.Colors[j], weight)
+	update.free = update.free || clearing
+
+	// Votes and channels are applied directly, also with batched updates.
+	if !update.free && update.sdf > -u.config.truncationDistance {
+		if label := pointCloud.getLabel(j); u.semantic != nil && label != LabelUnknown {
+			u.semantic.vote(globalVoxelIndex, label)
+		}
+		if instance := pointCloud.getInstance(j); u.instance != nil && instance != InstanceUnknown {
+			u.instance.vote(globalVoxelIndex, instance)
+		}
+		if u.channels != nil && pointCloud.Channels != nil {
+			u.channels.fuse(u.config, globalVoxelIndex, pointCloud, j, update.weight)
+		}
+	}
+
+	if u.buffer == nil {
+		block, voxelIndex := getBlockAndVoxelIndexFromGlobalVoxelIndex(u.layer, globalVoxelIndex)
+		if block != u.pendingBlock {
+			u.flush()
+			u.pendingBlock = block
+		}
+		u.pending = append(u.pending, indexedVoxelUpdate{voxelIndex: voxelIndex, update: update})
+		return
+	}
+
+	blockIndex := getBlockIndexFromGlobalVoxelIndex(globalVoxelIndex, u.layer.VoxelsPerSideInv)
+	voxelIndex := getLocalFromGlobalVoxelIndex(globalVoxelIndex, blockIndex, u.layer.VoxelsPerSide)
+	updates, ok := u.buffer[blockIndex]
+	if !ok {
+		updates = make(blockUpdates)
+		u.buffer[blockIndex] = updates
+	}
+	updates[voxelIndex] = append(updates[voxelIndex], update)
+}
+
+// flush applies the pending direct updates to their block.
+func (u *voxelUpdater) flush() {
+	if len(u.pending) == 0 {
+		return
+	}
+	u.pendingBlock.applyIndexedVoxelUpdates(u.config, u.pending)
+	u.pendingBlock.setUpdated()
+	if u.observations != nil {
+		u.observations.observeIndexedVoxelUpdates(u.pendingBlock.Index, u.stamp, u.pending)
+	}
+	u.pending = u.pending[:0]
+}
+
+// integrateChunks splits the point cloud into one chunk per thread and runs
+// integratePoints on every chunk concurrently.
+// Buffered updates are merged into the TSDF Layer before returning.
+func integrateChunks(
+	layer *TsdfLayer,
+	semantic *SemanticLayer,
+	instance *InstanceLayer,
+	channels *ChannelLayer,
+	observations *ObservationLayer,
+	config *Config,
+	pointCloud PointCloud,
+	integratePoints func(pointCloud PointCloud, updater *voxelUpdater),
+) {
+	if observations != nil {
+		observations.observeCloud(pointCloud.Stamp)
+	}
+	chunks := splitPointCloud(&pointCloud, config.Threads)
+	updaters := make([]*voxelUpdater, len(chunks))
+	wg := sync.WaitGroup{}
+	for j, pC := range chunks {
+		updaters[j] = newVoxelUpdater(layer, semantic, instance, channels, observations, config, pointCloud.Stamp)
+		wg.Add(1)
+		go func(pC PointCloud, updater *voxelUpdater) {
+			defer wg.Done()
+			integratePoints(pC, updater)
+			updater.flush()
+		}(pC, updaters[j])
+	}
+	wg.Wait()
+
+	if config.BatchVoxelUpdates {
+		buffers := make([]updateBuffer, len(updaters))
+		for j, updater := range updaters {
+			buffers[j] = updater.buffer
+		}
+		mergeUpdateBuffers(layer, observations, config, stampToSeconds(pointCloud.Stamp), buffers)
+	}
+}
+
+// mergeUpdateBuffers applies the buffered updates of all workers to the TSDF Layer
+// and records them at the stamp into the observation layer if it is not nil.
+// Every block is owned by a single goroutine which applies the updates of
+// all workers in worker order, so blocks are never contended.
+func mergeUpdateBuffers(
+	layer *TsdfLayer,
+	observations *ObservationLayer,
+	config *Config,
+	stamp uint32,
+	buffers []updateBuffer,
+) {
+	blockIndexSet := make(map[IndexType]struct{})
+	for _, buffer := range buffers {
+		for blockIndex := range buffer {
+			blockIndexSet[blockIndex] = struct{}{}
+		}
+	}
+	blockIndexes := make([]IndexType, 0, len(blockIndexSet))
+	for blockIndex := range blockIndexSet {
+		blockIndexes = append(blockIndexes, blockIndex)
+	}
+
+	owners := minInt(maxInt(config.Threads, 1), maxInt(len(blockIndexes), 1))
+	wg := sync.WaitGroup{}
+	for owner := 0; owner < owners; owner++ {
+		wg.Add(1)
+		go func(owner int) {
+			defer wg.Done()
+			for j := owner; j < len(blockIndexes); j += owners {
+				mergeBlockUpdates(layer, observations, config, stamp, blockIndexes[j], buffers)
+			}
+		}(owner)
+	}
+	wg.Wait()
+}
+
+// mergeBlockUpdates applies the buffered updates of a single block.
+func mergeBlockUpdates(
+	layer *TsdfLayer,
+	observations *ObservationLayer,
+	config *Config,
+	stamp uint32,
+	blockIndex IndexType,
+	buffers []updateBuffer,
+) {
+	block := layer.getBlockByIndex(blockIndex)
+	for _, buffer := range buffers {
+		block.applyBlockUpdates(config, buffer[blockIndex])
+		if observations != nil {
+			observations.observeBlockUpdates(blockIndex, stamp, buffer[blockIndex])
+		}
+	}
+	block.setUpdated()
+}
@@ -0,0 +1,370 @@
+package voxblox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The upstream Voxblox layer file (.vxblx) is a varint message count followed by varint length-delimited
+// protobuf messages: a LayerProto and a BlockProto per block.
+//
+//	message LayerProto {
+//	  optional double voxel_size = 1;
+//	  optional uint32 voxels_per_side = 2;
+//	  optional string type = 3;
+//	}
+//
+//	message BlockProto {
+//	  optional int32 voxels_per_side = 1;
+//	  optional double voxel_size = 2;
+//	  optional double origin_x = 3;
+//	  optional double origin_y = 4;
+//	  optional double origin_z = 5;
+//	  optional bool has_data = 6;
+//	  repeated uint32 voxel_data = 7;
+//	}
+//
+// The voxel data holds three integers per voxel in linear index order: the bits of the float distance and
+// weight and the color packed as RGBA from the most significant byte.
+const (
+	kLayerProtoVoxelSize     protowire.Number = 1
+	kLayerProtoVoxelsPerSide protowire.Number = 2
+	kLayerProtoType          protowire.Number = 3
+
+	kBlockProtoVoxelsPerSide protowire.Number = 1
+	kBlockProtoVoxelSize     protowire.Number = 2
+	kBlockProtoOriginX       protowire.Number = 3
+	kBlockProtoOriginY       protowire.Number = 4
+	kBlockProtoOriginZ       protowire.Number = 5
+	kBlockProtoHasData       protowire.Number = 6
+	kBlockProtoVoxelData     protowire.Number = 7
+)
+
+// kVxblxTsdfLayerType is the layer type of TSDF layers.
+const kVxblxTsdfLayerType = "tsdf"
+
+// kVxblxIntegersPerVoxel is the number of voxel data integers of a TSDF voxel.
+const kVxblxIntegersPerVoxel = 3
+
+// kVoxelSizeTolerance is the relative tolerance of voxel sizes read from files, which may store them as float.
+const kVoxelSizeTolerance = 1e-6
+
+// layerProto is the header message of a .vxblx file.
+type layerProto struct {
+	voxelSize     float64
+	voxelsPerSide uint32
+	layerType     string
+}
+
+// blockProto is a block message of a .vxblx file.
+type blockProto struct {
+	voxelsPerSide int32
+	voxelSize     float64
+	origin        Point
+	hasData       bool
+	voxelData     []uint32
+}
+
+// marshal appends the encoded layer message to b.
+func (m *layerProto) marshal(b []byte) []byte {
+	b = protowire.AppendTag(b, kLayerProtoVoxelSize, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(m.voxelSize))
+	b = protowire.AppendTag(b, kLayerProtoVoxelsPerSide, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.voxelsPerSide))
+	b = protowire.AppendTag(b, kLayerProtoType, protowire.BytesType)
+	return protowire.AppendString(b, m.layerType)
+}
+
+// unmarshal decodes the layer message, skipping unknown fields.
+func (m *layerProto) unmarshal(b []byte) error {
+	return consumeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == kLayerProtoVoxelSize && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			m.voxelSize = math.Float64frombits(v)
+			return n, nil
+		case num == kLayerProtoVoxelsPerSide && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.voxelsPerSide = uint32(v)
+			return n, nil
+		case num == kLayerProtoType && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			m.layerType = v
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+// marshal appends the encoded block message to b, with packed voxel data.
+func (m *blockProto) marshal(b []byte) []byte {
+	b = protowire.AppendTag(b, kBlockProtoVoxelsPerSide, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.voxelsPerSide))
+	b = protowire.AppendTag(b, kBlockProtoVoxelSize, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(m.voxelSize))
+	for k, num := range []protowire.Number{kBlockProtoOriginX, kBlockProtoOriginY, kBlockProtoOriginZ} {
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(m.origin[k]))
+	}
+	b = protowire.AppendTag(b, kBlockProtoHasData, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(m.hasData))
+
+	size := 0
+	for _, v := range m.voxelData {
+		size += protowire.SizeVarint(uint64(v))
+	}
+	b = protowire.AppendTag(b, kBlockProtoVoxelData, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(size))
+	for _, v := range m.voxelData {
+		b = protowire.AppendVarint(b, uint64(v))
+	}
+	return b
+}
+
+// unmarshal decodes the block message, accepting packed and unpacked voxel data and skipping unknown fields.
+func (m *blockProto) unmarshal(b []byte) error {
+	origins := map[protowire.Number]int{kBlockProtoOriginX: 0, kBlockProtoOriginY: 1, kBlockProtoOriginZ: 2}
+	return consumeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		k, isOrigin := origins[num]
+		switch {
+		case num == kBlockProtoVoxelsPerSide && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.voxelsPerSide = int32(v)
+			return n, nil
+		case num == kBlockProtoVoxelSize && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			m.voxelSize = math.Float64frombits(v)
+			return n, nil
+		case isOrigin && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			m.origin[k] = math.Float64frombits(v)
+			return n, nil
+		case num == kBlockProtoHasData && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.hasData = protowire.DecodeBool(v)
+			return n, nil
+		case num == kBlockProtoVoxelData && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.voxelData = append(m.voxelData, uint32(v))
+			return n, nil
+		case num == kBlockProtoVoxelData && typ == protowire.BytesType:
+			packed, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			for len(packed) > 0 {
+				v, m2 := protowire.ConsumeVarint(packed)
+				if m2 < 0 {
+					return 0, protowire.ParseError(m2)
+				}
+				m.voxelData = append(m.voxelData, uint32(v))
+				packed = packed[m2:]
+			}
+			return n, nil
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+}
+
+// consumeProtoFields calls consume with the number, type and encoded value of every field of the message.
+// consume returns the length of the value or a negative protowire error code.
+func consumeProtoFields(
+	b []byte,
+	consume func(num protowire.Number, typ protowire.Type, b []byte) (int, error),
+) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n, err := consume(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// writeDelimitedMessage writes a varint length-delimited message.
+func writeDelimitedMessage(w io.Writer, message []byte) error {
+	if _, err := w.Write(protowire.AppendVarint(nil, uint64(len(message)))); err != nil {
+		return err
+	}
+	_, err := w.Write(message)
+	return err
+}
+
+// readDelimitedMessage reads a varint length-delimited message.
+func readDelimitedMessage(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, size)
+	_, err = io.ReadFull(r, message)
+	return message, err
+}
+
+// WriteTsdfLayerProto writes all blocks of a TSDF Layer to a file in the .vxblx protobuf format of upstream Voxblox.
+// Thread-safe.
+func WriteTsdfLayerProto(layer *TsdfLayer, fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	blocks := make([]*TsdfBlock, 0, layer.GetBlockCount())
+	for _, block := range layer.getBlocks() {
+		blocks = append(blocks, block)
+	}
+	slices.SortFunc(blocks, func(a, b *TsdfBlock) int {
+		return slices.Compare(a.Index[:], b.Index[:])
+	})
+
+	if _, err := w.Write(protowire.AppendVarint(nil, uint64(1+len(blocks)))); err != nil {
+		return err
+	}
+	header := layerProto{
+		voxelSize:     layer.VoxelSize,
+		voxelsPerSide: uint32(layer.VoxelsPerSide),
+		layerType:     kVxblxTsdfLayerType,
+	}
+	if err := writeDelimitedMessage(w, header.marshal(nil)); err != nil {
+		return err
+	}
+
+	var message []byte
+	for _, block := range blocks {
+		proto := blockProto{
+			voxelsPerSide: int32(block.VoxelsPerSide),
+			voxelSize:     block.VoxelSize,
+			origin:        block.Origin,
+		}
+		block.RLock()
+		proto.voxelData = make([]uint32, 0, block.voxels.len()*kVxblxIntegersPerVoxel)
+		for _, voxel := range block.voxels.voxels {
+			proto.hasData = proto.hasData || voxel.isObserved()
+			color := voxel.getColor()
+			proto.voxelData = append(
+				proto.voxelData,
+				math.Float32bits(voxel.distance),
+				math.Float32bits(voxel.weight),
+				uint32(color[0])<<24|uint32(color[1])<<16|uint32(color[2])<<8|0xff,
+			)
+		}
+		block.RUnlock()
+
+		message = proto.marshal(message[:0])
+		if err := writeDelimitedMessage(w, message); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// ReadTsdfLayerProto reads a TSDF layer file in the .vxblx protobuf format of upstream Voxblox into a TSDF Layer.
+// The voxel size and voxels per side of the file must match the layer.
+// Blocks in the file replace existing blocks with the same index.
+// Thread-safe.
+func ReadTsdfLayerProto(layer *TsdfLayer, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%s has no layer header", fileName)
+	}
+	message, err := readDelimitedMessage(r)
+	if err != nil {
+		return err
+	}
+	var header layerProto
+	if err := header.unmarshal(message); err != nil {
+		return err
+	}
+	if header.layerType != kVxblxTsdfLayerType {
+		return fmt.Errorf("%s is a %q layer, not a TSDF layer", fileName, header.layerType)
+	}
+	if !isSameVoxelSize(header.voxelSize, layer.VoxelSize) || int(header.voxelsPerSide) != layer.VoxelsPerSide {
+		return fmt.Errorf(
+			"layer mismatch: file has voxel size %f and %d voxels per side",
+			header.voxelSize,
+			header.voxelsPerSide,
+		)
+	}
+
+	voxelCount := layer.VoxelsPerSide * layer.VoxelsPerSide * layer.VoxelsPerSide
+	blocks := make([]*TsdfBlock, 0, count-1)
+	for j := uint64(1); j < count; j++ {
+		message, err := readDelimitedMessage(r)
+		if err != nil {
+			return err
+		}
+		var proto blockProto
+		if err := proto.unmarshal(message); err != nil {
+			return err
+		}
+		if !isSameVoxelSize(proto.voxelSize, layer.VoxelSize) || int(proto.voxelsPerSide) != layer.VoxelsPerSide {
+			return fmt.Errorf("block %d does not match the layer", j)
+		}
+		if len(proto.voxelData) != voxelCount*kVxblxIntegersPerVoxel {
+			return fmt.Errorf("block %d has %d voxel integers instead of %d",
+				j, len(proto.voxelData), voxelCount*kVxblxIntegersPerVoxel)
+		}
+
+		blockIndex := getGridIndexFromOriginPoint(proto.origin, layer.BlockSizeInv)
+		block := NewTsdfBlock(
+			layer,
+			blockIndex,
+			getOriginPointFromGridIndex(blockIndex, layer.BlockSize),
+		)
+		for v := range block.voxels.voxels {
+			data := proto.voxelData[v*kVxblxIntegersPerVoxel:]
+			var voxel TsdfVoxel
+			voxel.setWeight(float64(math.Float32frombits(data[1])))
+			if !voxel.isObserved() {
+				continue
+			}
+			voxel.setDistance(float64(math.Float32frombits(data[0])))
+			voxel.setColor(Color{uint8(data[2] >> 24), uint8(data[2] >> 16), uint8(data[2] >> 8)})
+			block.voxels.voxels[v] = voxel
+		}
+		blocks = append(blocks, block)
+	}
+
+	// Only modify the layer once the whole file has been read.
+	for _, block := range blocks {
+		layer.blocks.set(block.Index, block)
+	}
+	return nil
+}
+
+// isSameVoxelSize returns whether the voxel size of a file matches the voxel size of a layer.
+func isSameVoxelSize(fileVoxelSize, voxelSize float64) bool {
+	return math.Abs(fileVoxelSize-voxelSize) <= kVoxelSizeTolerance*voxelSize
+}
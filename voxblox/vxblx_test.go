@@ -0,0 +1,104 @@
+package voxblox
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestWriteReadTsdfLayerProto(t *testing.T) {
+	tsdfLayer := NewTsdfLayer(0.1, 8)
+	for x := -10; x < 10; x++ {
+		setTestVoxel(
+			tsdfLayer,
+			IndexType{x, 2, -3},
+			float64(x)*0.01,
+			float64(x+11),
+			Color{uint8(x + 10), 1, 2},
+		)
+	}
+	fileName := filepath.Join(t.TempDir(), "map.vxblx")
+	assert.NoError(t, WriteTsdfLayerProto(tsdfLayer, fileName))
+
+	loadedLayer := NewTsdfLayer(0.1, 8)
+	assert.NoError(t, ReadTsdfLayerProto(loadedLayer, fileName))
+	assert.Equal(t, tsdfLayer.GetBlockCount(), loadedLayer.GetBlockCount())
+	for x := -10; x < 10; x++ {
+		voxel := getTestVoxel(t, loadedLayer, IndexType{x, 2, -3})
+		assert.Equal(t, float64(float32(float64(x)*0.01)), voxel.getDistance())
+		assert.Equal(t, float64(x+11), voxel.getWeight())
+		assert.Equal(t, Color{uint8(x + 10), 1, 2}, voxel.getColor())
+	}
+	block := loadedLayer.getBlockIfExists(IndexType{0, 0, -1})
+	assert.NotNil(t, block)
+	assert.Len(t, block.getVoxels(), 8)
+
+	// Layers with a different voxel size are rejected.
+	assert.Error(t, ReadTsdfLayerProto(NewTsdfLayer(0.05, 8), fileName))
+	assert.Error(t, ReadTsdfLayerProto(NewTsdfLayer(0.1, 16), fileName))
+}
+
+func TestReadTsdfLayerProtoUpstream(t *testing.T) {
+	// Upstream stores the voxel size as float and may write unpacked voxel data.
+	voxelSize := float64(float32(0.1))
+	var layer []byte
+	layer = protowire.AppendTag(layer, kLayerProtoVoxelSize, protowire.Fixed64Type)
+	layer = protowire.AppendFixed64(layer, math.Float64bits(voxelSize))
+	layer = protowire.AppendTag(layer, kLayerProtoVoxelsPerSide, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, 2)
+	layer = protowire.AppendTag(layer, kLayerProtoType, protowire.BytesType)
+	layer = protowire.AppendString(layer, "tsdf")
+
+	var block []byte
+	block = protowire.AppendTag(block, kBlockProtoVoxelsPerSide, protowire.VarintType)
+	block = protowire.AppendVarint(block, 2)
+	block = protowire.AppendTag(block, kBlockProtoVoxelSize, protowire.Fixed64Type)
+	block = protowire.AppendFixed64(block, math.Float64bits(voxelSize))
+	for k, num := range []protowire.Number{kBlockProtoOriginX, kBlockProtoOriginY, kBlockProtoOriginZ} {
+		block = protowire.AppendTag(block, num, protowire.Fixed64Type)
+		block = protowire.AppendFixed64(block, math.Float64bits(float64(float32([3]float64{-0.2, 0, 0.4}[k]))))
+	}
+	// Unknown fields are skipped.
+	block = protowire.AppendTag(block, 42, protowire.BytesType)
+	block = protowire.AppendString(block, "unknown")
+	for v := 0; v < 8; v++ {
+		var distance, weight float32
+		var color uint32
+		if v == 5 {
+			distance, weight, color = -0.05, 3, 0x00ff00ff
+		}
+		for _, value := range []uint32{math.Float32bits(distance), math.Float32bits(weight), color} {
+			block = protowire.AppendTag(block, kBlockProtoVoxelData, protowire.VarintType)
+			block = protowire.AppendVarint(block, uint64(value))
+		}
+	}
+
+	data := protowire.AppendVarint(nil, 2)
+	for _, message := range [][]byte{layer, block} {
+		data = protowire.AppendBytes(data, message)
+	}
+	fileName := filepath.Join(t.TempDir(), "upstream.vxblx")
+	assert.NoError(t, os.WriteFile(fileName, data, 0o644))
+
+	tsdfLayer := NewTsdfLayer(0.1, 2)
+	assert.NoError(t, ReadTsdfLayerProto(tsdfLayer, fileName))
+	assert.Equal(t, 1, tsdfLayer.GetBlockCount())
+	// Linear index 5 is voxel {1, 0, 1} of block {-1, 0, 2}.
+	voxel := getTestVoxel(t, tsdfLayer, IndexType{-1, 0, 5})
+	assert.InDelta(t, -0.05, voxel.getDistance(), 1e-6)
+	assert.Equal(t, 3.0, voxel.getWeight())
+	assert.Equal(t, Color{0, 255, 0}, voxel.getColor())
+	block0 := tsdfLayer.getBlockIfExists(IndexType{-1, 0, 2})
+	assert.NotNil(t, block0)
+	assert.Len(t, block0.getVoxels(), 1)
+
+	// Truncated files do not modify the layer.
+	assert.NoError(t, os.WriteFile(fileName, data[:len(data)-4], 0o644))
+	tsdfLayer = NewTsdfLayer(0.1, 2)
+	assert.Error(t, ReadTsdfLayerProto(tsdfLayer, fileName))
+	assert.Equal(t, 0, tsdfLayer.GetBlockCount())
+}